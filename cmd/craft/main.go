@@ -0,0 +1,12 @@
+// Command craft is the standalone form of 'osi-replay craft'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("craft", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command diff is the standalone form of 'osi-replay diff'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("diff", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command export is the standalone form of 'osi-replay export'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("export", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command flows is the standalone form of 'osi-replay flows'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("flows", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command generate is the standalone form of 'osi-replay generate'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("generate", os.Args[1:]))
+}
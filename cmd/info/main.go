@@ -0,0 +1,12 @@
+// Command info is the standalone form of 'osi-replay info'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("info", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command merge is the standalone form of 'osi-replay merge'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("merge", os.Args[1:]))
+}
@@ -0,0 +1,13 @@
+// Command osi-replay runs the capture, replay, transform and analysis
+// tools as subcommands. Run 'osi-replay help' for the list.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.Main(os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command slice is the standalone form of 'osi-replay slice'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("slice", os.Args[1:]))
+}
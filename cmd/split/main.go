@@ -0,0 +1,12 @@
+// Command split is the standalone form of 'osi-replay split'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("split", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command stats is the standalone form of 'osi-replay stats'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("stats", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command streams is the standalone form of 'osi-replay streams'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("streams", os.Args[1:]))
+}
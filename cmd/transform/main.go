@@ -1,27 +1,12 @@
+// Command transform is the standalone form of 'osi-replay transform'.
 package main
 
 import (
-	"flag"
-	"fmt"
+	"os"
 
-	"osi-replay/pkg/common"
-	"osi-replay/pkg/transform"
+	"osi-replay/internal/cli"
 )
 
 func main() {
-	var (
-		inFile  string
-		outFile string
-	)
-	flag.StringVar(&inFile, "in", "capture.pcap", "Input PCAP file")
-	flag.StringVar(&outFile, "out", "sanitized_capture.pcap", "Output PCAP file")
-	flag.Parse()
-
-	logger := common.NewLogger("transform-cmd")
-	logger.Info(fmt.Sprintf("Transforming %s -> %s", inFile, outFile))
-
-	if err := transform.Run(inFile, outFile, logger); err != nil {
-		logger.Fatal(err)
-	}
-	logger.Info("Transformation complete.")
+	os.Exit(cli.RunCommand("transform", os.Args[1:]))
 }
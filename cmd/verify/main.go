@@ -0,0 +1,12 @@
+// Command verify is the standalone form of 'osi-replay verify'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("verify", os.Args[1:]))
+}
@@ -0,0 +1,12 @@
+// Command workflow is the standalone form of 'osi-replay workflow'.
+package main
+
+import (
+	"os"
+
+	"osi-replay/internal/cli"
+)
+
+func main() {
+	os.Exit(cli.RunCommand("workflow", os.Args[1:]))
+}
@@ -2,7 +2,10 @@ module osi-replay
 
 go 1.23.1
 
-require github.com/google/gopacket v1.1.19
+require (
+	github.com/google/gopacket v1.1.19
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
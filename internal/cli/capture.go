@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/capture"
+	"osi-replay/pkg/common"
+)
+
+var captureCmd = &Command{
+	Name:    "capture",
+	Summary: "Capture live traffic from an interface into a pcap or pcapng file.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		cfg := &common.CaptureConfig{
+			Promiscuous: true,
+			SnapLen:     65535,
+		}
+		fs.StringVar(&cfg.InterfaceName, "i", "eth0", "Network interface to capture on")
+		fs.StringVar(&cfg.PcapFile, "out", "capture.pcap", "Output PCAP or PCAPNG file")
+		alias(fs, "out", "o")
+		fs.DurationVar(&cfg.DedupWindow, "dedup", 0, "Drop duplicate frames seen within this window (0 disables)")
+		var (
+			opts    capture.Options
+			metrics string
+		)
+		fs.Int64Var(&opts.RotateSize, "rotate-size", 0, "Start a new numbered output file after this many bytes (0 disables)")
+		fs.DurationVar(&opts.RotateInterval, "rotate-interval", 0, "Start a new numbered output file after this much capture time (0 disables)")
+		fs.StringVar(&metrics, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9100")
+
+		return func(env *Env) error {
+			if opts.RotateSize < 0 || opts.RotateInterval < 0 {
+				return usageErrorf("-rotate-size and -rotate-interval must not be negative")
+			}
+			reg, stop, err := serveMetrics(env, metrics)
+			if err != nil {
+				return err
+			}
+			defer stop()
+			if reg != nil {
+				opts.Metrics = capture.NewMetrics(reg)
+			}
+
+			// The interface is the input; rotated outputs log their own file.
+			logger := env.Logger.With("interface", cfg.InterfaceName)
+			logger.Info("Starting capture", "out", cfg.PcapFile)
+			if err := capture.CapturePacketsWithOptions(cfg, &opts, logger); err != nil {
+				return err
+			}
+			logger.Info("Capture complete.")
+			return nil
+		}
+	},
+}
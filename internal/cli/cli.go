@@ -0,0 +1,341 @@
+// Package cli implements the osi-replay command line: the osi-replay
+// entry point with its subcommands, and the single-command binaries under
+// cmd/ that share the same flags and behaviour.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/metrics"
+)
+
+// Exit codes shared by every command. As with diff(1), 1 is reserved for
+// checks that ran and failed (diff found differences, verify found loss),
+// so scripts can tell that apart from an error.
+const (
+	ExitOK     = 0
+	ExitFailed = 1
+	ExitError  = 2
+)
+
+// ErrCheckFailed is returned by commands whose check ran and failed; it
+// maps to ExitFailed and is not logged.
+var ErrCheckFailed = errors.New("check failed")
+
+// Env is what a command needs at run time.
+type Env struct {
+	// Args are the positional arguments left after flag parsing.
+	Args   []string
+	Logger *common.Logger
+	Stdout io.Writer
+}
+
+// Command is one osi-replay subcommand.
+type Command struct {
+	Name    string
+	Summary string
+	// Args describes the positional arguments for the usage line.
+	Args string
+	// Setup registers the command's flags on fs and returns the function
+	// that runs it once they are parsed.
+	Setup func(fs *flag.FlagSet) func(env *Env) error
+}
+
+// Commands lists every subcommand in the order help shows them.
+var Commands = []*Command{
+	captureCmd,
+	replayCmd,
+	transformCmd,
+	rewriterCmd,
+	verifyCmd,
+	workflowCmd,
+	streamsCmd,
+	infoCmd,
+	statsCmd,
+	flowsCmd,
+	exportCmd,
+	diffCmd,
+	mergeCmd,
+	splitCmd,
+	sliceCmd,
+	craftCmd,
+	generateCmd,
+}
+
+// Lookup returns the command called name, or nil.
+func Lookup(name string) *Command {
+	for _, c := range Commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// globals are the flags every command accepts.
+type globals struct {
+	logLevel  string
+	logFormat string
+	quiet     bool
+	verbose   bool
+	config    string
+}
+
+func newGlobals() *globals {
+	return &globals{logLevel: common.LevelInfo, logFormat: common.LogText}
+}
+
+func (g *globals) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.logLevel, "log-level", g.logLevel, "Log level: debug, info, warn or error")
+	fs.StringVar(&g.logFormat, "log-format", g.logFormat, "Log format: text or json")
+	fs.BoolVar(&g.quiet, "quiet", g.quiet, "Only log errors (same as -log-level error)")
+	fs.BoolVar(&g.verbose, "verbose", g.verbose, "Log debug messages too (same as -log-level debug)")
+	fs.StringVar(&g.config, "config", g.config, "YAML file with default flag values")
+}
+
+// setupLogging applies the logging flags.
+func (g *globals) setupLogging() error {
+	level := g.logLevel
+	switch {
+	case g.quiet && g.verbose:
+		return errors.New("-quiet and -verbose cannot be combined")
+	case g.quiet:
+		level = common.LevelError
+	case g.verbose:
+		level = common.LevelDebug
+	}
+	if err := common.SetLevel(level); err != nil {
+		return err
+	}
+	return common.SetOutput(os.Stderr, g.logFormat)
+}
+
+// usageError is an error caused by how the command was invoked; the
+// command's usage is printed with it.
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Main runs the osi-replay entry point with args (without the program
+// name) and returns the exit code.
+func Main(args []string) int {
+	g := newGlobals()
+	top := flag.NewFlagSet("osi-replay", flag.ContinueOnError)
+	g.register(top)
+	top.Usage = func() { printMainUsage(top) }
+	if err := top.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	set := visited(top)
+
+	rest := top.Args()
+	if len(rest) == 0 {
+		printMainUsage(top)
+		return ExitError
+	}
+	name, rest := rest[0], rest[1:]
+	if name == "help" {
+		if len(rest) == 0 {
+			printMainUsage(top)
+			return ExitOK
+		}
+		cmd := Lookup(rest[0])
+		if cmd == nil {
+			fmt.Fprintf(top.Output(), "osi-replay: unknown command %q\n", rest[0])
+			return ExitError
+		}
+		fs, _ := newFlagSet(cmd, "osi-replay "+cmd.Name, g)
+		fs.Usage()
+		return ExitOK
+	}
+	cmd := Lookup(name)
+	if cmd == nil {
+		fmt.Fprintf(top.Output(), "osi-replay: unknown command %q\n\n", name)
+		printMainUsage(top)
+		return ExitError
+	}
+	return run(cmd, "osi-replay "+name, rest, g, set)
+}
+
+// RunCommand runs the single command called name with args, as the
+// binaries under cmd/ do, and returns the exit code.
+func RunCommand(name string, args []string) int {
+	cmd := Lookup(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		return ExitError
+	}
+	return run(cmd, name, args, newGlobals(), nil)
+}
+
+func newFlagSet(cmd *Command, prog string, g *globals) (*flag.FlagSet, func(*Env) error) {
+	fs := flag.NewFlagSet(prog, flag.ContinueOnError)
+	runner := cmd.Setup(fs)
+	g.register(fs)
+	fs.Usage = func() { printUsage(fs, cmd, prog) }
+	return fs, runner
+}
+
+// run parses args for cmd and runs it. set holds global flags already
+// given before the command name.
+func run(cmd *Command, prog string, args []string, g *globals, set map[string]bool) int {
+	fs, runner := newFlagSet(cmd, prog, g)
+	if err := fs.Parse(args); err != nil {
+		return parseExit(err)
+	}
+	for name := range visited(fs) {
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[name] = true
+	}
+
+	if g.config != "" {
+		if err := applyConfig(fs, cmd.Name, g.config, set); err != nil {
+			fmt.Fprintf(fs.Output(), "%s: %v\n", prog, err)
+			return ExitError
+		}
+	}
+
+	if err := g.setupLogging(); err != nil {
+		fmt.Fprintf(fs.Output(), "%s: %v\n", prog, err)
+		return ExitError
+	}
+
+	logger := common.NewLogger(cmd.Name)
+	err := runner(&Env{Args: fs.Args(), Logger: logger, Stdout: os.Stdout})
+	var ue *usageError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrCheckFailed):
+		return ExitFailed
+	case errors.As(err, &ue):
+		fmt.Fprintf(fs.Output(), "%s: %v\n", prog, err)
+		fs.Usage()
+		return ExitError
+	default:
+		logger.Error(err)
+		return ExitError
+	}
+}
+
+func parseExit(err error) int {
+	if err == flag.ErrHelp {
+		return ExitOK
+	}
+	return ExitError
+}
+
+func visited(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// alias registers old as another name for the existing flag name, so
+// scripts using a command's earlier flag names keep working. Aliases have
+// no usage text and are left out of help.
+func alias(fs *flag.FlagSet, name, old string) {
+	fs.Var(fs.Lookup(name).Value, old, "")
+}
+
+func printMainUsage(top *flag.FlagSet) {
+	w := top.Output()
+	fmt.Fprintf(w, "Usage: osi-replay [global flags] <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range Commands {
+		fmt.Fprintf(w, "  %-10s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "\nGlobal flags (also accepted after the command):\n")
+	printFlags(w, top)
+	fmt.Fprintf(w, "\nRun 'osi-replay help <command>' for the flags of a command.\n")
+	fmt.Fprintf(w, "Exit status: %d on success, %d when diff or verify finds a difference, %d on error.\n",
+		ExitOK, ExitFailed, ExitError)
+}
+
+func printUsage(fs *flag.FlagSet, cmd *Command, prog string) {
+	w := fs.Output()
+	usage := "Usage: " + prog + " [flags]"
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	fmt.Fprintf(w, "%s\n\n%s\n\nFlags:\n", usage, cmd.Summary)
+	printFlags(w, fs)
+}
+
+// printFlags prints fs's flags in the style of flag.PrintDefaults,
+// skipping aliases.
+func printFlags(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Usage == "" {
+			return
+		}
+		typ, usage := flag.UnquoteUsage(f)
+		line := "  -" + f.Name
+		if typ != "" {
+			line += " " + typ
+		}
+		line += "\n    \t" + strings.ReplaceAll(usage, "\n", "\n    \t")
+		switch f.DefValue {
+		case "", "0", "0s", "false":
+		default:
+			if typ == "string" {
+				line += fmt.Sprintf(" (default %q)", f.DefValue)
+			} else {
+				line += fmt.Sprintf(" (default %v)", f.DefValue)
+			}
+		}
+		fmt.Fprintln(w, line)
+	})
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// serveMetrics starts the metrics listener when addr is set and returns
+// the registry to register metrics with (nil when disabled) and a function
+// that stops the listener.
+func serveMetrics(env *Env, addr string) (*metrics.Registry, func(), error) {
+	if addr == "" {
+		return nil, func() {}, nil
+	}
+	reg := metrics.NewRegistry()
+	srv, err := metrics.Serve(addr, reg, env.Logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reg, func() { srv.Close() }, nil
+}
+
+// createOutput returns a writer for path, or stdout when path is empty.
+// The returned close function must be called when done.
+func createOutput(env *Env, path string) (io.Writer, func() error, error) {
+	if path == "" {
+		return env.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating output file: %w", err)
+	}
+	return f, f.Close, nil
+}
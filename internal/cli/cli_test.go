@@ -0,0 +1,105 @@
+package cli_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"osi-replay/internal/cli"
+)
+
+func TestMainExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.pcap")
+	b := filepath.Join(dir, "b.pcap")
+
+	cases := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"generate a", []string{"-log-level", "warn", "generate", "-out", a, "-count", "3"}, cli.ExitOK},
+		{"generate b", []string{"generate", "-out", b, "-count", "3", "-seed", "2"}, cli.ExitOK},
+		{"same", []string{"diff", "-q", a, a}, cli.ExitOK},
+		{"different", []string{"diff", "-q", a, b}, cli.ExitFailed},
+		{"missing file", []string{"diff", a, filepath.Join(dir, "missing.pcap")}, cli.ExitError},
+		{"missing args", []string{"diff", a}, cli.ExitError},
+		{"slice without selection", []string{"slice", "-in", a}, cli.ExitError},
+		{"decap comment to pcap", []string{"transform", "-in", a, "-out", b, "-decap", "-decap-keep-id", "comment"}, cli.ExitError},
+		{"negative speed", []string{"replay", "-in", a, "-out", b, "-speed", "-1"}, cli.ExitError},
+		{"bad metrics address", []string{"replay", "-in", a, "-out", b, "-metrics-addr", "invalid:address:x"}, cli.ExitError},
+		{"unknown command", []string{"nope"}, cli.ExitError},
+		{"unknown flag", []string{"info", "-nope"}, cli.ExitError},
+		{"bad log level", []string{"-log-level", "loud", "info", "-in", a}, cli.ExitError},
+		{"json logs", []string{"-log-format", "json", "info", "-quiet", "-in", a}, cli.ExitOK},
+		{"bad log format", []string{"info", "-log-format", "xml", "-in", a}, cli.ExitError},
+		{"quiet and verbose", []string{"-quiet", "info", "-verbose", "-in", a}, cli.ExitError},
+		{"help", []string{"help", "replay"}, cli.ExitOK},
+		{"command help", []string{"info", "-h"}, cli.ExitOK},
+		{"no command", nil, cli.ExitError},
+	}
+	for _, tc := range cases {
+		if got := cli.Main(tc.args); got != tc.want {
+			t.Errorf("%s: exit %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRunCommandAliases(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "spec.yaml")
+	out := filepath.Join(dir, "out.pcap")
+	yaml := "packets:\n  - ethernet: {}\n    ipv4: {src: 10.0.0.1, dst: 10.0.0.2}\n    udp: {src: 1, dst: 2}\n"
+	if err := os.WriteFile(spec, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// craft's earlier -spec flag still works alongside -in.
+	if got := cli.RunCommand("craft", []string{"-spec", spec, "-out", out}); got != cli.ExitOK {
+		t.Fatalf("craft -spec: exit %d", got)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Fatalf("craft did not write %s: %v", out, err)
+	}
+	if got := cli.RunCommand("replay", []string{"-f", out, "-o", filepath.Join(dir, "copy.pcap")}); got != cli.ExitOK {
+		t.Fatalf("replay -f/-o: exit %d", got)
+	}
+	if got := cli.RunCommand("diff", []string{"-q", out, filepath.Join(dir, "copy.pcap")}); got != cli.ExitOK {
+		t.Errorf("replayed copy differs: exit %d", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.pcap")
+	b := filepath.Join(dir, "b.pcap")
+	cfg := filepath.Join(dir, "osi-replay.yaml")
+	if err := os.WriteFile(cfg, []byte("log-level: warn\ngenerate:\n  count: 3\n  seed: 2\n  kinds: [tcp, dns]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cli.Main([]string{"-config", cfg, "generate", "-out", a}); got != cli.ExitOK {
+		t.Fatalf("generate with config: exit %d", got)
+	}
+	if got := cli.Main([]string{"generate", "-out", b, "-count", "3", "-seed", "2", "-kinds", "tcp,dns"}); got != cli.ExitOK {
+		t.Fatalf("generate with flags: exit %d", got)
+	}
+	if got := cli.Main([]string{"diff", "-q", a, b}); got != cli.ExitOK {
+		t.Errorf("config values were not applied: diff exit %d", got)
+	}
+
+	// Flags on the command line win over the config file.
+	if got := cli.Main([]string{"generate", "-config", cfg, "-out", a, "-seed", "3"}); got != cli.ExitOK {
+		t.Fatalf("generate with override: exit %d", got)
+	}
+	if got := cli.Main([]string{"diff", "-q", a, b}); got != cli.ExitFailed {
+		t.Errorf("command-line -seed did not override the config: diff exit %d", got)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(bad, []byte("generate:\n  nope: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.Main([]string{"-config", bad, "generate", "-out", a}); got != cli.ExitError {
+		t.Errorf("unknown config flag: exit %d, want %d", got, cli.ExitError)
+	}
+}
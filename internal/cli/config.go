@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfig sets the flags of fs that are not in set from the YAML file
+// at path. Top-level scalar keys apply to every command (e.g. log-level);
+// a mapping named after a command holds that command's flags:
+//
+//	log-level: warn
+//	capture:
+//	  i: eth1
+//	  dedup: 1s
+//	transform:
+//	  vlan-remap: [100=200, 300=400]
+//
+// Lists are joined with commas.
+func applyConfig(fs *flag.FlagSet, command, path string, set map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config: %w", err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("error parsing config %s: %w", path, err)
+	}
+
+	global := flag.NewFlagSet("", flag.ContinueOnError)
+	(&globals{}).register(global)
+
+	values := make(map[string]any)
+	for key, v := range doc {
+		if Lookup(key) != nil {
+			continue
+		}
+		if global.Lookup(key) == nil {
+			return fmt.Errorf("config %s: %q is neither a command nor a global flag", path, key)
+		}
+		values[key] = v
+	}
+	if section, ok := doc[command]; ok {
+		m, ok := section.(map[string]any)
+		if !ok {
+			return fmt.Errorf("config %s: %q must be a mapping of flag names to values", path, command)
+		}
+		for key, v := range m {
+			values[key] = v
+		}
+	}
+
+	for key, v := range values {
+		if key == "config" {
+			return fmt.Errorf("config %s: config files cannot include other config files", path)
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("config %s: %s has no flag -%s", path, command, key)
+		}
+		if set[key] {
+			continue
+		}
+		if err := fs.Set(key, configValue(v)); err != nil {
+			return fmt.Errorf("config %s: invalid value for -%s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+func configValue(v any) string {
+	list, ok := v.([]any)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/craft"
+)
+
+var craftCmd = &Command{
+	Name:    "craft",
+	Summary: "Build a pcap from a YAML or JSON packet spec.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			specFile string
+			outFile  string
+		)
+		fs.StringVar(&specFile, "in", "packets.yaml", "YAML or JSON packet spec")
+		alias(fs, "in", "spec")
+		fs.StringVar(&outFile, "out", "crafted.pcap", "Output PCAP or PCAPNG file")
+
+		return func(env *Env) error {
+			spec, err := craft.Load(specFile)
+			if err != nil {
+				return err
+			}
+			_, err = craft.WriteFile(spec, outFile, env.Logger)
+			return err
+		}
+	},
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/diff"
+)
+
+var diffCmd = &Command{
+	Name:    "diff",
+	Summary: "Compare two captures packet by packet and field by field.",
+	Args:    "a.pcap b.pcap",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			opts   diff.Options
+			ignore string
+			asJSON bool
+			quiet  bool
+		)
+		fs.StringVar(&opts.Align, "align", diff.AlignIndex, "Packet alignment: 'index' or 'hash' (ignores addresses and checksums)")
+		fs.StringVar(&ignore, "ignore", "", "Comma-separated layers or Layer.Field names to skip, e.g. Frame.Timestamp,IPv4.Checksum")
+		fs.BoolVar(&asJSON, "json", false, "Print the report as JSON")
+		fs.BoolVar(&quiet, "q", false, "Only print the summary")
+
+		return func(env *Env) error {
+			if len(env.Args) != 2 {
+				return usageErrorf("two captures are required")
+			}
+			opts.Ignore = splitList(ignore)
+
+			res, err := diff.Compare(env.Args[0], env.Args[1], &opts)
+			if err != nil {
+				return err
+			}
+			if quiet {
+				res.Modified, res.OnlyA, res.OnlyB = nil, nil, nil
+			}
+			if asJSON {
+				err = writeJSON(env, res)
+			} else {
+				err = res.WriteText(env.Stdout)
+			}
+			if err != nil {
+				return err
+			}
+			if !res.Equal() {
+				return ErrCheckFailed
+			}
+			return nil
+		}
+	},
+}
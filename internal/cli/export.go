@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/export"
+)
+
+var exportCmd = &Command{
+	Name:    "export",
+	Summary: "Write decoded packets as NDJSON, a JSON array or CSV.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile  string
+			outFile string
+			fields  string
+			opts    export.Options
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.StringVar(&outFile, "out", "", "Output file (default stdout)")
+		fs.StringVar(&opts.Format, "format", export.FormatNDJSON, "Output format: ndjson, json or csv")
+		fs.StringVar(&fields, "fields", "", "Comma-separated fields to write, e.g. timestamp,IPv4.SrcIP,TCP")
+
+		return func(env *Env) error {
+			opts.Fields = splitList(fields)
+			w, closeOut, err := createOutput(env, outFile)
+			if err != nil {
+				return err
+			}
+			defer closeOut()
+			_, err = export.Run(inFile, w, &opts, env.Logger)
+			return err
+		}
+	},
+}
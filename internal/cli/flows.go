@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"net"
+	"strings"
+
+	"osi-replay/pkg/flow"
+)
+
+var flowsCmd = &Command{
+	Name:    "flows",
+	Summary: "Export flow records as CSV, JSON, IPFIX or NetFlow v9.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile string
+			out    string
+			format string
+			idle   = flow.DefaultIdleTimeout
+			active = flow.DefaultActiveTimeout
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.StringVar(&out, "out", "", "Output file or udp://host:port collector (default stdout)")
+		fs.StringVar(&format, "format", flow.FormatCSV, "Export format: csv, json, ipfix or netflow9")
+		fs.DurationVar(&idle, "idle", idle, "Idle timeout after which a flow is exported")
+		fs.DurationVar(&active, "active", active, "Active timeout after which a long flow is exported and restarted")
+
+		return func(env *Env) error {
+			var (
+				w        io.Writer
+				closeOut func() error
+				err      error
+			)
+			if strings.HasPrefix(out, "udp://") {
+				conn, err := net.Dial("udp", strings.TrimPrefix(out, "udp://"))
+				if err != nil {
+					return err
+				}
+				w, closeOut = conn, conn.Close
+			} else if w, closeOut, err = createOutput(env, out); err != nil {
+				return err
+			}
+			defer closeOut()
+
+			exp, err := flow.NewExporter(format, w)
+			if err != nil {
+				return err
+			}
+			return flow.Extract(inFile, idle, active, exp, env.Logger)
+		}
+	},
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/generate"
+	"osi-replay/pkg/replay"
+)
+
+var generateCmd = &Command{
+	Name:    "generate",
+	Summary: "Generate synthetic TCP, HTTP, DNS and ICMP conversations.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			outFile string
+			iface   string
+			clients string
+			servers string
+			kinds   string
+			start   string
+			cfg     generate.Config
+		)
+		fs.StringVar(&outFile, "out", "generated.pcap", "Output PCAP or PCAPNG file")
+		fs.StringVar(&iface, "i", "", "Send the traffic on this interface instead of writing a file")
+		fs.StringVar(&clients, "clients", "10.0.0.1,10.0.0.2", "Comma-separated client IPs")
+		fs.StringVar(&servers, "servers", "10.0.1.1", "Comma-separated server IPs")
+		fs.StringVar(&kinds, "kinds", "", "Comma-separated conversation kinds: tcp, http, dns, icmp (default all)")
+		fs.IntVar(&cfg.Count, "count", generate.DefaultCount, "Number of conversations")
+		fs.Float64Var(&cfg.Rate, "rate", generate.DefaultRate, "Mean conversations started per second")
+		fs.DurationVar(&cfg.RTT, "rtt", generate.DefaultRTT, "Round-trip time between client and server")
+		fs.IntVar(&cfg.DataSize, "size", generate.DefaultDataSize, "TCP payload and HTTP body size in bytes")
+		fs.Int64Var(&cfg.Seed, "seed", 1, "Random seed; the same seed gives the same traffic")
+		fs.StringVar(&start, "start", "", "RFC 3339 time of the first packet (default the Unix epoch)")
+
+		return func(env *Env) error {
+			cfg.Clients = splitList(clients)
+			cfg.Servers = splitList(servers)
+			cfg.Kinds = splitList(kinds)
+			if start != "" {
+				t, err := time.Parse(time.RFC3339Nano, start)
+				if err != nil {
+					return fmt.Errorf("invalid start time %q: %w", start, err)
+				}
+				cfg.Start = t
+			}
+
+			g, err := generate.New(cfg)
+			if err != nil {
+				return err
+			}
+			if iface != "" {
+				capCfg := &common.CaptureConfig{InterfaceName: iface, SnapLen: 65535}
+				return replay.ReplaySource(capCfg, g, &replay.Options{Speed: 1}, env.Logger)
+			}
+			_, err = g.WriteFile(outFile, env.Logger)
+			return err
+		}
+	},
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+
+	"osi-replay/pkg/info"
+	"osi-replay/pkg/stats"
+)
+
+var infoCmd = &Command{
+	Name:    "info",
+	Summary: "Summarise a capture file in a capinfos-like layout.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile string
+			asJSON bool
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.BoolVar(&asJSON, "json", false, "Print the summary as JSON")
+
+		return func(env *Env) error {
+			summary, err := info.Summarize(inFile)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(env, summary)
+			}
+			return summary.WriteText(env.Stdout)
+		}
+	},
+}
+
+var statsCmd = &Command{
+	Name:    "stats",
+	Summary: "Report protocol, talker and port statistics for a capture.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile string
+			top    int
+			asJSON bool
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.IntVar(&top, "top", stats.DefaultTop, "Number of entries in each top-N list")
+		fs.BoolVar(&asJSON, "json", false, "Print the report as JSON")
+
+		return func(env *Env) error {
+			report, err := stats.Compute(inFile, top)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return writeJSON(env, report)
+			}
+			return report.WriteText(env.Stdout)
+		}
+	},
+}
+
+// writeJSON prints v as indented JSON.
+func writeJSON(env *Env, v any) error {
+	enc := json.NewEncoder(env.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
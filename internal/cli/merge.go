@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"osi-replay/pkg/merge"
+)
+
+var mergeCmd = &Command{
+	Name:    "merge",
+	Summary: "Merge captures by timestamp, or concatenate them.",
+	Args:    "input1.pcap input2.pcapng ...",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			outFile string
+			offsets string
+			concat  bool
+		)
+		fs.StringVar(&outFile, "out", "merged.pcap", "Output PCAP or PCAPNG file")
+		fs.StringVar(&offsets, "offset", "", "Comma-separated clock offsets, one per input in order (e.g. 0,1.5ms,-2s)")
+		fs.BoolVar(&concat, "concat", false, "Concatenate inputs in order instead of interleaving by timestamp")
+
+		return func(env *Env) error {
+			if len(env.Args) == 0 {
+				return usageErrorf("no input files given")
+			}
+			inputs := make([]merge.Input, len(env.Args))
+			for i, path := range env.Args {
+				inputs[i].Path = path
+			}
+			if offsets != "" {
+				items := strings.Split(offsets, ",")
+				if len(items) > len(inputs) {
+					return fmt.Errorf("%d offsets given for %d inputs", len(items), len(inputs))
+				}
+				for i, item := range items {
+					if item = strings.TrimSpace(item); item == "" {
+						continue
+					}
+					d, err := time.ParseDuration(item)
+					if err != nil {
+						return fmt.Errorf("invalid offset %q: %w", item, err)
+					}
+					inputs[i].Offset = d
+				}
+			}
+			return merge.Merge(inputs, outFile, &merge.Options{Concatenate: concat}, env.Logger)
+		}
+	},
+}
@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/fragment"
+	"osi-replay/pkg/fuzz"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/replay"
+	"osi-replay/pkg/tunnel"
+)
+
+var replayCmd = &Command{
+	Name:    "replay",
+	Summary: "Replay a capture onto an interface, optionally tunnelled, fragmented or fuzzed.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			iface    string
+			inFile   string
+			outFile  string
+			encapCfg tunnel.EncapConfig
+			encapSrc string
+			encapDst string
+			vni      uint
+			sport    uint
+			dport    uint
+			defrag   bool
+			mtu      int
+			sel      selectFlags
+			doFuzz   bool
+			fuzzCfg  fuzz.Config
+			kinds    string
+			fuzzLog  string
+			metrics  string
+			opts     replay.Options
+		)
+		fs.StringVar(&iface, "i", "eth0", "Interface to replay on")
+		fs.StringVar(&inFile, "in", "capture.pcap", "PCAP or PCAPNG file to replay")
+		alias(fs, "in", "f")
+		fs.StringVar(&outFile, "out", "", "Write the replayed stream to this pcap/pcapng file instead of the interface")
+		alias(fs, "out", "o")
+		fs.Float64Var(&opts.Speed, "speed", 0, "Pace packets by their timestamps at this multiple of the original speed (0 sends as fast as possible)")
+		fs.StringVar(&metrics, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9101")
+		fs.BoolVar(&defrag, "defrag", false, "Reassemble fragmented IPv4/IPv6 datagrams before sending")
+		fs.IntVar(&mtu, "fragment-mtu", 0, "Fragment IP packets larger than this MTU before sending (0 disables)")
+		alias(fs, "fragment-mtu", "mtu")
+		fs.StringVar(&encapCfg.Kind, "encap", "", "Wrap each frame in a 'vxlan', 'gre' or 'ipip' tunnel")
+		fs.StringVar(&encapSrc, "encap-src", "", "Outer source IP for -encap")
+		fs.StringVar(&encapDst, "encap-dst", "", "Outer destination IP for -encap")
+		fs.UintVar(&vni, "encap-vni", 0, "VXLAN VNI or GRE key for -encap")
+		fs.UintVar(&sport, "encap-sport", 0, "Outer UDP source port for VXLAN (0 derives one per flow)")
+		fs.UintVar(&dport, "encap-dport", tunnel.DefaultVXLANPort, "Outer UDP destination port for VXLAN")
+		sel.register(fs, "replay")
+		fs.BoolVar(&doFuzz, "fuzz", false, "Mutate packets before sending")
+		fs.Int64Var(&fuzzCfg.Seed, "fuzz-seed", 1, "Seed for -fuzz; the same seed and input give the same mutations")
+		fs.Float64Var(&fuzzCfg.Rate, "fuzz-rate", 1, "Fraction of packets -fuzz mutates")
+		fs.StringVar(&kinds, "fuzz-kinds", "", "Comma-separated mutations for -fuzz: "+strings.Join(fuzz.AllKinds, ",")+" (default all)")
+		fs.StringVar(&fuzzLog, "fuzz-log", "", "Write one JSON line per mutation to this file")
+
+		return func(env *Env) error {
+			if opts.Speed < 0 {
+				return usageErrorf("-speed must not be negative")
+			}
+			logger := env.Logger.With("file", inFile)
+			if outFile != "" {
+				logger.Info("Replaying to file", "out", outFile)
+			} else {
+				logger.Info("Replaying on interface", "interface", iface)
+			}
+
+			reg, stop, err := serveMetrics(env, metrics)
+			if err != nil {
+				return err
+			}
+			defer stop()
+			if reg != nil {
+				opts.Metrics = replay.NewMetrics(reg)
+			}
+
+			if opts.Select, err = sel.selector(); err != nil {
+				return err
+			}
+			if defrag {
+				opts.Stages = append(opts.Stages, fragment.NewDefragmenter(0))
+			}
+			if encapCfg.Kind != "" {
+				encapCfg.SrcIP = net.ParseIP(encapSrc)
+				encapCfg.DstIP = net.ParseIP(encapDst)
+				encapCfg.VNI = uint32(vni)
+				encapCfg.SrcPort = uint16(sport)
+				encapCfg.DstPort = uint16(dport)
+				e, err := tunnel.NewEncapsulator(encapCfg)
+				if err != nil {
+					return err
+				}
+				opts.Stages = append(opts.Stages, e)
+			}
+			if mtu > 0 {
+				fr, err := fragment.NewFragmenter(mtu)
+				if err != nil {
+					return err
+				}
+				opts.Stages = append(opts.Stages, fr)
+			}
+
+			var fuzzer *fuzz.Fuzzer
+			if doFuzz {
+				fuzzCfg.Kinds = splitList(kinds)
+				// Tunnelled frames are Ethernet; otherwise the fuzzer
+				// sees the input's own link type.
+				if encapCfg.Kind == "" {
+					in, err := pcapio.Open(inFile)
+					if err != nil {
+						return err
+					}
+					fuzzCfg.LinkType = in.LinkType()
+					in.Close()
+				}
+				if fuzzLog != "" {
+					f, err := os.Create(fuzzLog)
+					if err != nil {
+						return fmt.Errorf("error creating fuzz log: %w", err)
+					}
+					defer f.Close()
+					fuzzCfg.Log = f
+				}
+				if fuzzer, err = fuzz.New(fuzzCfg); err != nil {
+					return err
+				}
+				opts.Stages = append(opts.Stages, fuzzer)
+			}
+
+			if outFile != "" {
+				err = replay.ReplayToFile(inFile, outFile, &opts, logger)
+			} else {
+				cfg := &common.CaptureConfig{
+					InterfaceName: iface,
+					SnapLen:       65535,
+					PcapFile:      inFile,
+				}
+				err = replay.ReplayPacketsWithOptions(cfg, &opts, logger)
+			}
+			if err != nil {
+				return err
+			}
+			if fuzzer != nil {
+				logger.Info("Fuzzing complete", "mutated", fuzzer.Mutated, "seed", fuzzCfg.Seed)
+			}
+			logger.Info("Replay complete.")
+			return nil
+		}
+	},
+}
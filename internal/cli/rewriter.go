@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/rewriter"
+)
+
+var rewriterCmd = &Command{
+	Name:    "rewriter",
+	Summary: "Rewrite MAC and IP addresses in a capture.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile  string
+			outFile string
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP file")
+		fs.StringVar(&outFile, "out", "rewritten_capture.pcap", "Output PCAP file")
+
+		return func(env *Env) error {
+			cfg := &rewriter.RewriteConfig{
+				IPMapSrc: map[string]string{"192.168.1.100": "10.0.0.5"},
+				IPMapDst: map[string]string{"192.168.1.200": "10.0.0.10"},
+				MACMapSrc: map[string]string{
+					"00:11:22:33:44:55": "aa:bb:cc:dd:ee:ff",
+				},
+				MACMapDst: map[string]string{},
+			}
+
+			env.Logger.Info("Rewriting packets", "in", inFile, "out", outFile)
+			if err := rewriter.Run(cfg, inFile, outFile, env.Logger); err != nil {
+				return err
+			}
+			env.Logger.Info("Rewrite complete.")
+			return nil
+		}
+	},
+}
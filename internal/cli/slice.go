@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/slice"
+)
+
+// selectFlags are the packet selection flags shared by slice, replay and
+// verify.
+type selectFlags struct {
+	ranges string
+	from   string
+	to     string
+	every  int
+}
+
+func (s *selectFlags) register(fs *flag.FlagSet, verb string) {
+	fs.StringVar(&s.ranges, "range", "", "Only "+verb+" these packet indexes, e.g. 1-100,250,1000-")
+	fs.StringVar(&s.from, "from", "", "Only "+verb+" packets from this RFC 3339 time or offset from the first packet (e.g. 30s)")
+	fs.StringVar(&s.to, "to", "", "Only "+verb+" packets before this RFC 3339 time or offset from the first packet")
+	fs.IntVar(&s.every, "every", 0, "Only "+verb+" every Nth packet")
+}
+
+// selector returns the Selector for the flags, or nil if none were given.
+func (s *selectFlags) selector() (*slice.Selector, error) {
+	return slice.NewSelector(s.ranges, s.from, s.to, s.every)
+}
+
+var sliceCmd = &Command{
+	Name:    "slice",
+	Summary: "Cut packets out of a capture by index, time window or sampling.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile  string
+			outFile string
+			sel     selectFlags
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.StringVar(&outFile, "out", "slice.pcap", "Output PCAP or PCAPNG file")
+		sel.register(fs, "keep")
+
+		return func(env *Env) error {
+			s, err := sel.selector()
+			if err != nil {
+				return err
+			}
+			if s == nil {
+				return usageErrorf("at least one of -range, -from, -to or -every is required")
+			}
+			_, err = slice.Run(inFile, outFile, s, env.Logger)
+			return err
+		}
+	},
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"flag"
+
+	"osi-replay/pkg/split"
+)
+
+var splitCmd = &Command{
+	Name:    "split",
+	Summary: "Split a capture by packet count, size, time, flow or host.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile string
+			outDir string
+			by     string
+			opts   split.Options
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.StringVar(&outDir, "out", "split", "Output directory")
+		fs.IntVar(&opts.Count, "count", 0, "Packets per output file")
+		fs.Int64Var(&opts.Size, "size", 0, "Maximum bytes per output file")
+		fs.DurationVar(&opts.Interval, "interval", 0, "Capture time per output file (e.g. 1m)")
+		fs.StringVar(&by, "by", "", "Write one file per 'flow' or 'host'")
+		fs.IntVar(&opts.MaxOpen, "max-open", split.DefaultMaxOpen, "Maximum output files kept open with -by")
+
+		return func(env *Env) error {
+			var modes []string
+			if opts.Count > 0 {
+				modes = append(modes, split.ModeCount)
+			}
+			if opts.Size > 0 {
+				modes = append(modes, split.ModeSize)
+			}
+			if opts.Interval > 0 {
+				modes = append(modes, split.ModeTime)
+			}
+			if by != "" {
+				modes = append(modes, by)
+			}
+			if len(modes) != 1 {
+				return usageErrorf("exactly one of -count, -size, -interval or -by is required")
+			}
+			opts.Mode = modes[0]
+
+			_, err := split.Split(inFile, outDir, &opts, env.Logger)
+			return err
+		}
+	},
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"osi-replay/pkg/stream"
+)
+
+var streamsCmd = &Command{
+	Name:    "streams",
+	Summary: "Reassemble TCP streams into per-direction payload files with an index.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile string
+			outDir string
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.StringVar(&outDir, "out", "streams", "Directory for stream files and index.json")
+
+		return func(env *Env) error {
+			env.Logger.Info("Reassembling TCP streams", "in", inFile, "out", outDir)
+
+			sink, err := stream.NewFileSink(outDir)
+			if err != nil {
+				return err
+			}
+			convs, err := stream.Reassemble(inFile, sink, env.Logger)
+			if err != nil {
+				return err
+			}
+
+			indexFile := filepath.Join(outDir, "index.json")
+			f, err := os.Create(indexFile)
+			if err != nil {
+				return fmt.Errorf("error creating index file: %w", err)
+			}
+			defer f.Close()
+			if err := stream.WriteIndex(f, convs); err != nil {
+				return err
+			}
+			env.Logger.Info("Wrote streams", "streams", len(convs), "index", indexFile)
+			return nil
+		}
+	},
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"osi-replay/pkg/dedup"
+	"osi-replay/pkg/fragment"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/timeshift"
+	"osi-replay/pkg/transform"
+	"osi-replay/pkg/tunnel"
+	"osi-replay/pkg/vlan"
+)
+
+var transformCmd = &Command{
+	Name:    "transform",
+	Summary: "Sanitize a capture, with optional decapsulation, dedup, VLAN and timestamp edits.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			inFile  string
+			outFile string
+			opts    transform.Options
+			tsCfg   timeshift.Config
+			rebase  string
+			dedupW  time.Duration
+			vlanCfg vlan.Config
+			push    string
+			remap   string
+			decap   bool
+			keepID  string
+			defrag  bool
+			mtu     int
+		)
+		fs.StringVar(&inFile, "in", "capture.pcap", "Input PCAP or PCAPNG file")
+		fs.StringVar(&outFile, "out", "sanitized_capture.pcap", "Output PCAP or PCAPNG file")
+		fs.StringVar(&opts.AuditFile, "audit", "", "Write a per-packet NDJSON audit trail to this file")
+		fs.StringVar(&opts.AuditReportFile, "audit-report", "", "Write aggregate per-rule audit totals (JSON) to this file")
+		fs.BoolVar(&decap, "decap", false, "Strip GRE, VXLAN, GENEVE or GTP-U encapsulation before sanitizing")
+		fs.StringVar(&keepID, "decap-keep-id", "", "Keep the tunnel ID as a 'vlan' tag or pcapng 'comment'")
+		fs.BoolVar(&defrag, "defrag", false, "Reassemble fragmented IPv4/IPv6 datagrams before sanitizing")
+		fs.IntVar(&mtu, "fragment-mtu", 0, "Fragment IP packets larger than this MTU (0 disables)")
+		fs.DurationVar(&dedupW, "dedup", 0, "Drop duplicate frames seen within this window (0 disables)")
+		fs.IntVar(&vlanCfg.Pop, "vlan-pop", 0, "Remove this many outer VLAN tags")
+		fs.StringVar(&remap, "vlan-remap", "", "Comma-separated VLAN remaps FROM=TO[/PCP], e.g. 100=200/3")
+		fs.StringVar(&push, "vlan-push", "", "Comma-separated tags to push, outermost first: [ad:]VID[/PCP]")
+		fs.DurationVar(&tsCfg.Offset, "ts-offset", 0, "Shift every timestamp by this duration (e.g. -36h)")
+		fs.StringVar(&rebase, "ts-rebase", "", "Move the first packet to this RFC 3339 time, keeping relative spacing")
+		fs.Float64Var(&tsCfg.Scale, "ts-scale", 1, "Multiply inter-packet gaps by this factor")
+		fs.DurationVar(&tsCfg.RandomMax, "ts-random", 0, "Add a secret random offset of up to +/- this duration")
+
+		return func(env *Env) error {
+			if decap && keepID == tunnel.KeepComment && !pcapio.IsPcapng(outFile) {
+				return usageErrorf("-decap-keep-id comment needs a .pcapng -out; pcap has no packet comments")
+			}
+			if decap {
+				d, err := tunnel.NewDecapsulator(tunnel.DecapConfig{KeepID: keepID})
+				if err != nil {
+					return err
+				}
+				opts.Prepare = append(opts.Prepare, d)
+			}
+			if defrag {
+				opts.Prepare = append(opts.Prepare, fragment.NewDefragmenter(0))
+			}
+			if dedupW > 0 {
+				opts.Stages = append(opts.Stages, dedup.New(dedupW))
+			}
+			for _, s := range splitList(remap) {
+				r, err := vlan.ParseRemap(s)
+				if err != nil {
+					return err
+				}
+				vlanCfg.Remap = append(vlanCfg.Remap, r)
+			}
+			for _, s := range splitList(push) {
+				tag, err := vlan.ParseTag(s)
+				if err != nil {
+					return err
+				}
+				vlanCfg.Push = append(vlanCfg.Push, tag)
+			}
+			if vlanCfg.Pop > 0 || len(vlanCfg.Remap) > 0 || len(vlanCfg.Push) > 0 {
+				tagger, err := vlan.New(vlanCfg)
+				if err != nil {
+					return err
+				}
+				opts.Stages = append(opts.Stages, tagger)
+			}
+			if rebase != "" {
+				t, err := time.Parse(time.RFC3339Nano, rebase)
+				if err != nil {
+					return fmt.Errorf("invalid -ts-rebase value %q: %w", rebase, err)
+				}
+				tsCfg.RebaseTo = t
+			}
+			if tsCfg != (timeshift.Config{Scale: 1}) {
+				shifter, err := timeshift.New(tsCfg)
+				if err != nil {
+					return err
+				}
+				opts.Stages = append(opts.Stages, shifter)
+			}
+			if mtu > 0 {
+				fr, err := fragment.NewFragmenter(mtu)
+				if err != nil {
+					return err
+				}
+				opts.Stages = append(opts.Stages, fr)
+			}
+
+			logger := env.Logger.With("file", inFile)
+			logger.Info("Transforming", "out", outFile)
+			if err := transform.RunWithOptions(inFile, outFile, &opts, logger); err != nil {
+				return err
+			}
+			logger.Info("Transformation complete.")
+			return nil
+		}
+	},
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"flag"
+	"time"
+
+	"osi-replay/pkg/replay"
+	"osi-replay/pkg/verify"
+)
+
+var verifyCmd = &Command{
+	Name:    "verify",
+	Summary: "Replay on one interface while capturing on another and report loss, reordering and latency.",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var (
+			cfg      verify.Config
+			sent     string
+			received string
+			sel      selectFlags
+			asJSON   bool
+		)
+		fs.StringVar(&cfg.TxInterface, "i", "", "Interface to replay on")
+		fs.StringVar(&cfg.RxInterface, "rx", "", "Interface to capture the replayed traffic on")
+		fs.StringVar(&cfg.PcapFile, "in", "capture.pcap", "PCAP or PCAPNG file to replay")
+		alias(fs, "in", "f")
+		fs.DurationVar(&cfg.Wait, "wait", verify.DefaultWait, "How long to keep capturing after the last packet is sent")
+		sel.register(fs, "replay")
+		fs.StringVar(&sent, "sent", "", "Compare this capture of sent traffic offline instead of replaying")
+		fs.StringVar(&received, "received", "", "Capture of received traffic to compare with -sent")
+		fs.BoolVar(&asJSON, "json", false, "Print the report as JSON")
+
+		return func(env *Env) error {
+			var (
+				report *verify.Report
+				err    error
+			)
+			switch {
+			case sent != "" || received != "":
+				if sent == "" || received == "" {
+					return usageErrorf("-sent and -received must be used together")
+				}
+				report, err = verify.Compare(sent, received)
+			case cfg.TxInterface == "" || cfg.RxInterface == "":
+				return usageErrorf("-i and -rx are required")
+			default:
+				var opts replay.Options
+				if opts.Select, err = sel.selector(); err != nil {
+					return err
+				}
+				env.Logger.Info("Verifying replay", "file", cfg.PcapFile, "tx", cfg.TxInterface, "rx", cfg.RxInterface)
+				start := time.Now()
+				report, err = verify.Run(cfg, &opts, env.Logger)
+				if err == nil {
+					env.Logger.Info("Verification complete", "duration", time.Since(start).Round(time.Millisecond))
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				err = writeJSON(env, report)
+			} else {
+				err = report.WriteText(env.Stdout)
+			}
+			if err != nil {
+				return err
+			}
+			if !report.Intact() {
+				return ErrCheckFailed
+			}
+			return nil
+		}
+	},
+}
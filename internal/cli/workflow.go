@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"osi-replay/pkg/workflow"
+)
+
+var workflowCmd = &Command{
+	Name:    "workflow",
+	Summary: "Run a YAML workflow of source, stages and sinks as one streaming pipeline.",
+	Args:    "workflow.yaml",
+	Setup: func(fs *flag.FlagSet) func(*Env) error {
+		var check bool
+		fs.BoolVar(&check, "check", false, "Only validate the workflow file")
+
+		return func(env *Env) error {
+			if len(env.Args) != 1 {
+				return usageErrorf("one workflow file is required")
+			}
+			logger := env.Logger.With("file", env.Args[0])
+			w, err := workflow.Load(env.Args[0])
+			if err != nil {
+				return err
+			}
+			if check {
+				logger.Info("Workflow is valid", "stages", len(w.Stages), "sinks", len(w.Sinks))
+				return nil
+			}
+
+			// Stop a live source cleanly on Ctrl+C so sinks are closed.
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			_, err = w.Run(ctx, logger)
+			return err
+		}
+	},
+}
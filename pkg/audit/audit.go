@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Actions recorded for a packet.
+const (
+	ActionKeep = "keep"
+	ActionDrop = "drop"
+)
+
+// Entry is one NDJSON line in the audit trail.
+type Entry struct {
+	Index     int       `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+	Rule      string    `json:"rule"`
+	Action    string    `json:"action"`
+}
+
+// RuleCount is the aggregate number of packets a rule applied an action to.
+type RuleCount struct {
+	Rule    string `json:"rule"`
+	Action  string `json:"action"`
+	Packets int    `json:"packets"`
+}
+
+// Recorder writes audit entries as NDJSON and keeps per-rule totals.
+type Recorder struct {
+	enc    *json.Encoder
+	counts map[RuleCount]int
+}
+
+// NewRecorder returns a Recorder writing entries to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		enc:    json.NewEncoder(w),
+		counts: make(map[RuleCount]int),
+	}
+}
+
+// Record writes e and adds it to the aggregate report.
+func (r *Recorder) Record(e Entry) error {
+	r.counts[RuleCount{Rule: e.Rule, Action: e.Action}]++
+	if err := r.enc.Encode(e); err != nil {
+		return fmt.Errorf("error writing audit entry %d: %w", e.Index, err)
+	}
+	return nil
+}
+
+// Report returns the per-rule totals sorted by rule and action.
+func (r *Recorder) Report() []RuleCount {
+	report := make([]RuleCount, 0, len(r.counts))
+	for k, n := range r.counts {
+		k.Packets = n
+		report = append(report, k)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Rule != report[j].Rule {
+			return report[i].Rule < report[j].Rule
+		}
+		return report[i].Action < report[j].Action
+	})
+	return report
+}
+
+// WriteReport writes the per-rule totals to w as indented JSON.
+func (r *Recorder) WriteReport(w io.Writer) error {
+	return WriteReport(w, r.Report())
+}
+
+// WriteReport writes report, as returned by Recorder.Report, to w as
+// indented JSON.
+func WriteReport(w io.Writer, report []RuleCount) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("error writing audit report: %w", err)
+	}
+	return nil
+}
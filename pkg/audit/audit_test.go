@@ -0,0 +1,58 @@
+package audit_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"osi-replay/pkg/audit"
+)
+
+func TestRecorder_EntriesAndReport(t *testing.T) {
+	var buf bytes.Buffer
+	rec := audit.NewRecorder(&buf)
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []audit.Entry{
+		{Index: 1, Timestamp: ts, Rule: "default", Action: audit.ActionKeep},
+		{Index: 2, Timestamp: ts, Rule: "blocked-ip", Action: audit.ActionDrop},
+		{Index: 3, Timestamp: ts, Rule: "blocked-ip", Action: audit.ActionDrop},
+	}
+	for _, e := range entries {
+		if err := rec.Record(e); err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+
+	var lines int
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		if e != entries[lines] {
+			t.Errorf("Line %d: expected %+v, got %+v", lines, entries[lines], e)
+		}
+		lines++
+	}
+	if lines != len(entries) {
+		t.Fatalf("Expected %d lines, got %d", len(entries), lines)
+	}
+
+	report := rec.Report()
+	want := []audit.RuleCount{
+		{Rule: "blocked-ip", Action: audit.ActionDrop, Packets: 2},
+		{Rule: "default", Action: audit.ActionKeep, Packets: 1},
+	}
+	if len(report) != len(want) {
+		t.Fatalf("Expected %d report rows, got %d", len(want), len(report))
+	}
+	for i := range want {
+		if report[i] != want[i] {
+			t.Errorf("Report row %d: expected %+v, got %+v", i, want[i], report[i])
+		}
+	}
+}
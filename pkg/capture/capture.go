@@ -1,44 +1,301 @@
 package capture
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/pcapgo"
 
 	"osi-replay/pkg/common"
+	"osi-replay/pkg/dedup"
+	"osi-replay/pkg/pcapio"
 )
 
+// Options controls optional behaviour of CapturePacketsWithOptions and
+// Stream.
+type Options struct {
+	// Format is the capture format Stream writes: pcapio.FormatPcap (the
+	// default) or pcapio.FormatPcapng. CapturePacketsWithOptions picks it
+	// from the file name.
+	Format string
+	// RotateSize and RotateInterval, if positive, start a new file once
+	// the current one holds this many bytes or spans this much capture
+	// time. Rotated files are numbered: capture-00001.pcap, ...
+	RotateSize     int64
+	RotateInterval time.Duration
+	// Metrics, if set, is updated as packets are captured.
+	Metrics *Metrics
+}
+
 func CapturePackets(cfg *common.CaptureConfig, logger *common.Logger) error {
+	return CapturePacketsWithOptions(cfg, &Options{}, logger)
+}
+
+// CapturePacketsWithOptions behaves like CapturePackets and additionally
+// honours opts.
+func CapturePacketsWithOptions(cfg *common.CaptureConfig, opts *Options, logger *common.Logger) error {
+	h, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+	opts.Metrics.watch(h)
+
+	out := &rotator{path: cfg.PcapFile, opts: opts, snaplen: h.snaplen, linkType: h.LinkType()}
+	if err := out.open(); err != nil {
+		return err
+	}
+	defer func() {
+		if err := out.close(); err != nil {
+			opts.Metrics.writeFailed()
+			logger.Error(err)
+		}
+	}()
+
+	logger.Info("Capture started. Press Ctrl+C to stop...")
+	for pkt, err := range h.Packets(context.Background()) {
+		if err != nil {
+			return err
+		}
+		if out.due(pkt) {
+			if err := out.close(); err != nil {
+				opts.Metrics.writeFailed()
+				logger.Error(err)
+			}
+			if err := out.open(); err != nil {
+				opts.Metrics.writeFailed()
+				return err
+			}
+			logger.Info("Rotated output file", "file", out.name)
+		}
+		opts.Metrics.captured(pkt)
+		if err := out.write(pkt); err != nil {
+			opts.Metrics.writeFailed()
+			logger.Error(fmt.Errorf("failed to write packet: %w", err))
+		}
+	}
+
+	if h.filter != nil {
+		logger.Info("Dropped duplicate packets", "packets", h.Duplicates())
+	}
+	logger.Info("No more packets to read. Capture done.")
+	return nil
+}
+
+// rotator writes to path, or to numbered files next to it when rotation
+// is enabled.
+type rotator struct {
+	path     string
+	opts     *Options
+	snaplen  uint32
+	linkType layers.LinkType
+
+	name   string
+	seq    int
+	f      *os.File
+	n      int64
+	start  time.Time
+	writer pcapio.PacketWriter
+}
+
+func (r *rotator) rotating() bool {
+	return r.opts.RotateSize > 0 || r.opts.RotateInterval > 0
+}
+
+// open starts the next file. The current one, if any, must be closed
+// first.
+func (r *rotator) open() error {
+	r.name = r.path
+	if r.rotating() {
+		r.seq++
+		ext := filepath.Ext(r.path)
+		r.name = fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(r.path, ext), r.seq, ext)
+	}
+	f, err := os.Create(r.name)
+	if err != nil {
+		return fmt.Errorf("failed to create pcap file %s: %w", r.name, err)
+	}
+	r.f, r.n, r.start = f, 0, time.Time{}
+	writer, err := pcapio.NewWriter(r, r.name, r.snaplen, r.linkType)
+	if err != nil {
+		r.close()
+		return fmt.Errorf("failed to write file header: %w", err)
+	}
+	r.writer = writer
+	r.opts.Metrics.opened(r.name, r.seq > 1)
+	return nil
+}
+
+// due reports whether pkt should go to a new file.
+func (r *rotator) due(pkt common.Packet) bool {
+	if r.start.IsZero() {
+		return false
+	}
+	if size := r.opts.RotateSize; size > 0 && r.n+int64(len(pkt.Data))+16 > size {
+		return true
+	}
+	interval := r.opts.RotateInterval
+	return interval > 0 && pkt.CaptureInfo.Timestamp.Sub(r.start) >= interval
+}
+
+func (r *rotator) write(pkt common.Packet) error {
+	if r.start.IsZero() {
+		r.start = pkt.CaptureInfo.Timestamp
+	}
+	return pcapio.WritePacket(r.writer, pkt)
+}
+
+// Write counts the bytes written to the current file.
+func (r *rotator) Write(p []byte) (int, error) {
+	n, err := r.f.Write(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// close closes the current file. An error means its tail may be lost.
+func (r *rotator) close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	if err != nil {
+		return fmt.Errorf("failed to close pcap file %s: %w", r.name, err)
+	}
+	return nil
+}
+
+// Handle is a live capture opened by Open.
+type Handle struct {
+	mu      sync.Mutex // guards closed, as Stats may be called from a metrics scrape
+	closed  bool
+	handle  *pcap.Handle
+	filter  *dedup.Filter
+	snaplen uint32
+}
+
+// Open starts capturing on cfg.InterfaceName; cfg.PcapFile is not used.
+// Failing to open the interface is reported as a *common.InterfaceError.
+func Open(cfg *common.CaptureConfig) (*Handle, error) {
 	handle, err := pcap.OpenLive(cfg.InterfaceName, cfg.SnapLen, cfg.Promiscuous, cfg.Timeout)
 	if err != nil {
-		return fmt.Errorf("failed to open device %s: %w", cfg.InterfaceName, err)
+		return nil, &common.InterfaceError{Interface: cfg.InterfaceName, Err: err}
+	}
+	h := &Handle{handle: handle, snaplen: uint32(cfg.SnapLen)}
+	if cfg.DedupWindow > 0 {
+		h.filter = dedup.New(cfg.DedupWindow)
+	}
+	return h, nil
+}
+
+// LinkType returns the link type of the captured frames.
+func (h *Handle) LinkType() layers.LinkType { return h.handle.LinkType() }
+
+// Duplicates returns how many frames the dedup window has dropped.
+func (h *Handle) Duplicates() int {
+	if h.filter == nil {
+		return 0
+	}
+	return h.filter.Dropped
+}
+
+// Packets returns an iterator over the captured frames, numbered from 1
+// after duplicates are dropped. It ends when ctx is done, the capture
+// has no more packets, or after yielding a capture error. ctx is checked
+// between packets, so give the CaptureConfig a Timeout for cancellation
+// to be noticed on an idle link.
+func (h *Handle) Packets(ctx context.Context) iter.Seq2[common.Packet, error] {
+	return func(yield func(common.Packet, error) bool) {
+		var index int
+		for ctx.Err() == nil {
+			data, ci, err := h.handle.ReadPacketData()
+			if err == pcap.NextErrorTimeoutExpired {
+				continue
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(common.Packet{}, fmt.Errorf("error capturing packet: %w", err))
+				return
+			}
+			if h.filter != nil && h.filter.Duplicate(data, ci.Timestamp) {
+				continue
+			}
+			index++
+			if !yield(common.Packet{Data: data, CaptureInfo: ci, Index: index}, nil) {
+				return
+			}
+		}
 	}
-	defer handle.Close()
+}
+
+// Stats are the capture statistics kept by libpcap and the kernel.
+type Stats struct {
+	Received  int
+	Dropped   int
+	IfDropped int
+}
 
-	f, err := os.Create(cfg.PcapFile)
+// Stats returns the packets received and dropped so far.
+func (h *Handle) Stats() (Stats, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return Stats{}, errors.New("capture handle is closed")
+	}
+	st, err := h.handle.Stats()
 	if err != nil {
-		return fmt.Errorf("failed to create pcap file %s: %w", cfg.PcapFile, err)
+		return Stats{}, err
 	}
-	defer f.Close()
+	return Stats{Received: st.PacketsReceived, Dropped: st.PacketsDropped, IfDropped: st.PacketsIfDropped}, nil
+}
 
-	writer := pcapgo.NewWriter(f)
-	if err := writer.WriteFileHeader(uint32(cfg.SnapLen), handle.LinkType()); err != nil {
-		return fmt.Errorf("failed to write file header: %w", err)
+// Close stops the capture.
+func (h *Handle) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		h.handle.Close()
 	}
+}
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	logger.Info("Capture started. Press Ctrl+C to stop...")
+// Stream writes the packets captured by h to w in opts.Format until ctx
+// is done or the capture ends, and returns how many were written. It
+// stops at the first capture error.
+func Stream(ctx context.Context, h *Handle, w io.Writer, opts *Options, logger *common.Logger) (int, error) {
+	writer, err := pcapio.NewFormatWriter(w, opts.Format, h.snaplen, h.LinkType())
+	if err != nil {
+		return 0, fmt.Errorf("failed to write file header: %w", err)
+	}
 
-	for packet := range packetSource.Packets() {
-		ci := packet.Metadata().CaptureInfo
-		if err := writer.WritePacket(ci, packet.Data()); err != nil {
+	opts.Metrics.watch(h)
+	var count int
+	for pkt, err := range h.Packets(ctx) {
+		if err != nil {
+			return count, err
+		}
+		opts.Metrics.captured(pkt)
+		if err := pcapio.WritePacket(writer, pkt); err != nil {
+			opts.Metrics.writeFailed()
 			logger.Error(fmt.Errorf("failed to write packet: %w", err))
+			continue
 		}
+		count++
 	}
 
-	logger.Info("No more packets to read. Capture done.")
-	return nil
+	if h.filter != nil {
+		logger.Info("Dropped duplicate packets", "packets", h.Duplicates())
+	}
+	return count, nil
 }
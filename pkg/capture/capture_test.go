@@ -1,6 +1,7 @@
 package capture_test
 
 import (
+	"errors"
 	"os"
 	"testing"
 
@@ -60,3 +61,11 @@ func TestCapturePackets_Simple(t *testing.T) {
 	}
 	_ = os.Remove("test_capture.pcap")
 }
+
+func TestOpen_InterfaceError(t *testing.T) {
+	_, err := capture.Open(&common.CaptureConfig{InterfaceName: "does-not-exist", SnapLen: 65535})
+	var ie *common.InterfaceError
+	if !errors.As(err, &ie) || ie.Interface != "does-not-exist" {
+		t.Errorf("Expected *common.InterfaceError, got %v", err)
+	}
+}
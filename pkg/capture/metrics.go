@@ -0,0 +1,84 @@
+package capture
+
+import (
+	"sync"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/metrics"
+)
+
+// Metrics are the capture metrics. A nil *Metrics records nothing.
+type Metrics struct {
+	Packets     *metrics.Counter
+	Bytes       *metrics.Counter
+	WriteErrors *metrics.Counter
+	Rotations   *metrics.Counter
+	File        *metrics.Info
+
+	mu     sync.Mutex
+	handle *Handle
+	last   Stats
+}
+
+// NewMetrics registers the capture metrics with reg. Kernel and interface
+// drops are read from the capture handle's statistics when scraped.
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	m := &Metrics{
+		Packets:     reg.Counter("osi_replay_capture_packets_total", "Packets captured."),
+		Bytes:       reg.Counter("osi_replay_capture_bytes_total", "Bytes captured."),
+		WriteErrors: reg.Counter("osi_replay_capture_write_errors_total", "Captured packets that could not be written."),
+		Rotations:   reg.Counter("osi_replay_capture_rotations_total", "Output file rotations."),
+		File:        reg.Info("osi_replay_capture_file_info", "The file currently being written.", "path"),
+	}
+	reg.CounterFunc("osi_replay_capture_kernel_dropped_packets_total",
+		"Packets dropped by the kernel because the capture fell behind.",
+		func() float64 { return float64(m.stats().Dropped) })
+	reg.CounterFunc("osi_replay_capture_interface_dropped_packets_total",
+		"Packets dropped by the network interface or its driver.",
+		func() float64 { return float64(m.stats().IfDropped) })
+	return m
+}
+
+// stats returns the handle's statistics, or the last ones read once the
+// handle is closed.
+func (m *Metrics) stats() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.handle != nil {
+		if st, err := m.handle.Stats(); err == nil {
+			m.last = st
+		}
+	}
+	return m.last
+}
+
+func (m *Metrics) watch(h *Handle) {
+	if m != nil {
+		m.mu.Lock()
+		m.handle = h
+		m.mu.Unlock()
+	}
+}
+
+func (m *Metrics) captured(pkt common.Packet) {
+	if m != nil {
+		m.Packets.Inc()
+		m.Bytes.Add(uint64(len(pkt.Data)))
+	}
+}
+
+func (m *Metrics) writeFailed() {
+	if m != nil {
+		m.WriteErrors.Inc()
+	}
+}
+
+func (m *Metrics) opened(name string, rotated bool) {
+	if m == nil {
+		return
+	}
+	m.File.Set(name)
+	if rotated {
+		m.Rotations.Inc()
+	}
+}
@@ -1,8 +1,12 @@
 package common_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"osi-replay/pkg/common"
@@ -33,3 +37,214 @@ func TestEnsureFileWritable_ExistingFile(t *testing.T) {
 	// Clean up
 	os.Remove(testFile)
 }
+
+// splitStage emits every packet twice and holds the last one until Flush.
+type splitStage struct {
+	held []common.Packet
+}
+
+func (s *splitStage) Name() string { return "split" }
+
+func (s *splitStage) Process(pkt common.Packet) ([]common.Packet, error) {
+	out := s.held
+	s.held = []common.Packet{pkt}
+	return append(out, pkt), nil
+}
+
+func (s *splitStage) Flush() ([]common.Packet, error) {
+	out := s.held
+	s.held = nil
+	return out, nil
+}
+
+// dropOdd drops packets with an odd index.
+type dropOdd struct{}
+
+func (dropOdd) Name() string { return "drop-odd" }
+
+func (dropOdd) Process(pkt common.Packet) ([]common.Packet, error) {
+	if pkt.Index%2 == 1 {
+		return nil, nil
+	}
+	return []common.Packet{pkt}, nil
+}
+
+func (dropOdd) Flush() ([]common.Packet, error) { return nil, nil }
+
+func TestRunStages_ChainAndFlush(t *testing.T) {
+	stages := []common.Stage{&splitStage{}, dropOdd{}}
+	var drops int
+	dropped := func(st common.Stage, pkt common.Packet) {
+		if st.Name() != "drop-odd" {
+			t.Errorf("Unexpected drop by stage %s", st.Name())
+		}
+		drops++
+	}
+
+	var got []common.Packet
+	for i := 1; i <= 3; i++ {
+		out, err := common.RunStages(stages, []common.Packet{{Index: i}}, dropped)
+		if err != nil {
+			t.Fatalf("RunStages returned error: %v", err)
+		}
+		got = append(got, out...)
+	}
+	out, err := common.FlushStages(stages, dropped)
+	if err != nil {
+		t.Fatalf("FlushStages returned error: %v", err)
+	}
+	got = append(got, out...)
+
+	// splitStage emits 1 | 1,2 | 2,3 and flushes 3; dropOdd keeps the 2s.
+	if len(got) != 2 || got[0].Index != 2 || got[1].Index != 2 {
+		t.Errorf("Unexpected pipeline output: %+v", got)
+	}
+	if drops != 4 {
+		t.Errorf("Expected 4 drops, got %d", drops)
+	}
+}
+
+type failOn struct{ index int }
+
+func (f failOn) Name() string { return "fail" }
+
+func (f failOn) Process(pkt common.Packet) ([]common.Packet, error) {
+	if pkt.Index == f.index {
+		return nil, errors.New("boom")
+	}
+	return []common.Packet{pkt}, nil
+}
+
+func (failOn) Flush() ([]common.Packet, error) { return nil, nil }
+
+func TestApply_StageErrors(t *testing.T) {
+	src := func(yield func(common.Packet, error) bool) {
+		for i := 1; i <= 3; i++ {
+			if !yield(common.Packet{Index: i}, nil) {
+				return
+			}
+		}
+	}
+
+	var got []int
+	var errs []error
+	for pkt, err := range common.Apply(src, []common.Stage{failOn{index: 2}, &splitStage{}}, nil) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		got = append(got, pkt.Index)
+	}
+
+	// splitStage emits 1 | 1,3 and flushes 3; packet 2 fails first.
+	if len(got) != 4 || got[0] != 1 || got[1] != 1 || got[2] != 3 || got[3] != 3 {
+		t.Errorf("Unexpected output indexes: %v", got)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error, got %v", errs)
+	}
+	var se *common.StageError
+	if !errors.As(errs[0], &se) || se.Stage != "fail" || se.Index != 2 {
+		t.Errorf("Expected StageError for stage fail on packet 2, got %v", errs[0])
+	}
+}
+
+func TestApply_SourceErrorEnds(t *testing.T) {
+	// A source that fails the same way forever, like a truncated file read
+	// without giving up.
+	src := func(yield func(common.Packet, error) bool) {
+		if !yield(common.Packet{Index: 1}, nil) {
+			return
+		}
+		for {
+			if !yield(common.Packet{}, errors.New("unexpected EOF")) {
+				return
+			}
+		}
+	}
+
+	var got []int
+	var errs int
+	for pkt, err := range common.Apply(src, []common.Stage{&splitStage{}}, nil) {
+		if err != nil {
+			if errs++; errs > 1 {
+				t.Fatalf("Expected iteration to end after the source error")
+			}
+			continue
+		}
+		got = append(got, pkt.Index)
+	}
+	// The stages are still flushed after the error.
+	if len(got) != 2 || got[0] != 1 || got[1] != 1 {
+		t.Errorf("Unexpected output indexes: %v", got)
+	}
+}
+
+func TestApply_StopEarly(t *testing.T) {
+	src := func(yield func(common.Packet, error) bool) {
+		for i := 1; ; i++ {
+			if !yield(common.Packet{Index: i}, nil) {
+				return
+			}
+		}
+	}
+	var n int
+	for range common.Apply(src, nil, nil) {
+		if n++; n == 5 {
+			break
+		}
+	}
+	if n != 5 {
+		t.Errorf("Expected to stop after 5 packets, got %d", n)
+	}
+}
+
+func TestLogger_JSONLevelsAndAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	if err := common.SetOutput(&buf, common.LogJSON); err != nil {
+		t.Fatalf("SetOutput returned error: %v", err)
+	}
+	defer common.SetOutput(os.Stderr, common.LogText)
+	if err := common.SetLevel(common.LevelWarn); err != nil {
+		t.Fatalf("SetLevel returned error: %v", err)
+	}
+	defer common.SetLevel(common.LevelInfo)
+
+	logger := common.NewLogger("test").With("file", "in.pcap")
+	logger.Info("hidden")
+	logger.Warn("decode error", "index", 3)
+	logger.Error(&common.StageError{Stage: "fuzz", Index: 7, Err: errors.New("boom")})
+	logger.Error(nil)
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", line, err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 log lines, got %d:\n%s", len(lines), buf.String())
+	}
+	if lines[0]["level"] != "WARN" || lines[0]["component"] != "test" || lines[0]["file"] != "in.pcap" || lines[0]["index"] != 3.0 {
+		t.Errorf("Unexpected warning line: %v", lines[0])
+	}
+	if lines[1]["level"] != "ERROR" || lines[1]["stage"] != "fuzz" || lines[1]["index"] != 7.0 {
+		t.Errorf("Unexpected error line: %v", lines[1])
+	}
+	if lines[2]["msg"] != "<nil>" {
+		t.Errorf("Unexpected nil error line: %v", lines[2])
+	}
+
+	if err := common.SetOutput(&buf, "xml"); err == nil {
+		t.Errorf("Expected error for unknown log format")
+	}
+	if err := common.SetLevel("loud"); err == nil {
+		t.Errorf("Expected error for unknown log level")
+	}
+
+	var nilLogger *common.Logger
+	nilLogger.Info("ignored")
+	nilLogger.With("k", "v").Error(errors.New("ignored"))
+}
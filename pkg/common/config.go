@@ -9,4 +9,7 @@ type CaptureConfig struct {
 	SnapLen       int32
 	Timeout       time.Duration
 	PcapFile      string
+	// DedupWindow, if positive, drops frames repeated within this window
+	// while capturing (e.g. SPAN ports mirroring both directions).
+	DedupWindow time.Duration
 }
@@ -0,0 +1,33 @@
+package common
+
+import "fmt"
+
+// StageError reports a Stage that failed on a packet. Index is 0 when the
+// stage failed while flushing.
+type StageError struct {
+	Stage string
+	Index int
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	if e.Index == 0 {
+		return fmt.Sprintf("stage %s failed to flush: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("stage %s failed on packet %d: %v", e.Stage, e.Index, e.Err)
+}
+
+func (e *StageError) Unwrap() error { return e.Err }
+
+// InterfaceError reports a network interface that could not be opened or
+// configured for capture or injection.
+type InterfaceError struct {
+	Interface string
+	Err       error
+}
+
+func (e *InterfaceError) Error() string {
+	return fmt.Sprintf("error opening interface %s: %v", e.Interface, e.Err)
+}
+
+func (e *InterfaceError) Unwrap() error { return e.Err }
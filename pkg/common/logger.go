@@ -1,29 +1,160 @@
 package common
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
 )
 
+// Log levels accepted by SetLevel, from most to least verbose.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Log formats accepted by SetOutput.
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+var levels = map[string]slog.Level{
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+var (
+	// minLevel is shared by all Loggers so one flag controls every component.
+	minLevel = new(slog.LevelVar)
+
+	handlerMu sync.Mutex
+	handler   slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: minLevel})
+)
+
+// SetLevel suppresses messages below level for all Loggers.
+func SetLevel(level string) error {
+	l, ok := levels[level]
+	if !ok {
+		return fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+	}
+	minLevel.Set(l)
+	return nil
+}
+
+// SetOutput sends the output of Loggers created from now on to w, as
+// logfmt-style text (LogText) or one JSON object per line (LogJSON).
+func SetOutput(w io.Writer, format string) error {
+	opts := &slog.HandlerOptions{Level: minLevel}
+	var h slog.Handler
+	switch format {
+	case LogText:
+		h = slog.NewTextHandler(w, opts)
+	case LogJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	handlerMu.Lock()
+	handler = h
+	handlerMu.Unlock()
+	return nil
+}
+
+// Logger writes levelled, structured messages tagged with the component
+// that logged them. Every method takes optional slog-style key/value
+// attributes, e.g. logger.Info("Rotated output file", "file", name). A nil
+// *Logger discards everything, so library callers may pass nil.
 type Logger struct {
-	prefix string
+	l *slog.Logger
 }
 
+// NewLogger returns a Logger whose messages carry component=prefix.
 func NewLogger(prefix string) *Logger {
-	return &Logger{prefix: prefix}
+	handlerMu.Lock()
+	h := handler
+	handlerMu.Unlock()
+	return &Logger{l: slog.New(h).With("component", prefix)}
+}
+
+// FromSlog wraps l, e.g. so a program embedding these packages can route
+// their logs through its own handler.
+func FromSlog(l *slog.Logger) *Logger {
+	return &Logger{l: l}
+}
+
+// Slog returns the underlying slog.Logger.
+func (l *Logger) Slog() *slog.Logger {
+	if l == nil {
+		return slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return l.l
+}
+
+// With returns a Logger that adds args to every message, e.g. the file
+// being processed.
+func (l *Logger) With(args ...any) *Logger {
+	if l == nil {
+		return nil
+	}
+	return &Logger{l: l.l.With(args...)}
+}
+
+func (l *Logger) Debug(msg string, args ...any) {
+	if l != nil {
+		l.l.Debug(msg, args...)
+	}
+}
+
+func (l *Logger) Info(msg string, args ...any) {
+	if l != nil {
+		l.l.Info(msg, args...)
+	}
+}
+
+func (l *Logger) Warn(msg string, args ...any) {
+	if l != nil {
+		l.l.Warn(msg, args...)
+	}
 }
 
-func (l *Logger) Info(msg string) {
-	log.Printf("[INFO] [%s] %s", l.prefix, msg)
+// Error logs err as the message. A StageError also adds its stage and
+// packet index as attributes.
+func (l *Logger) Error(err error, args ...any) {
+	if l != nil {
+		l.l.Error(errorMessage(err), errorAttrs(err, args)...)
+	}
 }
 
-func (l *Logger) Warn(msg string) {
-	log.Printf("[WARN] [%s] %s", l.prefix, msg)
+// Fatal logs err like Error and exits with status 1.
+func (l *Logger) Fatal(err error, args ...any) {
+	if l != nil {
+		l.l.Error(errorMessage(err), append(errorAttrs(err, args), "fatal", true)...)
+	}
+	os.Exit(1)
 }
 
-func (l *Logger) Error(err error) {
-	log.Printf("[ERROR] [%s] %v", l.prefix, err)
+// errorMessage is err's text, or "<nil>" so a nil error still logs.
+func errorMessage(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
 }
 
-func (l *Logger) Fatal(err error) {
-	log.Fatalf("[FATAL] [%s] %v", l.prefix, err)
+func errorAttrs(err error, args []any) []any {
+	var se *StageError
+	if errors.As(err, &se) {
+		args = append(args, "stage", se.Stage)
+		if se.Index > 0 {
+			args = append(args, "index", se.Index)
+		}
+	}
+	return args
 }
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"errors"
+	"iter"
+
+	"github.com/google/gopacket"
+)
+
+// Packet is a raw frame together with its capture metadata.
+type Packet struct {
+	Data        []byte
+	CaptureInfo gopacket.CaptureInfo
+	// Index is the 1-based position of the packet in its source capture.
+	Index int
+	// Comment is attached to the packet when written to pcapng.
+	Comment string
+}
+
+// Stage is one step of a packet-processing pipeline. Process may return
+// no packets (drop), one, or several. Flush is called once the input is
+// exhausted and returns any packets the stage is still holding.
+type Stage interface {
+	Name() string
+	Process(pkt Packet) ([]Packet, error)
+	Flush() ([]Packet, error)
+}
+
+// RunStages feeds pkts through stages in order and returns whatever the
+// last stage emits. dropped is called for every packet a stage discards.
+// Errors are returned as *StageError.
+func RunStages(stages []Stage, pkts []Packet, dropped func(Stage, Packet)) ([]Packet, error) {
+	for _, st := range stages {
+		var next []Packet
+		for _, pkt := range pkts {
+			out, err := st.Process(pkt)
+			if err != nil {
+				return nil, stageError(st, pkt.Index, err)
+			}
+			if len(out) == 0 && dropped != nil {
+				dropped(st, pkt)
+			}
+			next = append(next, out...)
+		}
+		pkts = next
+	}
+	return pkts, nil
+}
+
+// FlushStages drains every stage in order, passing what each one releases
+// through the stages that follow it.
+func FlushStages(stages []Stage, dropped func(Stage, Packet)) ([]Packet, error) {
+	var out []Packet
+	for i, st := range stages {
+		pkts, err := st.Flush()
+		if err != nil {
+			return out, stageError(st, 0, err)
+		}
+		pkts, err = RunStages(stages[i+1:], pkts, dropped)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, pkts...)
+	}
+	return out, nil
+}
+
+// stageError wraps err in a StageError unless a nested stage already did.
+func stageError(st Stage, index int, err error) error {
+	var se *StageError
+	if errors.As(err, &se) {
+		return err
+	}
+	return &StageError{Stage: st.Name(), Index: index, Err: err}
+}
+
+// Apply returns an iterator over the packets of src after they pass
+// through stages, flushing the stages once src ends. Errors are yielded
+// with a zero Packet. A stage error drops only the packet it concerns; an
+// error from src is taken to end it, so the stages are flushed and the
+// iteration ends.
+func Apply(src iter.Seq2[Packet, error], stages []Stage, dropped func(Stage, Packet)) iter.Seq2[Packet, error] {
+	return func(yield func(Packet, error) bool) {
+		emit := func(pkts []Packet, err error) bool {
+			for _, pkt := range pkts {
+				if !yield(pkt, nil) {
+					return false
+				}
+			}
+			return err == nil || yield(Packet{}, err)
+		}
+		for pkt, err := range src {
+			if err != nil {
+				if !yield(Packet{}, err) {
+					return
+				}
+				break
+			}
+			if !emit(RunStages(stages, []Packet{pkt}, dropped)) {
+				return
+			}
+		}
+		emit(FlushStages(stages, dropped))
+	}
+}
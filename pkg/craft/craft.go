@@ -0,0 +1,629 @@
+package craft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"gopkg.in/yaml.v3"
+)
+
+// Link types accepted in Spec.Link.
+const (
+	LinkEthernet = "ethernet"
+	LinkRaw      = "raw"
+)
+
+// DefaultInterval separates packets that have no explicit time.
+const DefaultInterval = time.Millisecond
+
+// Default addresses used when a spec leaves them out.
+var (
+	DefaultSrcMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	DefaultDstMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// Spec describes a capture. YAML and JSON share the same field names.
+type Spec struct {
+	// Link is "ethernet" (the default) or "raw" for bare IP packets.
+	Link string `yaml:"link" json:"link"`
+	// Start is the RFC 3339 time of the first packet; it defaults to the
+	// Unix epoch so output is reproducible.
+	Start    string        `yaml:"start" json:"start"`
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	Packets  []Packet      `yaml:"packets" json:"packets"`
+}
+
+// Packet describes one frame as a stack of optional layers, from the link
+// layer up. Lengths and checksums are computed.
+type Packet struct {
+	// Time is an RFC 3339 time or an offset from Spec.Start such as
+	// "+1.5s". Without it the packet follows the previous one after
+	// Spec.Interval.
+	Time string `yaml:"time" json:"time"`
+	// Repeat writes the packet this many times, Spec.Interval apart.
+	Repeat int `yaml:"repeat" json:"repeat"`
+
+	Ethernet   *Ethernet `yaml:"ethernet" json:"ethernet"`
+	VLAN       []VLAN    `yaml:"vlan" json:"vlan"`
+	ARP        *ARP      `yaml:"arp" json:"arp"`
+	IPv4       *IPv4     `yaml:"ipv4" json:"ipv4"`
+	IPv6       *IPv6     `yaml:"ipv6" json:"ipv6"`
+	TCP        *TCP      `yaml:"tcp" json:"tcp"`
+	UDP        *UDP      `yaml:"udp" json:"udp"`
+	ICMPv4     *ICMP     `yaml:"icmpv4" json:"icmpv4"`
+	ICMPv6     *ICMP     `yaml:"icmpv6" json:"icmpv6"`
+	DNS        *DNS      `yaml:"dns" json:"dns"`
+	Payload    string    `yaml:"payload" json:"payload"`
+	PayloadHex string    `yaml:"payload_hex" json:"payload_hex"`
+}
+
+// Ethernet is an Ethernet II header.
+type Ethernet struct {
+	Src string `yaml:"src" json:"src"`
+	Dst string `yaml:"dst" json:"dst"`
+}
+
+// VLAN is an 802.1Q tag.
+type VLAN struct {
+	ID  uint16 `yaml:"id" json:"id"`
+	PCP uint8  `yaml:"pcp" json:"pcp"`
+}
+
+// ARP is an Ethernet/IPv4 ARP message; Op 1 is a request, 2 a reply.
+type ARP struct {
+	Op        uint16 `yaml:"op" json:"op"`
+	SenderMAC string `yaml:"sender_mac" json:"sender_mac"`
+	SenderIP  string `yaml:"sender_ip" json:"sender_ip"`
+	TargetMAC string `yaml:"target_mac" json:"target_mac"`
+	TargetIP  string `yaml:"target_ip" json:"target_ip"`
+}
+
+// IPv4 is an IPv4 header. TTL defaults to 64.
+type IPv4 struct {
+	Src string `yaml:"src" json:"src"`
+	Dst string `yaml:"dst" json:"dst"`
+	TTL uint8  `yaml:"ttl" json:"ttl"`
+	TOS uint8  `yaml:"tos" json:"tos"`
+	ID  uint16 `yaml:"id" json:"id"`
+	DF  bool   `yaml:"df" json:"df"`
+}
+
+// IPv6 is an IPv6 header. HopLimit defaults to 64.
+type IPv6 struct {
+	Src          string `yaml:"src" json:"src"`
+	Dst          string `yaml:"dst" json:"dst"`
+	HopLimit     uint8  `yaml:"hop_limit" json:"hop_limit"`
+	TrafficClass uint8  `yaml:"traffic_class" json:"traffic_class"`
+	FlowLabel    uint32 `yaml:"flow_label" json:"flow_label"`
+}
+
+// TCP is a TCP header. Flags uses the letters F, S, R, P, A, U, E and C.
+// Window defaults to 65535.
+type TCP struct {
+	Src    uint16 `yaml:"src" json:"src"`
+	Dst    uint16 `yaml:"dst" json:"dst"`
+	Seq    uint32 `yaml:"seq" json:"seq"`
+	Ack    uint32 `yaml:"ack" json:"ack"`
+	Flags  string `yaml:"flags" json:"flags"`
+	Window uint16 `yaml:"window" json:"window"`
+}
+
+// UDP is a UDP header.
+type UDP struct {
+	Src uint16 `yaml:"src" json:"src"`
+	Dst uint16 `yaml:"dst" json:"dst"`
+}
+
+// ICMP is an ICMPv4 or ICMPv6 message. ID and Seq are used by echo
+// requests and replies.
+type ICMP struct {
+	Type uint8  `yaml:"type" json:"type"`
+	Code uint8  `yaml:"code" json:"code"`
+	ID   uint16 `yaml:"id" json:"id"`
+	Seq  uint16 `yaml:"seq" json:"seq"`
+}
+
+// DNS is a DNS query or response.
+type DNS struct {
+	ID        uint16      `yaml:"id" json:"id"`
+	Response  bool        `yaml:"response" json:"response"`
+	Questions []DNSRecord `yaml:"questions" json:"questions"`
+	Answers   []DNSRecord `yaml:"answers" json:"answers"`
+}
+
+// DNSRecord is a question or answer. Type is a name such as "A" or a
+// number; Data is the address or name an answer points to.
+type DNSRecord struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+	TTL  uint32 `yaml:"ttl" json:"ttl"`
+	Data string `yaml:"data" json:"data"`
+}
+
+// Parse reads a YAML or JSON spec, rejecting keys the spec does not
+// define.
+func Parse(data []byte) (*Spec, error) {
+	var spec Spec
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing packet spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// Load reads the spec at path.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading packet spec: %w", err)
+	}
+	return Parse(data)
+}
+
+// LinkType returns the capture link type for the spec.
+func (s *Spec) LinkType() (layers.LinkType, error) {
+	switch strings.ToLower(s.Link) {
+	case "", LinkEthernet:
+		return layers.LinkTypeEthernet, nil
+	case LinkRaw:
+		return layers.LinkTypeRaw, nil
+	}
+	return 0, fmt.Errorf("unknown link type %q", s.Link)
+}
+
+// Build serializes every packet in the spec, expanding repeats, and
+// assigns timestamps and 1-based indexes.
+func (s *Spec) Build() ([]common.Packet, error) {
+	linkType, err := s.LinkType()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Unix(0, 0).UTC()
+	if s.Start != "" {
+		if start, err = time.Parse(time.RFC3339Nano, s.Start); err != nil {
+			return nil, fmt.Errorf("invalid start time %q: %w", s.Start, err)
+		}
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	var out []common.Packet
+	ts := start
+	for i := range s.Packets {
+		p := &s.Packets[i]
+		if i > 0 {
+			ts = ts.Add(interval)
+		}
+		if p.Time != "" {
+			if ts, err = parseTime(p.Time, start); err != nil {
+				return nil, fmt.Errorf("packet %d: %w", i+1, err)
+			}
+		}
+		data, err := p.Serialize(linkType)
+		if err != nil {
+			return nil, fmt.Errorf("packet %d: %w", i+1, err)
+		}
+		repeat := p.Repeat
+		if repeat < 1 {
+			repeat = 1
+		}
+		for r := 0; r < repeat; r++ {
+			if r > 0 {
+				ts = ts.Add(interval)
+			}
+			out = append(out, common.Packet{
+				Data:        data,
+				CaptureInfo: gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)},
+				Index:       len(out) + 1,
+			})
+		}
+	}
+	return out, nil
+}
+
+func parseTime(s string, start time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, "+") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time offset %q: %w", s, err)
+		}
+		return start.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want RFC 3339 or +duration", s)
+	}
+	return t, nil
+}
+
+// WriteFile builds the spec and writes it to outFile, as pcapng when the
+// name ends in ".pcapng". It returns the number of packets written.
+func WriteFile(spec *Spec, outFile string, logger *common.Logger) (int, error) {
+	pkts, err := spec.Build()
+	if err != nil {
+		return 0, err
+	}
+	linkType, _ := spec.LinkType()
+
+	f, err := os.Create(outFile)
+	if err != nil {
+		return 0, fmt.Errorf("error creating output file: %w", err)
+	}
+	defer f.Close()
+
+	writer, err := pcapio.NewWriter(f, outFile, 65536, linkType)
+	if err != nil {
+		return 0, err
+	}
+	for _, pkt := range pkts {
+		if err := writer.WritePacket(pkt.CaptureInfo, pkt.Data); err != nil {
+			return 0, fmt.Errorf("error writing packet %d: %w", pkt.Index, err)
+		}
+	}
+	logger.Info("Wrote packets", "packets", len(pkts), "file", outFile)
+	return len(pkts), nil
+}
+
+// Serialize builds the frame for linkType with lengths and checksums
+// filled in.
+func (p *Packet) Serialize(linkType layers.LinkType) ([]byte, error) {
+	var stack []gopacket.SerializableLayer
+	// next is the EtherType of whatever follows the link layers.
+	var next layers.EthernetType
+	switch {
+	case p.ARP != nil:
+		next = layers.EthernetTypeARP
+	case p.IPv4 != nil:
+		next = layers.EthernetTypeIPv4
+	case p.IPv6 != nil:
+		next = layers.EthernetTypeIPv6
+	}
+
+	if linkType == layers.LinkTypeEthernet {
+		eth := &layers.Ethernet{SrcMAC: DefaultSrcMAC, DstMAC: DefaultDstMAC, EthernetType: next}
+		if p.Ethernet != nil {
+			var err error
+			if eth.SrcMAC, err = parseMAC(p.Ethernet.Src, DefaultSrcMAC); err != nil {
+				return nil, err
+			}
+			if eth.DstMAC, err = parseMAC(p.Ethernet.Dst, DefaultDstMAC); err != nil {
+				return nil, err
+			}
+		}
+		stack = append(stack, eth)
+		for i, v := range p.VLAN {
+			if i == 0 {
+				eth.EthernetType = layers.EthernetTypeDot1Q
+			}
+			tag := &layers.Dot1Q{VLANIdentifier: v.ID, Priority: v.PCP, Type: next}
+			if i < len(p.VLAN)-1 {
+				tag.Type = layers.EthernetTypeDot1Q
+			}
+			stack = append(stack, tag)
+		}
+	} else if p.Ethernet != nil || len(p.VLAN) > 0 || p.ARP != nil {
+		return nil, fmt.Errorf("link layer fields need an ethernet link type")
+	}
+
+	var network gopacket.NetworkLayer
+	switch {
+	case p.ARP != nil:
+		arp, err := p.ARP.layer()
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, arp)
+	case p.IPv4 != nil && p.IPv6 != nil:
+		return nil, fmt.Errorf("a packet cannot have both ipv4 and ipv6")
+	case p.IPv4 != nil:
+		ip, err := p.IPv4.layer(p.transportProtocol(false))
+		if err != nil {
+			return nil, err
+		}
+		stack, network = append(stack, ip), ip
+	case p.IPv6 != nil:
+		ip, err := p.IPv6.layer(p.transportProtocol(true))
+		if err != nil {
+			return nil, err
+		}
+		stack, network = append(stack, ip), ip
+	}
+
+	transports := 0
+	for _, set := range []bool{p.TCP != nil, p.UDP != nil, p.ICMPv4 != nil, p.ICMPv6 != nil} {
+		if set {
+			transports++
+		}
+	}
+	if transports > 1 {
+		return nil, fmt.Errorf("a packet can have only one of tcp, udp, icmpv4 and icmpv6")
+	}
+	if transports == 1 && network == nil {
+		return nil, fmt.Errorf("transport layer needs an ipv4 or ipv6 layer")
+	}
+	switch {
+	case p.TCP != nil:
+		tcp, err := p.TCP.layer()
+		if err != nil {
+			return nil, err
+		}
+		tcp.SetNetworkLayerForChecksum(network)
+		stack = append(stack, tcp)
+	case p.UDP != nil:
+		udp := &layers.UDP{SrcPort: layers.UDPPort(p.UDP.Src), DstPort: layers.UDPPort(p.UDP.Dst)}
+		udp.SetNetworkLayerForChecksum(network)
+		stack = append(stack, udp)
+	case p.ICMPv4 != nil:
+		if p.IPv4 == nil {
+			return nil, fmt.Errorf("icmpv4 needs an ipv4 layer")
+		}
+		stack = append(stack, &layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(p.ICMPv4.Type, p.ICMPv4.Code),
+			Id:       p.ICMPv4.ID,
+			Seq:      p.ICMPv4.Seq,
+		})
+	case p.ICMPv6 != nil:
+		if p.IPv6 == nil {
+			return nil, fmt.Errorf("icmpv6 needs an ipv6 layer")
+		}
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(p.ICMPv6.Type, p.ICMPv6.Code)}
+		icmp.SetNetworkLayerForChecksum(network)
+		stack = append(stack, icmp)
+		if t := p.ICMPv6.Type; t == layers.ICMPv6TypeEchoRequest || t == layers.ICMPv6TypeEchoReply {
+			stack = append(stack, &layers.ICMPv6Echo{Identifier: p.ICMPv6.ID, SeqNumber: p.ICMPv6.Seq})
+		}
+	}
+
+	if p.DNS != nil {
+		if p.TCP == nil && p.UDP == nil {
+			return nil, fmt.Errorf("dns needs a tcp or udp layer")
+		}
+		dns, err := p.DNS.layer()
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, dns)
+	}
+
+	payload := []byte(p.Payload)
+	if p.PayloadHex != "" {
+		b, err := hex.DecodeString(strings.ReplaceAll(p.PayloadHex, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload_hex: %w", err)
+		}
+		payload = append(payload, b...)
+	}
+	if len(payload) > 0 {
+		stack = append(stack, gopacket.Payload(payload))
+	}
+	if len(stack) == 0 {
+		return nil, fmt.Errorf("packet has no layers")
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, stack...); err != nil {
+		return nil, fmt.Errorf("error serializing packet: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// transportProtocol returns the IP protocol number of the transport layer.
+func (p *Packet) transportProtocol(v6 bool) layers.IPProtocol {
+	switch {
+	case p.TCP != nil:
+		return layers.IPProtocolTCP
+	case p.UDP != nil:
+		return layers.IPProtocolUDP
+	case p.ICMPv4 != nil:
+		return layers.IPProtocolICMPv4
+	case p.ICMPv6 != nil:
+		return layers.IPProtocolICMPv6
+	}
+	if v6 {
+		return layers.IPProtocolNoNextHeader
+	}
+	return layers.IPProtocol(253) // reserved for experimentation
+}
+
+func (a *ARP) layer() (*layers.ARP, error) {
+	senderMAC, err := parseMAC(a.SenderMAC, DefaultSrcMAC)
+	if err != nil {
+		return nil, err
+	}
+	targetMAC, err := parseMAC(a.TargetMAC, net.HardwareAddr{0, 0, 0, 0, 0, 0})
+	if err != nil {
+		return nil, err
+	}
+	senderIP, err := parseIP(a.SenderIP, false)
+	if err != nil {
+		return nil, err
+	}
+	targetIP, err := parseIP(a.TargetIP, false)
+	if err != nil {
+		return nil, err
+	}
+	op := a.Op
+	if op == 0 {
+		op = layers.ARPRequest
+	}
+	return &layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         op,
+		SourceHwAddress:   senderMAC,
+		SourceProtAddress: senderIP,
+		DstHwAddress:      targetMAC,
+		DstProtAddress:    targetIP,
+	}, nil
+}
+
+func (h *IPv4) layer(proto layers.IPProtocol) (*layers.IPv4, error) {
+	src, err := parseIP(h.Src, false)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseIP(h.Dst, false)
+	if err != nil {
+		return nil, err
+	}
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: h.TTL, TOS: h.TOS, Id: h.ID, Protocol: proto, SrcIP: src, DstIP: dst}
+	if ip.TTL == 0 {
+		ip.TTL = 64
+	}
+	if h.DF {
+		ip.Flags = layers.IPv4DontFragment
+	}
+	return ip, nil
+}
+
+func (h *IPv6) layer(proto layers.IPProtocol) (*layers.IPv6, error) {
+	src, err := parseIP(h.Src, true)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseIP(h.Dst, true)
+	if err != nil {
+		return nil, err
+	}
+	ip := &layers.IPv6{Version: 6, HopLimit: h.HopLimit, TrafficClass: h.TrafficClass,
+		FlowLabel: h.FlowLabel, NextHeader: proto, SrcIP: src, DstIP: dst}
+	if ip.HopLimit == 0 {
+		ip.HopLimit = 64
+	}
+	return ip, nil
+}
+
+func (h *TCP) layer() (*layers.TCP, error) {
+	tcp := &layers.TCP{SrcPort: layers.TCPPort(h.Src), DstPort: layers.TCPPort(h.Dst),
+		Seq: h.Seq, Ack: h.Ack, Window: h.Window}
+	if tcp.Window == 0 {
+		tcp.Window = 65535
+	}
+	for _, c := range strings.ToUpper(h.Flags) {
+		switch c {
+		case 'F':
+			tcp.FIN = true
+		case 'S':
+			tcp.SYN = true
+		case 'R':
+			tcp.RST = true
+		case 'P':
+			tcp.PSH = true
+		case 'A':
+			tcp.ACK = true
+		case 'U':
+			tcp.URG = true
+		case 'E':
+			tcp.ECE = true
+		case 'C':
+			tcp.CWR = true
+		default:
+			return nil, fmt.Errorf("unknown TCP flag %q", c)
+		}
+	}
+	return tcp, nil
+}
+
+func (d *DNS) layer() (*layers.DNS, error) {
+	dns := &layers.DNS{ID: d.ID, QR: d.Response, RD: true, RA: d.Response}
+	for _, q := range d.Questions {
+		t, err := parseDNSType(q.Type)
+		if err != nil {
+			return nil, err
+		}
+		dns.Questions = append(dns.Questions, layers.DNSQuestion{Name: []byte(q.Name), Type: t, Class: layers.DNSClassIN})
+	}
+	for _, a := range d.Answers {
+		t, err := parseDNSType(a.Type)
+		if err != nil {
+			return nil, err
+		}
+		rr := layers.DNSResourceRecord{Name: []byte(a.Name), Type: t, Class: layers.DNSClassIN, TTL: a.TTL}
+		switch t {
+		case layers.DNSTypeA, layers.DNSTypeAAAA:
+			ip, err := parseIP(a.Data, t == layers.DNSTypeAAAA)
+			if err != nil {
+				return nil, err
+			}
+			rr.IP = ip
+		case layers.DNSTypeCNAME:
+			rr.CNAME = []byte(a.Data)
+		case layers.DNSTypeNS:
+			rr.NS = []byte(a.Data)
+		case layers.DNSTypePTR:
+			rr.PTR = []byte(a.Data)
+		case layers.DNSTypeTXT:
+			rr.TXTs = [][]byte{[]byte(a.Data)}
+		default:
+			return nil, fmt.Errorf("unsupported DNS answer type %s", t)
+		}
+		dns.Answers = append(dns.Answers, rr)
+	}
+	return dns, nil
+}
+
+var dnsTypes = map[string]layers.DNSType{
+	"A": layers.DNSTypeA, "AAAA": layers.DNSTypeAAAA, "CNAME": layers.DNSTypeCNAME,
+	"MX": layers.DNSTypeMX, "NS": layers.DNSTypeNS, "PTR": layers.DNSTypePTR,
+	"SOA": layers.DNSTypeSOA, "SRV": layers.DNSTypeSRV, "TXT": layers.DNSTypeTXT,
+}
+
+func parseDNSType(s string) (layers.DNSType, error) {
+	if s == "" {
+		return layers.DNSTypeA, nil
+	}
+	if t, ok := dnsTypes[strings.ToUpper(s)]; ok {
+		return t, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown DNS type %q", s)
+	}
+	return layers.DNSType(n), nil
+}
+
+func parseMAC(s string, def net.HardwareAddr) (net.HardwareAddr, error) {
+	if s == "" {
+		return def, nil
+	}
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address %q", s)
+	}
+	return mac, nil
+}
+
+func parseIP(s string, v6 bool) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	if v6 {
+		if ip.To4() != nil {
+			return nil, fmt.Errorf("expected an IPv6 address, got %s", s)
+		}
+		return ip, nil
+	}
+	if ip = ip.To4(); ip == nil {
+		return nil, fmt.Errorf("expected an IPv4 address, got %s", s)
+	}
+	return ip, nil
+}
@@ -0,0 +1,155 @@
+package craft_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/craft"
+	"osi-replay/pkg/pcapio"
+)
+
+const yamlSpec = `
+start: 2024-01-01T00:00:00Z
+interval: 10ms
+packets:
+  - ethernet: {src: "00:11:22:33:44:55", dst: "66:77:88:99:aa:bb"}
+    vlan: [{id: 100, pcp: 3}]
+    ipv4: {src: 10.0.0.1, dst: 10.0.0.2, df: true}
+    tcp: {src: 40000, dst: 80, seq: 1000, flags: S}
+  - time: "+1s"
+    repeat: 2
+    ipv4: {src: 10.0.0.1, dst: 10.0.0.53}
+    udp: {src: 5353, dst: 53}
+    dns:
+      id: 7
+      questions: [{name: example.com, type: A}]
+  - ipv6: {src: "2001:db8::1", dst: "2001:db8::2"}
+    icmpv6: {type: 128, id: 1, seq: 2}
+    payload: ping
+`
+
+func TestBuild_YAML(t *testing.T) {
+	spec, err := craft.Parse([]byte(yamlSpec))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	pkts, err := spec.Build()
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	if len(pkts) != 4 {
+		t.Fatalf("Expected 4 packets, got %d", len(pkts))
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	wantTimes := []time.Time{start, start.Add(time.Second), start.Add(time.Second + 10*time.Millisecond), start.Add(time.Second + 20*time.Millisecond)}
+	for i, pkt := range pkts {
+		if !pkt.CaptureInfo.Timestamp.Equal(wantTimes[i]) {
+			t.Errorf("Packet %d: expected time %v, got %v", i+1, wantTimes[i], pkt.CaptureInfo.Timestamp)
+		}
+		if pkt.Index != i+1 || pkt.CaptureInfo.CaptureLength != len(pkt.Data) {
+			t.Errorf("Packet %d: bad index or length", i+1)
+		}
+	}
+
+	p := gopacket.NewPacket(pkts[0].Data, layers.LayerTypeEthernet, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatalf("Decode error: %v", p.ErrorLayer().Error())
+	}
+	vlan, _ := p.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+	ip, _ := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	tcp, _ := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if vlan == nil || vlan.VLANIdentifier != 100 || vlan.Priority != 3 {
+		t.Errorf("Unexpected VLAN tag %+v", vlan)
+	}
+	if ip == nil || ip.Length != 40 || ip.Flags != layers.IPv4DontFragment || ip.Checksum == 0 {
+		t.Errorf("Unexpected IPv4 header %+v", ip)
+	}
+	if tcp == nil || !tcp.SYN || tcp.Seq != 1000 || tcp.Checksum == 0 {
+		t.Errorf("Unexpected TCP header %+v", tcp)
+	}
+
+	p = gopacket.NewPacket(pkts[1].Data, layers.LayerTypeEthernet, gopacket.Default)
+	dns, _ := p.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	if dns == nil || dns.ID != 7 || len(dns.Questions) != 1 || string(dns.Questions[0].Name) != "example.com" {
+		t.Errorf("Unexpected DNS layer %+v", dns)
+	}
+
+	p = gopacket.NewPacket(pkts[3].Data, layers.LayerTypeEthernet, gopacket.Default)
+	echo, _ := p.Layer(layers.LayerTypeICMPv6Echo).(*layers.ICMPv6Echo)
+	if echo == nil || echo.Identifier != 1 || echo.SeqNumber != 2 {
+		t.Errorf("Unexpected ICMPv6 echo %+v", echo)
+	}
+	// gopacket's ICMPv6Echo decoder does not expose the payload.
+	if !bytes.HasSuffix(pkts[3].Data, []byte("ping")) {
+		t.Errorf("Expected echo payload %q in %x", "ping", pkts[3].Data)
+	}
+}
+
+func TestWriteFile_JSONRawLink(t *testing.T) {
+	spec, err := craft.Parse([]byte(`{"link": "raw", "packets": [{"ipv4": {"src": "192.0.2.1", "dst": "192.0.2.2"}, "icmpv4": {"type": 8, "id": 1, "seq": 1}}]}`))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	out := filepath.Join(t.TempDir(), "out.pcap")
+	n, err := craft.WriteFile(spec, out, common.NewLogger("test"))
+	if err != nil || n != 1 {
+		t.Fatalf("WriteFile returned %d, %v", n, err)
+	}
+
+	f, err := pcapio.Open(out)
+	if err != nil {
+		t.Fatalf("Error opening output: %v", err)
+	}
+	defer f.Close()
+	if f.LinkType() != layers.LinkTypeRaw {
+		t.Errorf("Expected raw link type, got %s", f.LinkType())
+	}
+	data, _, err := f.ReadPacketData()
+	if err != nil {
+		t.Fatalf("Error reading packet: %v", err)
+	}
+	p := gopacket.NewPacket(data, layers.LayerTypeIPv4, gopacket.Default)
+	icmp, _ := p.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if icmp == nil || icmp.TypeCode.Type() != layers.ICMPv4TypeEchoRequest || icmp.Checksum == 0 {
+		t.Errorf("Unexpected ICMPv4 layer %+v", icmp)
+	}
+}
+
+func TestParse_UnknownFields(t *testing.T) {
+	for name, spec := range map[string]string{
+		"misspelled ttl":   "packets: [{ipv4: {src: 10.0.0.1, dst: 10.0.0.2, tll: 5}}]",
+		"tcp field in udp": "packets: [{ipv4: {src: 10.0.0.1, dst: 10.0.0.2}, udp: {src_port: 53}}]",
+		"unknown top key":  `{"link": "raw", "packet": [{"payload": "x"}]}`,
+	} {
+		if _, err := craft.Parse([]byte(spec)); err == nil {
+			t.Errorf("%s: expected Parse error", name)
+		}
+	}
+}
+
+func TestBuild_Errors(t *testing.T) {
+	for name, spec := range map[string]string{
+		"bad link":         `{"link": "token-ring", "packets": [{"payload": "x"}]}`,
+		"bad ip":           `{"packets": [{"ipv4": {"src": "nope", "dst": "10.0.0.1"}}]}`,
+		"tcp without ip":   `{"packets": [{"tcp": {"src": 1, "dst": 2}}]}`,
+		"two transports":   `{"packets": [{"ipv4": {"src": "10.0.0.1", "dst": "10.0.0.2"}, "tcp": {}, "udp": {}}]}`,
+		"bad flag":         `{"packets": [{"ipv4": {"src": "10.0.0.1", "dst": "10.0.0.2"}, "tcp": {"flags": "SX"}}]}`,
+		"vlan on raw link": `{"link": "raw", "packets": [{"vlan": [{"id": 1}], "ipv4": {"src": "10.0.0.1", "dst": "10.0.0.2"}}]}`,
+		"bad time":         `{"packets": [{"time": "later", "payload": "x"}]}`,
+	} {
+		s, err := craft.Parse([]byte(spec))
+		if err != nil {
+			t.Fatalf("%s: Parse returned error: %v", name, err)
+		}
+		if _, err := s.Build(); err == nil {
+			t.Errorf("%s: expected Build error", name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+)
+
+// DefaultWindow is a reasonable window for SPAN-port duplicates, which
+// normally arrive microseconds apart.
+const DefaultWindow = 10 * time.Millisecond
+
+type key [16]byte
+
+type seen struct {
+	key key
+	ts  time.Time
+}
+
+// Filter drops frames identical to one seen within the configured window.
+// Frames carrying IP are compared from the IP header on, with TTL/hop
+// limit and the IPv4 header checksum ignored, so copies mirrored on both
+// sides of a router match despite their different MACs, VLAN tags and TTL.
+type Filter struct {
+	window  time.Duration
+	last    map[key]time.Time
+	queue   []seen
+	Dropped int
+}
+
+// New returns a Filter using window; a non-positive window uses DefaultWindow.
+func New(window time.Duration) *Filter {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Filter{window: window, last: make(map[key]time.Time)}
+}
+
+// Duplicate reports whether data, captured at ts, repeats a frame seen
+// within the window, and remembers it otherwise. Timestamps are expected
+// to be non-decreasing.
+func (f *Filter) Duplicate(data []byte, ts time.Time) bool {
+	f.expire(ts)
+
+	k := hashFrame(data)
+	if prev, ok := f.last[k]; ok && ts.Sub(prev) <= f.window {
+		f.Dropped++
+		return true
+	}
+	f.last[k] = ts
+	f.queue = append(f.queue, seen{key: k, ts: ts})
+	return false
+}
+
+// Name implements common.Stage.
+func (f *Filter) Name() string { return "dedup" }
+
+// Process implements common.Stage.
+func (f *Filter) Process(pkt common.Packet) ([]common.Packet, error) {
+	if f.Duplicate(pkt.Data, pkt.CaptureInfo.Timestamp) {
+		return nil, nil
+	}
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage.
+func (f *Filter) Flush() ([]common.Packet, error) { return nil, nil }
+
+// expire forgets frames that fell out of the window ending at now.
+func (f *Filter) expire(now time.Time) {
+	cutoff := now.Add(-f.window)
+	var i int
+	for ; i < len(f.queue) && f.queue[i].ts.Before(cutoff); i++ {
+		if f.last[f.queue[i].key].Equal(f.queue[i].ts) {
+			delete(f.last, f.queue[i].key)
+		}
+	}
+	f.queue = f.queue[i:]
+}
+
+// hashFrame hashes data from its first IP header on (the whole frame when
+// there is none) with the IP fields that legitimately differ between
+// copies of the same packet zeroed out.
+func hashFrame(data []byte) key {
+	buf := make([]byte, len(data))
+	copy(buf, data)
+
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+	offset, start := 0, -1
+	for _, l := range packet.Layers() {
+		switch l.LayerType() {
+		case layers.LayerTypeIPv4, layers.LayerTypeIPv6:
+			if start < 0 {
+				start = offset
+			}
+		}
+		switch l.LayerType() {
+		case layers.LayerTypeIPv4:
+			if offset+12 <= len(buf) {
+				buf[offset+8] = 0 // TTL
+				buf[offset+10], buf[offset+11] = 0, 0
+			}
+		case layers.LayerTypeIPv6:
+			if offset+8 <= len(buf) {
+				buf[offset+7] = 0 // hop limit
+			}
+		}
+		offset += len(l.LayerContents())
+	}
+
+	if start > 0 {
+		buf = buf[start:]
+	}
+	h := fnv.New128a()
+	h.Write(buf)
+	var k key
+	copy(k[:], h.Sum(nil))
+	return k
+}
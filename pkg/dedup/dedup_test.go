@@ -0,0 +1,92 @@
+package dedup_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/dedup"
+)
+
+func buildFrame(t *testing.T, ttl uint8, payload byte) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      ttl,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("192.168.1.100"),
+		DstIP:    net.ParseIP("192.168.1.200"),
+	}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip4)
+
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload{payload}); err != nil {
+		t.Fatalf("Error serializing layers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDuplicate_IgnoresTTL(t *testing.T) {
+	f := dedup.New(time.Second)
+	start := time.Now()
+
+	if f.Duplicate(buildFrame(t, 64, 1), start) {
+		t.Fatalf("First frame reported as duplicate")
+	}
+	if !f.Duplicate(buildFrame(t, 63, 1), start.Add(time.Millisecond)) {
+		t.Errorf("Expected frame differing only in TTL to be a duplicate")
+	}
+	if f.Duplicate(buildFrame(t, 64, 2), start.Add(2*time.Millisecond)) {
+		t.Errorf("Frame with different payload reported as duplicate")
+	}
+	if f.Dropped != 1 {
+		t.Errorf("Expected 1 dropped frame, got %d", f.Dropped)
+	}
+}
+
+func TestDuplicate_OutsideWindow(t *testing.T) {
+	f := dedup.New(10 * time.Millisecond)
+	start := time.Now()
+
+	f.Duplicate(buildFrame(t, 64, 1), start)
+	if f.Duplicate(buildFrame(t, 64, 1), start.Add(time.Second)) {
+		t.Errorf("Frame repeated outside the window reported as duplicate")
+	}
+}
+
+func TestDuplicate_RoutedCopy(t *testing.T) {
+	f := dedup.New(time.Second)
+	start := time.Now()
+
+	// The routed copy has new MACs, a VLAN tag and a decremented TTL.
+	routed := buildFrame(t, 63, 1)
+	copy(routed[0:12], []byte{0x02, 0, 0, 0, 0, 1, 0x02, 0, 0, 0, 0, 2})
+	tagged := append([]byte{}, routed[:12]...)
+	tagged = append(tagged, 0x81, 0x00, 0x00, 0x64)
+	tagged = append(tagged, routed[12:]...)
+
+	if f.Duplicate(buildFrame(t, 64, 1), start) {
+		t.Fatalf("First frame reported as duplicate")
+	}
+	if !f.Duplicate(routed, start.Add(time.Millisecond)) {
+		t.Errorf("Expected copy with different MACs and TTL to be a duplicate")
+	}
+	if !f.Duplicate(tagged, start.Add(2*time.Millisecond)) {
+		t.Errorf("Expected VLAN-tagged copy to be a duplicate")
+	}
+}
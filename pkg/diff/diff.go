@@ -0,0 +1,351 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"strings"
+
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Alignment modes.
+const (
+	// AlignIndex pairs the Nth packet of A with the Nth packet of B.
+	AlignIndex = "index"
+	// AlignHash pairs packets whose content matches once addresses and
+	// checksums, the fields a rewrite changes, are masked out.
+	AlignHash = "hash"
+)
+
+// Options controls Compare.
+type Options struct {
+	Align string
+	// Ignore lists layers ("IPv4") or fields ("IPv4.Checksum") left out of
+	// the field comparison.
+	Ignore []string
+}
+
+// FieldDiff is one field that differs between a pair of packets.
+type FieldDiff struct {
+	Layer string `json:"layer"`
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// PacketDiff lists the differences for a pair of aligned packets.
+// Indexes are 1-based.
+type PacketDiff struct {
+	IndexA int         `json:"index_a"`
+	IndexB int         `json:"index_b"`
+	Fields []FieldDiff `json:"fields"`
+}
+
+// Summary counts the outcome of a comparison.
+type Summary struct {
+	Align     string `json:"align"`
+	PacketsA  int    `json:"packets_a"`
+	PacketsB  int    `json:"packets_b"`
+	Identical int    `json:"identical"`
+	Modified  int    `json:"modified"`
+	OnlyA     int    `json:"only_a"`
+	OnlyB     int    `json:"only_b"`
+}
+
+// Result is the outcome of Compare.
+type Result struct {
+	Summary  Summary      `json:"summary"`
+	Modified []PacketDiff `json:"modified"`
+	// OnlyA and OnlyB hold the 1-based indexes of unmatched packets.
+	OnlyA []int `json:"only_a"`
+	OnlyB []int `json:"only_b"`
+}
+
+// Equal reports whether the captures matched completely.
+func (r *Result) Equal() bool {
+	s := r.Summary
+	return s.Modified == 0 && s.OnlyA == 0 && s.OnlyB == 0
+}
+
+type record struct {
+	index int
+	data  []byte
+	ci    gopacket.CaptureInfo
+}
+
+// Compare aligns the captures at pathA and pathB and reports how they
+// differ. A is streamed; in hash mode the packets of B are held in memory
+// until matched.
+func Compare(pathA, pathB string, opts *Options) (*Result, error) {
+	align := opts.Align
+	if align == "" {
+		align = AlignIndex
+	}
+	if align != AlignIndex && align != AlignHash {
+		return nil, fmt.Errorf("unknown alignment %q", align)
+	}
+
+	fa, err := pcapio.Open(pathA)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", pathA, err)
+	}
+	defer fa.Close()
+	fb, err := pcapio.Open(pathB)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", pathB, err)
+	}
+	defer fb.Close()
+
+	c := &comparer{
+		res:    &Result{Summary: Summary{Align: align}},
+		ignore: make(map[string]bool),
+		linkA:  fa.LinkType(),
+		linkB:  fb.LinkType(),
+	}
+	for _, name := range opts.Ignore {
+		c.ignore[name] = true
+	}
+
+	if align == AlignIndex {
+		err = c.byIndex(fa, fb)
+	} else {
+		err = c.byHash(fa, fb)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.res, nil
+}
+
+type comparer struct {
+	res          *Result
+	ignore       map[string]bool
+	linkA, linkB layers.LinkType
+}
+
+func next(r pcapio.Reader, index *int) (*record, error) {
+	data, ci, err := r.ReadPacketData()
+	if err != nil {
+		return nil, err
+	}
+	*index++
+	return &record{index: *index, data: data, ci: ci}, nil
+}
+
+func (c *comparer) byIndex(fa, fb pcapio.Reader) error {
+	s := &c.res.Summary
+	for {
+		a, errA := next(fa, &s.PacketsA)
+		b, errB := next(fb, &s.PacketsB)
+		if errA != nil && errA != io.EOF {
+			return fmt.Errorf("error reading capture A: %w", errA)
+		}
+		if errB != nil && errB != io.EOF {
+			return fmt.Errorf("error reading capture B: %w", errB)
+		}
+		switch {
+		case a == nil && b == nil:
+			return nil
+		case b == nil:
+			c.onlyA(a.index)
+		case a == nil:
+			c.onlyB(b.index)
+		default:
+			c.pair(a, b)
+		}
+	}
+}
+
+func (c *comparer) byHash(fa, fb pcapio.Reader) error {
+	s := &c.res.Summary
+	pending := make(map[[16]byte][]*record)
+	var order []*record
+	for {
+		b, err := next(fb, &s.PacketsB)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading capture B: %w", err)
+		}
+		h := maskedHash(b.data, c.linkB)
+		pending[h] = append(pending[h], b)
+		order = append(order, b)
+	}
+
+	matched := make(map[int]bool)
+	for {
+		a, err := next(fa, &s.PacketsA)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading capture A: %w", err)
+		}
+		h := maskedHash(a.data, c.linkA)
+		queue := pending[h]
+		if len(queue) == 0 {
+			c.onlyA(a.index)
+			continue
+		}
+		b := queue[0]
+		pending[h] = queue[1:]
+		matched[b.index] = true
+		c.pair(a, b)
+	}
+	for _, b := range order {
+		if !matched[b.index] {
+			c.onlyB(b.index)
+		}
+	}
+	return nil
+}
+
+func (c *comparer) onlyA(i int) {
+	c.res.OnlyA = append(c.res.OnlyA, i)
+	c.res.Summary.OnlyA++
+}
+
+func (c *comparer) onlyB(i int) {
+	c.res.OnlyB = append(c.res.OnlyB, i)
+	c.res.Summary.OnlyB++
+}
+
+func (c *comparer) pair(a, b *record) {
+	fields := c.fieldDiffs(a, b)
+	if len(fields) == 0 {
+		c.res.Summary.Identical++
+		return
+	}
+	c.res.Summary.Modified++
+	c.res.Modified = append(c.res.Modified, PacketDiff{IndexA: a.index, IndexB: b.index, Fields: fields})
+}
+
+// fieldDiffs decodes both packets and compares them layer by layer.
+func (c *comparer) fieldDiffs(a, b *record) []FieldDiff {
+	var out []FieldDiff
+	add := func(layer, field string, va, vb interface{}) {
+		if c.ignore[layer] || c.ignore[layer+"."+field] {
+			return
+		}
+		sa, sb := fmt.Sprint(va), fmt.Sprint(vb)
+		if sa != sb {
+			out = append(out, FieldDiff{Layer: layer, Field: field, A: sa, B: sb})
+		}
+	}
+
+	add("Frame", "Timestamp", a.ci.Timestamp.UTC(), b.ci.Timestamp.UTC())
+	add("Frame", "Length", a.ci.Length, b.ci.Length)
+	if bytes.Equal(a.data, b.data) {
+		return out
+	}
+
+	la := gopacket.NewPacket(a.data, c.linkA, gopacket.Default).Layers()
+	lb := gopacket.NewPacket(b.data, c.linkB, gopacket.Default).Layers()
+	for i := 0; i < len(la) || i < len(lb); i++ {
+		switch {
+		case i >= len(lb):
+			add(la[i].LayerType().String(), "", "present", "missing")
+			continue
+		case i >= len(la):
+			add(lb[i].LayerType().String(), "", "missing", "present")
+			continue
+		}
+		name := la[i].LayerType().String()
+		if la[i].LayerType() != lb[i].LayerType() {
+			add("Layer", fmt.Sprint(i), name, lb[i].LayerType())
+			break
+		}
+		va, vb := reflect.Indirect(reflect.ValueOf(la[i])), reflect.Indirect(reflect.ValueOf(lb[i]))
+		if va.Kind() != reflect.Struct {
+			// Payload and other raw layers.
+			if !bytes.Equal(la[i].LayerContents(), lb[i].LayerContents()) {
+				add(name, "Contents", summarize(la[i].LayerContents()), summarize(lb[i].LayerContents()))
+			}
+			continue
+		}
+		for f := 0; f < va.NumField(); f++ {
+			sf := va.Type().Field(f)
+			if !sf.IsExported() || sf.Anonymous || sf.Type.Kind() == reflect.Func {
+				continue
+			}
+			add(name, sf.Name, va.Field(f).Interface(), vb.Field(f).Interface())
+		}
+	}
+	return out
+}
+
+// summarize shortens raw bytes for display.
+func summarize(b []byte) string {
+	const max = 16
+	if len(b) <= max {
+		return fmt.Sprintf("%d bytes %x", len(b), b)
+	}
+	return fmt.Sprintf("%d bytes %x...", len(b), b[:max])
+}
+
+// maskedHash hashes data with MAC and IP addresses and the IP, TCP and
+// UDP checksums zeroed, so a packet still matches itself after rewriting.
+func maskedHash(data []byte, linkType layers.LinkType) [16]byte {
+	masked := append([]byte(nil), data...)
+	packet := gopacket.NewPacket(masked, linkType, gopacket.DecodeOptions{NoCopy: true, Lazy: true})
+	var off int
+	for _, l := range packet.Layers() {
+		b := masked[off:]
+		switch l.(type) {
+		case *layers.Ethernet:
+			clear(b[0:12])
+		case *layers.IPv4:
+			clear(b[10:12])
+			clear(b[12:20])
+		case *layers.IPv6:
+			clear(b[8:40])
+		case *layers.TCP:
+			clear(b[16:18])
+		case *layers.UDP:
+			clear(b[6:8])
+		}
+		off += len(l.LayerContents())
+	}
+	h := fnv.New128a()
+	h.Write(masked)
+	var k [16]byte
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+// WriteText writes a human-readable report to w.
+func (r *Result) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	for _, d := range r.Modified {
+		fmt.Fprintf(&sb, "Packet A#%d <-> B#%d:\n", d.IndexA, d.IndexB)
+		for _, f := range d.Fields {
+			fmt.Fprintf(&sb, "  %s.%s: %s -> %s\n", f.Layer, f.Field, f.A, f.B)
+		}
+	}
+	if len(r.OnlyA) > 0 {
+		fmt.Fprintf(&sb, "Only in A: %s\n", joinInts(r.OnlyA))
+	}
+	if len(r.OnlyB) > 0 {
+		fmt.Fprintf(&sb, "Only in B: %s\n", joinInts(r.OnlyB))
+	}
+	s := r.Summary
+	fmt.Fprintf(&sb, "Summary (%s alignment): A=%d B=%d identical=%d modified=%d only-A=%d only-B=%d\n",
+		s.Align, s.PacketsA, s.PacketsB, s.Identical, s.Modified, s.OnlyA, s.OnlyB)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func joinInts(v []int) string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = fmt.Sprint(n)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,147 @@
+package diff_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/diff"
+	"osi-replay/pkg/rewriter"
+)
+
+func udpPacket(t *testing.T, src, dst string, payload string) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP(src), DstIP: net.ParseIP(dst)}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 9999}
+	udp.SetNetworkLayerForChecksum(ip4)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("Error serializing packet: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func writeCapture(t *testing.T, path string, pkts [][]byte) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	for i, data := range pkts {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1700000000+int64(i), 0), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("Error writing packet: %v", err)
+		}
+	}
+}
+
+func TestCompare_Identical(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.pcap")
+	writeCapture(t, path, [][]byte{udpPacket(t, "10.0.0.1", "10.0.0.2", "one")})
+	res, err := diff.Compare(path, path, &diff.Options{})
+	if err != nil {
+		t.Fatalf("Compare returned error: %v", err)
+	}
+	if !res.Equal() || res.Summary.Identical != 1 {
+		t.Errorf("Expected identical captures, got %+v", res.Summary)
+	}
+}
+
+func TestCompare_HashAlignmentAfterRewrite(t *testing.T) {
+	dir := t.TempDir()
+	pathA, pathB := filepath.Join(dir, "a.pcap"), filepath.Join(dir, "b.pcap")
+	p1 := udpPacket(t, "10.0.0.1", "10.0.0.2", "one")
+	p2 := udpPacket(t, "10.0.0.1", "10.0.0.2", "two")
+	p3 := udpPacket(t, "10.0.0.1", "10.0.0.2", "three")
+	writeCapture(t, pathA, [][]byte{p1, p2, p3})
+
+	cfg := &rewriter.RewriteConfig{IPMapSrc: map[string]string{"10.0.0.1": "192.168.1.1"}}
+	var rewritten [][]byte
+	for _, p := range [][]byte{p2, p3} {
+		data, err := rewriter.RewritePacket(p, cfg)
+		if err != nil {
+			t.Fatalf("RewritePacket returned error: %v", err)
+		}
+		rewritten = append(rewritten, data)
+	}
+	rewritten = append(rewritten, udpPacket(t, "10.0.0.9", "10.0.0.2", "extra"))
+	writeCapture(t, pathB, rewritten)
+
+	res, err := diff.Compare(pathA, pathB, &diff.Options{Align: diff.AlignHash, Ignore: []string{"Frame.Timestamp"}})
+	if err != nil {
+		t.Fatalf("Compare returned error: %v", err)
+	}
+	s := res.Summary
+	if s.Modified != 2 || s.OnlyA != 1 || s.OnlyB != 1 || res.Equal() {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+	if res.OnlyA[0] != 1 || res.OnlyB[0] != 3 {
+		t.Errorf("Expected A#1 and B#3 unmatched, got %v and %v", res.OnlyA, res.OnlyB)
+	}
+	d := res.Modified[0]
+	if d.IndexA != 2 || d.IndexB != 1 {
+		t.Errorf("Expected A#2 paired with B#1, got A#%d B#%d", d.IndexA, d.IndexB)
+	}
+	var sawSrc bool
+	for _, f := range d.Fields {
+		if f.Layer == "IPv4" && f.Field == "SrcIP" {
+			sawSrc = f.A == "10.0.0.1" && f.B == "192.168.1.1"
+		}
+		if f.Layer == "Payload" || f.Field == "DstIP" {
+			t.Errorf("Unexpected difference %+v", f)
+		}
+	}
+	if !sawSrc {
+		t.Errorf("Expected IPv4.SrcIP difference, got %+v", d.Fields)
+	}
+
+	var buf bytes.Buffer
+	if err := res.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "IPv4.SrcIP: 10.0.0.1 -> 192.168.1.1") {
+		t.Errorf("Unexpected text report:\n%s", buf.String())
+	}
+}
+
+func TestCompare_IndexAlignmentWithIgnore(t *testing.T) {
+	dir := t.TempDir()
+	pathA, pathB := filepath.Join(dir, "a.pcap"), filepath.Join(dir, "b.pcap")
+	writeCapture(t, pathA, [][]byte{udpPacket(t, "10.0.0.1", "10.0.0.2", "x"), udpPacket(t, "10.0.0.1", "10.0.0.2", "y")})
+	writeCapture(t, pathB, [][]byte{udpPacket(t, "10.0.0.5", "10.0.0.2", "x")})
+
+	res, err := diff.Compare(pathA, pathB, &diff.Options{Ignore: []string{"IPv4.SrcIP", "IPv4.Checksum", "UDP.Checksum"}})
+	if err != nil {
+		t.Fatalf("Compare returned error: %v", err)
+	}
+	s := res.Summary
+	if s.Identical != 1 || s.Modified != 0 || s.OnlyA != 1 || s.OnlyB != 0 {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+}
+
+func TestCompare_UnknownAlignment(t *testing.T) {
+	if _, err := diff.Compare("a", "b", &diff.Options{Align: "fuzzy"}); err == nil {
+		t.Error("Expected error for unknown alignment")
+	}
+}
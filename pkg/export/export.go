@@ -0,0 +1,344 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+)
+
+// Output formats.
+const (
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+)
+
+// DefaultCSVFields are the columns written in CSV mode when no fields are
+// selected.
+var DefaultCSVFields = []string{
+	"index", "timestamp", "length", "protocols",
+	"IPv4.SrcIP", "IPv4.DstIP", "IPv6.SrcIP", "IPv6.DstIP",
+	"TCP.SrcPort", "TCP.DstPort", "UDP.SrcPort", "UDP.DstPort",
+}
+
+// Options controls the Encoder.
+type Options struct {
+	Format string
+	// Fields selects what is written: frame fields (index, timestamp,
+	// length, capture_length, protocols) and layer fields such as
+	// "IPv4.SrcIP" or whole layers such as "TCP". Empty means everything,
+	// or DefaultCSVFields in CSV mode.
+	Fields []string
+}
+
+// Record is one decoded packet.
+type Record struct {
+	Index         int       `json:"index"`
+	Timestamp     time.Time `json:"timestamp"`
+	Length        int       `json:"length"`
+	CaptureLength int       `json:"capture_length"`
+	// Protocols lists the decoded layers, e.g. "Ethernet:IPv4:UDP:DNS".
+	Protocols string `json:"protocols"`
+	// Layers maps each layer name to its fields. A repeated layer, such
+	// as the inner header of a tunnel, gets a suffix: "IPv4_2".
+	Layers map[string]map[string]any `json:"layers"`
+}
+
+// Decode converts packet, the index-th in its capture, into a Record.
+func Decode(packet gopacket.Packet, index int) *Record {
+	md := packet.Metadata()
+	r := &Record{
+		Index:         index,
+		Timestamp:     md.Timestamp,
+		Length:        md.Length,
+		CaptureLength: md.CaptureLength,
+		Layers:        make(map[string]map[string]any),
+	}
+	var names []string
+	for _, l := range packet.Layers() {
+		name := l.LayerType().String()
+		names = append(names, name)
+		key := name
+		for n := 2; r.Layers[key] != nil; n++ {
+			key = fmt.Sprintf("%s_%d", name, n)
+		}
+		r.Layers[key] = layerFields(l)
+	}
+	r.Protocols = strings.Join(names, ":")
+	return r
+}
+
+// Field returns the value of a frame field, layer or "Layer.Field".
+func (r *Record) Field(name string) (any, bool) {
+	switch name {
+	case "index":
+		return r.Index, true
+	case "timestamp":
+		return r.Timestamp.UTC().Format(time.RFC3339Nano), true
+	case "length":
+		return r.Length, true
+	case "capture_length":
+		return r.CaptureLength, true
+	case "protocols":
+		return r.Protocols, true
+	}
+	layer, field, hasField := strings.Cut(name, ".")
+	fields, ok := r.Layers[layer]
+	if !ok {
+		return nil, false
+	}
+	if !hasField {
+		return fields, true
+	}
+	v, ok := fields[field]
+	return v, ok
+}
+
+// layerFields returns the exported fields of a decoded layer. Layers that
+// are raw bytes, such as the application payload, are given as hex.
+func layerFields(l gopacket.Layer) map[string]any {
+	if df, ok := l.(*gopacket.DecodeFailure); ok {
+		return map[string]any{"error": df.Error().Error(), "hex": hex.EncodeToString(df.LayerContents())}
+	}
+	v := reflect.Indirect(reflect.ValueOf(l))
+	if v.Kind() != reflect.Struct {
+		return map[string]any{"length": len(l.LayerContents()), "hex": hex.EncodeToString(l.LayerContents())}
+	}
+	return structFields(v)
+}
+
+func structFields(v reflect.Value) map[string]any {
+	out := make(map[string]any)
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		sf := t.Field(i)
+		// Skip the embedded BaseLayer, which repeats the raw bytes.
+		if !sf.IsExported() || sf.Anonymous {
+			continue
+		}
+		if val, ok := value(v.Field(i), sf.Name); ok {
+			out[sf.Name] = val
+		}
+	}
+	return out
+}
+
+// nameFields are byte fields holding text, such as DNS names.
+var nameFields = map[string]bool{
+	"Name": true, "CNAME": true, "NS": true, "PTR": true, "MName": true, "RName": true, "TXTs": true,
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// value converts a field to something that encodes naturally as JSON:
+// numbers stay numbers, addresses become strings and other bytes hex.
+func value(v reflect.Value, name string) (any, bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.String:
+		return v.String(), true
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, true
+		}
+		return value(v.Elem(), name)
+	case reflect.Struct:
+		return structFields(v), true
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := bytesOf(v)
+			switch {
+			case v.Type().Implements(stringerType):
+				// net.IP, net.HardwareAddr
+				return v.Interface().(fmt.Stringer).String(), true
+			case nameFields[name]:
+				return string(b), true
+			}
+			return hex.EncodeToString(b), true
+		}
+		items := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if item, ok := value(v.Index(i), name); ok {
+				items = append(items, item)
+			}
+		}
+		return items, true
+	}
+	return nil, false
+}
+
+func bytesOf(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice {
+		return v.Bytes()
+	}
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return b
+}
+
+// Encoder writes Records in the configured format.
+type Encoder struct {
+	format string
+	fields []string
+	w      io.Writer
+	csv    *csv.Writer
+	count  int
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer, opts *Options) (*Encoder, error) {
+	e := &Encoder{format: opts.Format, fields: opts.Fields, w: w}
+	switch e.format {
+	case "":
+		e.format = FormatNDJSON
+	case FormatNDJSON, FormatJSON:
+	case FormatCSV:
+		if len(e.fields) == 0 {
+			e.fields = DefaultCSVFields
+		}
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(e.fields); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+	return e, nil
+}
+
+// Encode writes r.
+func (e *Encoder) Encode(r *Record) error {
+	defer func() { e.count++ }()
+	if e.csv != nil {
+		row := make([]string, len(e.fields))
+		for i, name := range e.fields {
+			row[i] = cell(r, name)
+		}
+		return e.csv.Write(row)
+	}
+
+	var out any = r
+	if len(e.fields) > 0 {
+		sel := make(map[string]any, len(e.fields))
+		for _, name := range e.fields {
+			if v, ok := r.Field(name); ok {
+				sel[name] = v
+			}
+		}
+		out = sel
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	if e.format == FormatJSON {
+		sep := ",\n"
+		if e.count == 0 {
+			sep = "[\n"
+		}
+		b = append([]byte(sep), b...)
+	} else {
+		b = append(b, '\n')
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+// Close finishes the output: it closes the JSON array or flushes CSV.
+func (e *Encoder) Close() error {
+	switch {
+	case e.csv != nil:
+		e.csv.Flush()
+		return e.csv.Error()
+	case e.format == FormatJSON:
+		end := "\n]\n"
+		if e.count == 0 {
+			end = "[]\n"
+		}
+		_, err := io.WriteString(e.w, end)
+		return err
+	}
+	return nil
+}
+
+// cell formats a field for CSV. Missing fields are empty; composite ones
+// are written as JSON.
+func cell(r *Record, name string) string {
+	v, ok := r.Field(name)
+	if !ok || v == nil {
+		return ""
+	}
+	switch x := v.(type) {
+	case string:
+		return x
+	case int:
+		return strconv.Itoa(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Run decodes every packet of inFile and writes it to w. It returns the
+// number of packets written.
+func Run(inFile string, w io.Writer, opts *Options, logger *common.Logger) (int, error) {
+	reader, err := pcapio.Open(inFile)
+	if err != nil {
+		return 0, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer reader.Close()
+
+	enc, err := NewEncoder(w, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var index int
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error(fmt.Errorf("error reading packet data: %w", err))
+			break
+		}
+		index++
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{NoCopy: true})
+		md := packet.Metadata()
+		md.CaptureInfo = ci
+		if err := enc.Encode(Decode(packet, index)); err != nil {
+			return index - 1, fmt.Errorf("error writing packet %d: %w", index, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return index, fmt.Errorf("error finishing output: %w", err)
+	}
+	return index, nil
+}
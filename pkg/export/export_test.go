@@ -0,0 +1,141 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/export"
+)
+
+func writeCapture(t *testing.T, path string) {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeDot1Q,
+	}
+	vlan := &layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv4}
+	ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.53")}
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip4)
+	dns := &layers.DNS{ID: 42, RD: true, QDCount: 1,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}}}
+
+	tcpIP := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 9999, SYN: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(tcpIP)
+	plainEth := *eth
+	plainEth.EthernetType = layers.EthernetTypeIPv4
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	for i, stack := range [][]gopacket.SerializableLayer{
+		{eth, vlan, ip4, udp, dns},
+		{&plainEth, tcpIP, tcp, gopacket.Payload("hi")},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, opts, stack...); err != nil {
+			t.Fatalf("Error serializing packet %d: %v", i, err)
+		}
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1700000000+int64(i), 0), CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatalf("Error writing packet %d: %v", i, err)
+		}
+	}
+}
+
+func run(t *testing.T, opts *export.Options) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.pcap")
+	writeCapture(t, path)
+	var buf bytes.Buffer
+	n, err := export.Run(path, &buf, opts, common.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 packets, got %d", n)
+	}
+	return buf.String()
+}
+
+func TestRun_NDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(run(t, &export.Options{})), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+
+	var rec export.Record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if rec.Index != 1 || rec.Protocols != "Ethernet:Dot1Q:IPv4:UDP:DNS" {
+		t.Errorf("Unexpected record header: index %d, protocols %s", rec.Index, rec.Protocols)
+	}
+	if rec.Layers["Ethernet"]["SrcMAC"] != "00:11:22:33:44:55" {
+		t.Errorf("Unexpected Ethernet.SrcMAC %v", rec.Layers["Ethernet"]["SrcMAC"])
+	}
+	if rec.Layers["Dot1Q"]["VLANIdentifier"] != float64(100) {
+		t.Errorf("Unexpected Dot1Q.VLANIdentifier %v", rec.Layers["Dot1Q"]["VLANIdentifier"])
+	}
+	if rec.Layers["IPv4"]["DstIP"] != "10.0.0.53" {
+		t.Errorf("Unexpected IPv4.DstIP %v", rec.Layers["IPv4"]["DstIP"])
+	}
+	qs, _ := rec.Layers["DNS"]["Questions"].([]any)
+	if len(qs) != 1 || qs[0].(map[string]any)["Name"] != "example.com" {
+		t.Errorf("Unexpected DNS questions %v", rec.Layers["DNS"]["Questions"])
+	}
+
+	if !strings.Contains(lines[1], `"Payload":{"hex":"6869","length":2}`) {
+		t.Errorf("Expected payload hex in %s", lines[1])
+	}
+}
+
+func TestRun_JSONArrayWithFields(t *testing.T) {
+	out := run(t, &export.Options{Format: export.FormatJSON, Fields: []string{"index", "TCP.DstPort", "TCP.SYN"}})
+	var recs []map[string]any
+	if err := json.Unmarshal([]byte(out), &recs); err != nil {
+		t.Fatalf("Invalid JSON array: %v\n%s", err, out)
+	}
+	if len(recs) != 2 || len(recs[0]) != 1 {
+		t.Fatalf("Unexpected records %v", recs)
+	}
+	if recs[1]["TCP.DstPort"] != float64(9999) || recs[1]["TCP.SYN"] != true {
+		t.Errorf("Unexpected TCP fields %v", recs[1])
+	}
+}
+
+func TestRun_CSV(t *testing.T) {
+	out := run(t, &export.Options{Format: export.FormatCSV, Fields: []string{"index", "IPv4.SrcIP", "UDP.DstPort", "TCP.DstPort"}})
+	want := "index,IPv4.SrcIP,UDP.DstPort,TCP.DstPort\n1,10.0.0.1,53,\n2,10.0.0.1,,9999\n"
+	if out != want {
+		t.Errorf("Expected CSV:\n%s\ngot:\n%s", want, out)
+	}
+}
+
+func TestNewEncoder_UnknownFormat(t *testing.T) {
+	if _, err := export.NewEncoder(&bytes.Buffer{}, &export.Options{Format: "xml"}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -0,0 +1,368 @@
+package flow
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Export formats accepted by NewExporter.
+const (
+	FormatCSV       = "csv"
+	FormatJSON      = "json"
+	FormatIPFIX     = "ipfix"
+	FormatNetflowV9 = "netflow9"
+)
+
+// Exporter receives finished flows.
+type Exporter interface {
+	Export(f *Flow) error
+	// Close writes anything still buffered. It does not close the
+	// underlying writer.
+	Close() error
+}
+
+// NewExporter returns an Exporter writing format to w. For the binary
+// formats every Write carries one complete message, so w may be a UDP
+// connection to a collector.
+func NewExporter(format string, w io.Writer) (Exporter, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSVExporter(w), nil
+	case FormatJSON:
+		return NewJSONExporter(w), nil
+	case FormatIPFIX:
+		return NewRecordExporter(w, 10), nil
+	case FormatNetflowV9:
+		return NewRecordExporter(w, 9), nil
+	}
+	return nil, fmt.Errorf("unknown flow export format %q", format)
+}
+
+// CSVExporter writes one row per flow after a header row.
+type CSVExporter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewCSVExporter returns a CSVExporter writing to w.
+func NewCSVExporter(w io.Writer) *CSVExporter {
+	return &CSVExporter{w: csv.NewWriter(w)}
+}
+
+// Export writes f as a CSV row.
+func (e *CSVExporter) Export(f *Flow) error {
+	if !e.header {
+		e.header = true
+		if err := e.w.Write([]string{
+			"start", "end", "proto", "src_ip", "src_port", "dst_ip", "dst_port",
+			"packets", "bytes", "rev_packets", "rev_bytes", "tcp_flags", "end_reason",
+		}); err != nil {
+			return err
+		}
+	}
+	return e.w.Write([]string{
+		f.Start.UTC().Format(time.RFC3339Nano),
+		f.End.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(int(f.Proto)),
+		f.SrcIP.String(),
+		strconv.Itoa(int(f.SrcPort)),
+		f.DstIP.String(),
+		strconv.Itoa(int(f.DstPort)),
+		strconv.FormatUint(f.Packets, 10),
+		strconv.FormatUint(f.Bytes, 10),
+		strconv.FormatUint(f.RevPackets, 10),
+		strconv.FormatUint(f.RevBytes, 10),
+		fmt.Sprintf("0x%02x", f.TCPFlags),
+		f.EndReason,
+	})
+}
+
+// Close flushes buffered rows.
+func (e *CSVExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// JSONExporter writes one JSON object per line.
+type JSONExporter struct {
+	enc *json.Encoder
+}
+
+// NewJSONExporter returns a JSONExporter writing to w.
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(w)}
+}
+
+// Export writes f as a JSON line.
+func (e *JSONExporter) Export(f *Flow) error { return e.enc.Encode(f) }
+
+// Close is a no-op; every flow is written as it arrives.
+func (e *JSONExporter) Close() error { return nil }
+
+// Information elements used in the templates. NetFlow v9 and IPFIX share
+// these numbers; the switched times are NetFlow v9 only and the absolute
+// flow times IPFIX only.
+const (
+	ieOctetDeltaCount    = 1
+	iePacketDeltaCount   = 2
+	ieProtocolIdentifier = 4
+	ieTCPControlBits     = 6
+	ieSourcePort         = 7
+	ieSourceIPv4         = 8
+	ieDestinationPort    = 11
+	ieDestinationIPv4    = 12
+	ieLastSwitched       = 21
+	ieFirstSwitched      = 22
+	ieSourceIPv6         = 27
+	ieDestinationIPv6    = 28
+	ieFlowStartMillis    = 152
+	ieFlowEndMillis      = 153
+)
+
+// Template IDs; data sets reuse them as their set ID.
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+type field struct{ id, length uint16 }
+
+func templateFields(version, addrLen uint16) []field {
+	src, dst := uint16(ieSourceIPv4), uint16(ieDestinationIPv4)
+	if addrLen == 16 {
+		src, dst = ieSourceIPv6, ieDestinationIPv6
+	}
+	fields := []field{
+		{src, addrLen}, {dst, addrLen},
+		{ieSourcePort, 2}, {ieDestinationPort, 2},
+		{ieProtocolIdentifier, 1}, {ieTCPControlBits, 1},
+		{iePacketDeltaCount, 8}, {ieOctetDeltaCount, 8},
+	}
+	if version == 9 {
+		return append(fields, field{ieFirstSwitched, 4}, field{ieLastSwitched, 4})
+	}
+	return append(fields, field{ieFlowStartMillis, 8}, field{ieFlowEndMillis, 8})
+}
+
+const (
+	// maxBody bounds the sets in one message, templates included, so that
+	// a message plus its IP, UDP and export headers fits a 1500-byte MTU.
+	maxBody = 1400
+	// templateEvery is how many messages are sent between template
+	// refreshes, so collectors that start late still learn them.
+	templateEvery = 16
+)
+
+// RecordExporter writes NetFlow v9 or IPFIX messages. Each bidirectional
+// flow becomes one record per direction that carried packets.
+type RecordExporter struct {
+	// DomainID is the IPFIX observation domain or NetFlow v9 source ID.
+	DomainID uint32
+
+	w        io.Writer
+	version  uint16
+	v4, v6   [][]byte
+	messages uint32
+	records  uint32
+	boot     time.Time
+	last     time.Time
+}
+
+// NewRecordExporter returns a RecordExporter for version 9 (NetFlow v9)
+// or 10 (IPFIX).
+func NewRecordExporter(w io.Writer, version uint16) *RecordExporter {
+	return &RecordExporter{w: w, version: version}
+}
+
+// Export queues the records for f, sending a message when enough have
+// accumulated.
+func (e *RecordExporter) Export(f *Flow) error {
+	if e.boot.IsZero() {
+		e.boot = f.Start
+	}
+	if f.End.After(e.last) {
+		e.last = f.End
+	}
+	rev := Flow{Key: f.Key.Reverse(), Start: f.Start, End: f.End,
+		Packets: f.RevPackets, Bytes: f.RevBytes, TCPFlags: f.TCPFlags}
+	for _, d := range []*Flow{f, &rev} {
+		if d.Packets == 0 {
+			continue
+		}
+		rec := e.encodeRecord(d)
+		v4 := d.SrcIP.Is4()
+		if len(e.v4)+len(e.v6) > 0 && e.bodyLen(len(rec), v4) > maxBody {
+			if err := e.send(); err != nil {
+				return err
+			}
+		}
+		if v4 {
+			e.v4 = append(e.v4, rec)
+		} else {
+			e.v6 = append(e.v6, rec)
+		}
+	}
+	return nil
+}
+
+// bodyLen returns the size of the next message body if a record of n
+// bytes were added to the IPv4 or IPv6 data set.
+func (e *RecordExporter) bodyLen(n int, v4 bool) int {
+	size := 0
+	if e.messages%templateEvery == 0 {
+		size += len(e.templateSet())
+	}
+	v4Len, v6Len := recordsLen(e.v4), recordsLen(e.v6)
+	if v4 {
+		v4Len += n
+	} else {
+		v6Len += n
+	}
+	for _, l := range []int{v4Len, v6Len} {
+		if l > 0 {
+			// Set header plus padding, as in dataSet.
+			size += (4 + l + 3) &^ 3
+		}
+	}
+	return size
+}
+
+func recordsLen(recs [][]byte) int {
+	n := 0
+	for _, r := range recs {
+		n += len(r)
+	}
+	return n
+}
+
+// Close sends any queued records.
+func (e *RecordExporter) Close() error {
+	if len(e.v4)+len(e.v6) == 0 {
+		return nil
+	}
+	return e.send()
+}
+
+func (e *RecordExporter) send() error {
+	var body []byte
+	var count uint16
+	if e.messages%templateEvery == 0 {
+		body = append(body, e.templateSet()...)
+		count += 2
+	}
+	for _, set := range []struct {
+		id   uint16
+		recs [][]byte
+	}{{templateIPv4, e.v4}, {templateIPv6, e.v6}} {
+		if len(set.recs) == 0 {
+			continue
+		}
+		body = append(body, dataSet(set.id, set.recs)...)
+		count += uint16(len(set.recs))
+	}
+	n := uint32(len(e.v4) + len(e.v6))
+	e.v4, e.v6 = e.v4[:0], e.v6[:0]
+
+	msg := e.header(count, len(body))
+	msg = append(msg, body...)
+	e.messages++
+	if _, err := e.w.Write(msg); err != nil {
+		return fmt.Errorf("error writing flow message: %w", err)
+	}
+	e.records += n
+	return nil
+}
+
+// header builds the message header. IPFIX sequence numbers count data
+// records; NetFlow v9 ones count messages.
+func (e *RecordExporter) header(count uint16, bodyLen int) []byte {
+	if e.version == 10 {
+		h := make([]byte, 16)
+		binary.BigEndian.PutUint16(h[0:], 10)
+		binary.BigEndian.PutUint16(h[2:], uint16(16+bodyLen))
+		binary.BigEndian.PutUint32(h[4:], uint32(e.last.Unix()))
+		binary.BigEndian.PutUint32(h[8:], e.records)
+		binary.BigEndian.PutUint32(h[12:], e.DomainID)
+		return h
+	}
+	h := make([]byte, 20)
+	binary.BigEndian.PutUint16(h[0:], 9)
+	binary.BigEndian.PutUint16(h[2:], count)
+	binary.BigEndian.PutUint32(h[4:], e.uptime(e.last))
+	binary.BigEndian.PutUint32(h[8:], uint32(e.last.Unix()))
+	binary.BigEndian.PutUint32(h[12:], e.messages)
+	binary.BigEndian.PutUint32(h[16:], e.DomainID)
+	return h
+}
+
+// templateSet describes both templates. The set ID differs between
+// NetFlow v9 (0) and IPFIX (2).
+func (e *RecordExporter) templateSet() []byte {
+	setID := uint16(0)
+	if e.version == 10 {
+		setID = 2
+	}
+	set := make([]byte, 4)
+	binary.BigEndian.PutUint16(set[0:], setID)
+	for _, t := range []struct {
+		id      uint16
+		addrLen uint16
+	}{{templateIPv4, 4}, {templateIPv6, 16}} {
+		fields := templateFields(e.version, t.addrLen)
+		set = binary.BigEndian.AppendUint16(set, t.id)
+		set = binary.BigEndian.AppendUint16(set, uint16(len(fields)))
+		for _, f := range fields {
+			set = binary.BigEndian.AppendUint16(set, f.id)
+			set = binary.BigEndian.AppendUint16(set, f.length)
+		}
+	}
+	binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+	return set
+}
+
+func dataSet(id uint16, recs [][]byte) []byte {
+	set := make([]byte, 4)
+	binary.BigEndian.PutUint16(set[0:], id)
+	for _, r := range recs {
+		set = append(set, r...)
+	}
+	// Pad to a 32-bit boundary as NetFlow v9 requires; IPFIX allows it.
+	for len(set)%4 != 0 {
+		set = append(set, 0)
+	}
+	binary.BigEndian.PutUint16(set[2:], uint16(len(set)))
+	return set
+}
+
+// encodeRecord lays f out in template order.
+func (e *RecordExporter) encodeRecord(f *Flow) []byte {
+	var b []byte
+	b = append(b, f.SrcIP.AsSlice()...)
+	b = append(b, f.DstIP.AsSlice()...)
+	b = binary.BigEndian.AppendUint16(b, f.SrcPort)
+	b = binary.BigEndian.AppendUint16(b, f.DstPort)
+	b = append(b, f.Proto, f.TCPFlags)
+	b = binary.BigEndian.AppendUint64(b, f.Packets)
+	b = binary.BigEndian.AppendUint64(b, f.Bytes)
+	if e.version == 9 {
+		b = binary.BigEndian.AppendUint32(b, e.uptime(f.Start))
+		return binary.BigEndian.AppendUint32(b, e.uptime(f.End))
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(f.Start.UnixMilli()))
+	return binary.BigEndian.AppendUint64(b, uint64(f.End.UnixMilli()))
+}
+
+// uptime returns t in milliseconds since the exporter's notional boot,
+// the first exported flow's start, as the v9 sysUptime field counts.
+// Flows that started earlier are clamped to 0.
+func (e *RecordExporter) uptime(t time.Time) uint32 {
+	if t.Before(e.boot) {
+		return 0
+	}
+	return uint32(t.Sub(e.boot).Milliseconds())
+}
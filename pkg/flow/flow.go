@@ -0,0 +1,266 @@
+package flow
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Default timeouts, matching common router defaults.
+const (
+	DefaultIdleTimeout   = 15 * time.Second
+	DefaultActiveTimeout = 30 * time.Minute
+)
+
+// Reasons a flow was exported.
+const (
+	EndIdle   = "idle"
+	EndActive = "active"
+	EndTCP    = "end"
+	EndFlush  = "flush"
+)
+
+// Key identifies a flow. Src is the endpoint that sent the first packet.
+type Key struct {
+	Proto   uint8      `json:"proto"`
+	SrcIP   netip.Addr `json:"src_ip"`
+	DstIP   netip.Addr `json:"dst_ip"`
+	SrcPort uint16     `json:"src_port"`
+	DstPort uint16     `json:"dst_port"`
+}
+
+// Reverse returns the key as seen from the other endpoint.
+func (k Key) Reverse() Key {
+	return Key{Proto: k.Proto, SrcIP: k.DstIP, DstIP: k.SrcIP, SrcPort: k.DstPort, DstPort: k.SrcPort}
+}
+
+// Flow is a bidirectional 5-tuple flow.
+type Flow struct {
+	Key
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Packets    uint64    `json:"packets"` // sent by Src
+	Bytes      uint64    `json:"bytes"`
+	RevPackets uint64    `json:"rev_packets"` // sent by Dst
+	RevBytes   uint64    `json:"rev_bytes"`
+	// TCPFlags is the union of TCP flags seen in either direction.
+	TCPFlags  uint8  `json:"tcp_flags"`
+	EndReason string `json:"end_reason"`
+
+	finFwd, finRev bool
+}
+
+// Table aggregates packets into flows and hands finished flows to an
+// export function. Timeouts are evaluated against packet timestamps.
+type Table struct {
+	idle, active time.Duration
+	emit         func(*Flow) error
+	flows        map[Key]*Flow
+	lastSweep    time.Time
+	Exported     int
+}
+
+// NewTable returns a Table; non-positive timeouts use the defaults.
+func NewTable(idle, active time.Duration, emit func(*Flow) error) *Table {
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+	if active <= 0 {
+		active = DefaultActiveTimeout
+	}
+	return &Table{idle: idle, active: active, emit: emit, flows: make(map[Key]*Flow)}
+}
+
+// Add accounts packet, captured at ts with the given wire length, to its
+// flow. Non-IP packets are ignored.
+func (t *Table) Add(packet gopacket.Packet, ts time.Time, length int) error {
+	if err := t.sweep(ts); err != nil {
+		return err
+	}
+
+	key, flags, ok := keyOf(packet)
+	if !ok {
+		return nil
+	}
+	size := uint64(length)
+
+	f, forward := t.flows[key], true
+	if f == nil {
+		if f = t.flows[key.Reverse()]; f != nil {
+			forward = false
+		}
+	}
+	if f != nil && ts.Sub(f.Start) > t.active {
+		if err := t.export(f, EndActive); err != nil {
+			return err
+		}
+		f = nil
+		forward = true
+	}
+	if f == nil {
+		f = &Flow{Key: key, Start: ts}
+		t.flows[key] = f
+	}
+
+	f.End = ts
+	f.TCPFlags |= flags
+	if forward {
+		f.Packets++
+		f.Bytes += size
+		f.finFwd = f.finFwd || flags&tcpFIN != 0
+	} else {
+		f.RevPackets++
+		f.RevBytes += size
+		f.finRev = f.finRev || flags&tcpFIN != 0
+	}
+	if flags&tcpRST != 0 || (f.finFwd && f.finRev) {
+		return t.export(f, EndTCP)
+	}
+	return nil
+}
+
+// Flush exports every remaining flow, oldest first.
+func (t *Table) Flush() error {
+	flows := make([]*Flow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	sortFlows(flows)
+	for _, f := range flows {
+		if err := t.export(f, EndFlush); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweep exports idle flows at most once per second of capture time.
+func (t *Table) sweep(now time.Time) error {
+	if now.Sub(t.lastSweep) < time.Second {
+		return nil
+	}
+	t.lastSweep = now
+	var idle []*Flow
+	for _, f := range t.flows {
+		if now.Sub(f.End) > t.idle {
+			idle = append(idle, f)
+		}
+	}
+	sortFlows(idle)
+	for _, f := range idle {
+		if err := t.export(f, EndIdle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) export(f *Flow, reason string) error {
+	delete(t.flows, f.Key)
+	f.EndReason = reason
+	t.Exported++
+	return t.emit(f)
+}
+
+func sortFlows(flows []*Flow) {
+	sort.Slice(flows, func(i, j int) bool { return flows[i].Start.Before(flows[j].Start) })
+}
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+	tcpURG = 0x20
+)
+
+// KeyOf returns the key of packet's flow, oriented from its sender. It
+// reports false for packets without an IP layer.
+func KeyOf(packet gopacket.Packet) (Key, bool) {
+	k, _, ok := keyOf(packet)
+	return k, ok
+}
+
+func keyOf(packet gopacket.Packet) (Key, uint8, bool) {
+	var k Key
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		k.Proto = uint8(ip.Protocol)
+		k.SrcIP, _ = netip.AddrFromSlice(ip.SrcIP.To4())
+		k.DstIP, _ = netip.AddrFromSlice(ip.DstIP.To4())
+	case *layers.IPv6:
+		k.Proto = uint8(ip.NextHeader)
+		k.SrcIP, _ = netip.AddrFromSlice(ip.SrcIP.To16())
+		k.DstIP, _ = netip.AddrFromSlice(ip.DstIP.To16())
+	default:
+		return k, 0, false
+	}
+
+	var flags uint8
+	switch l := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		k.Proto = uint8(layers.IPProtocolTCP)
+		k.SrcPort, k.DstPort = uint16(l.SrcPort), uint16(l.DstPort)
+		for _, f := range []struct {
+			set bool
+			bit uint8
+		}{{l.FIN, tcpFIN}, {l.SYN, tcpSYN}, {l.RST, tcpRST}, {l.PSH, tcpPSH}, {l.ACK, tcpACK}, {l.URG, tcpURG}} {
+			if f.set {
+				flags |= f.bit
+			}
+		}
+	case *layers.UDP:
+		k.Proto = uint8(layers.IPProtocolUDP)
+		k.SrcPort, k.DstPort = uint16(l.SrcPort), uint16(l.DstPort)
+	case *layers.UDPLite:
+		k.SrcPort, k.DstPort = uint16(l.SrcPort), uint16(l.DstPort)
+	case *layers.SCTP:
+		k.SrcPort, k.DstPort = uint16(l.SrcPort), uint16(l.DstPort)
+	}
+	return k, flags, true
+}
+
+// Extract reads the capture at inFile and exports its flows through exp.
+func Extract(inFile string, idle, active time.Duration, exp Exporter, logger *common.Logger) error {
+	reader, err := pcapio.Open(inFile)
+	if err != nil {
+		return fmt.Errorf("error opening input file: %w", err)
+	}
+	defer reader.Close()
+
+	table := NewTable(idle, active, exp.Export)
+	var count int
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error(fmt.Errorf("error reading packet data: %w", err))
+			break
+		}
+		count++
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		if err := table.Add(packet, ci.Timestamp, ci.Length); err != nil {
+			return fmt.Errorf("error exporting flow: %w", err)
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return fmt.Errorf("error exporting flow: %w", err)
+	}
+	if err := exp.Close(); err != nil {
+		return fmt.Errorf("error closing exporter: %w", err)
+	}
+
+	logger.Info("Flow extraction complete", "packets", count, "flows", table.Exported)
+	return nil
+}
@@ -0,0 +1,332 @@
+package flow_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/flow"
+)
+
+var base = time.Unix(1700000000, 0)
+
+type seg struct {
+	src, dst     string
+	sport, dport uint16
+	flags        string // any of "SAFR" for TCP; empty means UDP
+	at           time.Duration
+}
+
+func buildPacket(t *testing.T, s seg) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP(s.src), DstIP: net.ParseIP(s.dst)}
+	var l4 gopacket.SerializableLayer
+	if s.flags == "" {
+		udp := &layers.UDP{SrcPort: layers.UDPPort(s.sport), DstPort: layers.UDPPort(s.dport)}
+		udp.SetNetworkLayerForChecksum(ip4)
+		l4 = udp
+	} else {
+		ip4.Protocol = layers.IPProtocolTCP
+		tcp := &layers.TCP{SrcPort: layers.TCPPort(s.sport), DstPort: layers.TCPPort(s.dport), Window: 1024,
+			SYN: strings.Contains(s.flags, "S"), ACK: strings.Contains(s.flags, "A"),
+			FIN: strings.Contains(s.flags, "F"), RST: strings.Contains(s.flags, "R")}
+		tcp.SetNetworkLayerForChecksum(ip4)
+		l4 = tcp
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip4, l4, gopacket.Payload([]byte("data"))); err != nil {
+		t.Fatalf("Error serializing packet: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func runTable(t *testing.T, idle, active time.Duration, segs []seg) []*flow.Flow {
+	t.Helper()
+	var out []*flow.Flow
+	table := flow.NewTable(idle, active, func(f *flow.Flow) error {
+		out = append(out, f)
+		return nil
+	})
+	for _, s := range segs {
+		data := buildPacket(t, s)
+		packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		if err := table.Add(packet, base.Add(s.at), len(data)); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+	}
+	if err := table.Flush(); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	return out
+}
+
+func TestTable_BidirectionalTCP(t *testing.T) {
+	flows := runTable(t, 0, 0, []seg{
+		{"10.0.0.1", "10.0.0.2", 40000, 80, "S", 0},
+		{"10.0.0.2", "10.0.0.1", 80, 40000, "SA", time.Millisecond},
+		{"10.0.0.1", "10.0.0.2", 40000, 80, "A", 2 * time.Millisecond},
+		{"10.0.0.1", "10.0.0.2", 40000, 80, "FA", 3 * time.Millisecond},
+		{"10.0.0.2", "10.0.0.1", 80, 40000, "FA", 4 * time.Millisecond},
+		{"10.0.0.1", "10.0.0.2", 5353, 53, "", 5 * time.Millisecond},
+	})
+	if len(flows) != 2 {
+		t.Fatalf("Expected 2 flows, got %d", len(flows))
+	}
+	f := flows[0]
+	if f.SrcIP.String() != "10.0.0.1" || f.SrcPort != 40000 || f.DstPort != 80 {
+		t.Errorf("Unexpected initiator: %s:%d -> %d", f.SrcIP, f.SrcPort, f.DstPort)
+	}
+	if f.Packets != 3 || f.RevPackets != 2 {
+		t.Errorf("Expected 3/2 packets, got %d/%d", f.Packets, f.RevPackets)
+	}
+	if f.TCPFlags != 0x13 {
+		t.Errorf("Expected flags 0x13, got 0x%02x", f.TCPFlags)
+	}
+	if f.EndReason != flow.EndTCP {
+		t.Errorf("Expected end reason %q, got %q", flow.EndTCP, f.EndReason)
+	}
+	if f.End.Sub(f.Start) != 4*time.Millisecond {
+		t.Errorf("Unexpected duration %v", f.End.Sub(f.Start))
+	}
+	if flows[1].Proto != 17 || flows[1].EndReason != flow.EndFlush {
+		t.Errorf("Expected flushed UDP flow, got %+v", flows[1])
+	}
+}
+
+func TestTable_Timeouts(t *testing.T) {
+	flows := runTable(t, 5*time.Second, 20*time.Second, []seg{
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 0},
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 10 * time.Second}, // idle expiry first
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 14 * time.Second},
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 18 * time.Second},
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 22 * time.Second},
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 26 * time.Second},
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 31 * time.Second}, // active expiry
+	})
+	var reasons []string
+	for _, f := range flows {
+		reasons = append(reasons, f.EndReason)
+	}
+	want := []string{flow.EndIdle, flow.EndActive, flow.EndFlush}
+	if strings.Join(reasons, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected reasons %v, got %v", want, reasons)
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	flows := runTable(t, 0, 0, []seg{{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 0}})
+	var buf bytes.Buffer
+	exp := flow.NewCSVExporter(&buf)
+	if err := exp.Export(flows[0]); err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	if err := exp.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "start,end,proto") {
+		t.Fatalf("Unexpected CSV output:\n%s", buf.String())
+	}
+	if !strings.Contains(lines[1], ",17,10.0.0.1,1000,10.0.0.2,2000,1,") {
+		t.Errorf("Unexpected CSV row: %s", lines[1])
+	}
+}
+
+func TestRecordExporter_UDPCollector(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer pc.Close()
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Error dialing collector: %v", err)
+	}
+	defer conn.Close()
+
+	flows := runTable(t, 0, 0, []seg{
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 0},
+		{"10.0.0.2", "10.0.0.1", 2000, 1000, "", time.Millisecond},
+	})
+	for _, version := range []uint16{10, 9} {
+		format := flow.FormatIPFIX
+		if version == 9 {
+			format = flow.FormatNetflowV9
+		}
+		exp, err := flow.NewExporter(format, conn)
+		if err != nil {
+			t.Fatalf("NewExporter returned error: %v", err)
+		}
+		for _, f := range flows {
+			if err := exp.Export(f); err != nil {
+				t.Fatalf("Export returned error: %v", err)
+			}
+		}
+		if err := exp.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+
+		pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+		msg := make([]byte, 65535)
+		n, _, err := pc.ReadFrom(msg)
+		if err != nil {
+			t.Fatalf("Error reading from collector: %v", err)
+		}
+		msg = msg[:n]
+		if v := binary.BigEndian.Uint16(msg[0:]); v != version {
+			t.Fatalf("Expected version %d, got %d", version, v)
+		}
+
+		// src(4) dst(4) ports(4) proto(1) flags(1) packets(8) bytes(8)
+		// then start and end: 8-byte epoch milliseconds for IPFIX, 4-byte
+		// milliseconds of sysUptime for NetFlow v9.
+		hdrLen, templateSet, recLen := 16, uint16(2), 46
+		if version == 9 {
+			hdrLen, templateSet, recLen = 20, 0, 38
+			// Two templates plus a record per direction.
+			if c := binary.BigEndian.Uint16(msg[2:]); c != 4 {
+				t.Errorf("Expected NetFlow v9 count 4, got %d", c)
+			}
+		} else if l := binary.BigEndian.Uint16(msg[2:]); int(l) != n {
+			t.Errorf("IPFIX length %d does not match datagram size %d", l, n)
+		}
+
+		sets := map[uint16][]byte{}
+		for off := hdrLen; off+4 <= n; {
+			id, l := binary.BigEndian.Uint16(msg[off:]), int(binary.BigEndian.Uint16(msg[off+2:]))
+			if l < 4 || off+l > n {
+				t.Fatalf("Bad set length %d at offset %d", l, off)
+			}
+			sets[id] = msg[off+4 : off+l]
+			off += l
+		}
+		if _, ok := sets[templateSet]; !ok {
+			t.Errorf("Version %d: template set missing", version)
+		}
+		data, ok := sets[256]
+		if !ok {
+			t.Fatalf("Version %d: IPv4 data set missing", version)
+		}
+		if len(data) < 2*recLen {
+			t.Fatalf("Expected two records, got %d bytes", len(data))
+		}
+		if !net.IP(data[0:4]).Equal(net.ParseIP("10.0.0.1")) || !net.IP(data[recLen:recLen+4]).Equal(net.ParseIP("10.0.0.2")) {
+			t.Errorf("Unexpected record addresses: %v, %v", net.IP(data[0:4]), net.IP(data[recLen:recLen+4]))
+		}
+		if p := binary.BigEndian.Uint64(data[14:]); p != 1 {
+			t.Errorf("Expected 1 packet in forward record, got %d", p)
+		}
+		if version == 9 {
+			first, last := binary.BigEndian.Uint32(data[30:]), binary.BigEndian.Uint32(data[34:])
+			if uptime := binary.BigEndian.Uint32(msg[4:]); first != 0 || last != 1 || uptime != 1 {
+				t.Errorf("Expected switched times 0-1 at uptime 1, got %d-%d at %d", first, last, uptime)
+			}
+		}
+	}
+}
+
+func TestRecordExporter_MessageSize(t *testing.T) {
+	var flows []*flow.Flow
+	for i := 0; i < 100; i++ {
+		flows = append(flows, &flow.Flow{
+			Key: flow.Key{Proto: 17, SrcIP: netip.MustParseAddr("2001:db8::1"), DstIP: netip.MustParseAddr("2001:db8::2"),
+				SrcPort: uint16(1000 + i), DstPort: 53},
+			Start: base, End: base.Add(time.Second),
+			Packets: 1, Bytes: 100, RevPackets: 1, RevBytes: 200,
+		})
+	}
+	for _, version := range []uint16{10, 9} {
+		w := &messageWriter{}
+		exp := flow.NewRecordExporter(w, version)
+		for _, f := range flows {
+			if err := exp.Export(f); err != nil {
+				t.Fatalf("Export returned error: %v", err)
+			}
+		}
+		if err := exp.Close(); err != nil {
+			t.Fatalf("Close returned error: %v", err)
+		}
+		if len(w.sizes) < 2 {
+			t.Fatalf("Version %d: expected several messages, got %d", version, len(w.sizes))
+		}
+		for i, n := range w.sizes {
+			// 20-byte IPv4 and 8-byte UDP headers on a 1500-byte MTU.
+			if n > 1500-28 {
+				t.Errorf("Version %d: message %d is %d bytes", version, i, n)
+			}
+		}
+	}
+}
+
+// messageWriter records the size of each Write.
+type messageWriter struct {
+	sizes []int
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return len(p), nil
+}
+
+func TestExtract(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	for i, s := range []seg{
+		{"10.0.0.1", "10.0.0.2", 1000, 2000, "", 0},
+		{"10.0.0.3", "10.0.0.2", 1000, 2000, "", time.Second},
+	} {
+		// Captured with a short snaplen: only the headers were kept.
+		data := buildPacket(t, s)
+		ci := gopacket.CaptureInfo{Timestamp: base.Add(s.at), CaptureLength: len(data), Length: 1000}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("Error writing packet %d: %v", i, err)
+		}
+	}
+	f.Close()
+
+	var buf bytes.Buffer
+	if err := flow.Extract(path, 0, 0, flow.NewJSONExporter(&buf), common.NewLogger("test")); err != nil {
+		t.Fatalf("Extract returned error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 JSON lines, got %d:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], `"src_ip":"10.0.0.1"`) {
+		t.Errorf("Unexpected first flow: %s", lines[0])
+	}
+	if !strings.Contains(lines[0], `"bytes":1000,`) {
+		t.Errorf("Expected the wire length counted, got %s", lines[0])
+	}
+}
+
+func TestNewExporter_UnknownFormat(t *testing.T) {
+	if _, err := flow.NewExporter("xml", &bytes.Buffer{}); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -0,0 +1,249 @@
+package fragment
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"osi-replay/pkg/common"
+)
+
+// DefaultTimeout is how long incomplete datagrams are kept, matching the
+// Linux ipfrag_time default.
+const DefaultTimeout = 30 * time.Second
+
+const (
+	// Past these limits on incomplete datagrams the oldest are discarded,
+	// as the Linux ipfrag_high_thresh does.
+	maxPending      = 1024
+	maxPendingBytes = 4 << 20
+	// maxLength bounds the 16-bit IPv4 total length and IPv6 payload
+	// length of a reassembled datagram.
+	maxLength = 0xffff
+)
+
+type datagramKey struct {
+	version  int
+	src, dst [16]byte
+	id       uint32
+	proto    uint8
+}
+
+type piece struct {
+	offset int
+	data   []byte
+}
+
+type datagram struct {
+	seq      uint64 // arrival order, for evicting the oldest
+	first    time.Time
+	link     []byte // frame bytes before the IP header
+	header   []byte // IP header of the offset-0 fragment
+	pieces   []piece
+	totalLen int // payload length, known once the last fragment arrives
+	buffered int
+}
+
+// Defragmenter is a common.Stage that reassembles fragmented IPv4 and
+// IPv6 datagrams into whole packets. Unfragmented packets pass through.
+// The reassembled packet carries the timestamp and index of the fragment
+// that completed it. IPv6 fragments are only reassembled when the
+// Fragment header directly follows the fixed header.
+type Defragmenter struct {
+	timeout   time.Duration
+	pending   map[datagramKey]*datagram
+	buffered  int
+	seq       uint64
+	Completed int
+	Expired   int // timed out, evicted or flushed incomplete
+}
+
+// NewDefragmenter returns a Defragmenter that discards datagrams still
+// incomplete after timeout; a non-positive timeout uses DefaultTimeout.
+func NewDefragmenter(timeout time.Duration) *Defragmenter {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Defragmenter{timeout: timeout, pending: make(map[datagramKey]*datagram)}
+}
+
+// Name implements common.Stage.
+func (d *Defragmenter) Name() string { return "defrag" }
+
+// Process implements common.Stage.
+func (d *Defragmenter) Process(pkt common.Packet) ([]common.Packet, error) {
+	d.expire(pkt.CaptureInfo.Timestamp)
+
+	off, version, ok := locateIP(pkt.Data)
+	if !ok {
+		return []common.Packet{pkt}, nil
+	}
+	var (
+		key     datagramKey
+		header  []byte
+		fragOff int
+		more    bool
+		body    []byte
+	)
+	ip := pkt.Data[off:]
+	switch version {
+	case 4:
+		flags := binary.BigEndian.Uint16(ip[6:])
+		fragOff = int(flags&0x1fff) * 8
+		more = flags&0x2000 != 0
+		if fragOff == 0 && !more {
+			// Not a fragment: pass it on even if snaplen cut it short.
+			return []common.Packet{pkt}, nil
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if ihl < ipv4MinHeader || total < ihl || total > len(ip) {
+			return nil, fmt.Errorf("malformed or truncated IPv4 fragment")
+		}
+		key = datagramKey{version: 4, id: uint32(binary.BigEndian.Uint16(ip[4:])), proto: ip[9]}
+		copy(key.src[:], ip[12:16])
+		copy(key.dst[:], ip[16:20])
+		header = ip[:ihl]
+		body = ip[ihl:total]
+	case 6:
+		if ip[6] != ipProtoFragment {
+			return []common.Packet{pkt}, nil
+		}
+		payloadLen := int(binary.BigEndian.Uint16(ip[4:]))
+		if ipv6Header+payloadLen > len(ip) || payloadLen < ipv6FragHeader {
+			return nil, fmt.Errorf("malformed or truncated IPv6 fragment")
+		}
+		fh := ip[ipv6Header : ipv6Header+ipv6FragHeader]
+		fragOff = int(binary.BigEndian.Uint16(fh[2:]) &^ 7)
+		more = fh[3]&1 != 0
+		key = datagramKey{version: 6, id: binary.BigEndian.Uint32(fh[4:]), proto: fh[0]}
+		copy(key.src[:], ip[8:24])
+		copy(key.dst[:], ip[24:40])
+		header = ip[:ipv6Header]
+		body = ip[ipv6Header+ipv6FragHeader : ipv6Header+payloadLen]
+	}
+
+	limit := maxLength
+	if version == 4 {
+		limit -= len(header)
+	}
+	if fragOff+len(body) > limit {
+		d.discard(key)
+		return nil, fmt.Errorf("IPv%d fragment ends at offset %d, past the %d-byte datagram limit", version, fragOff+len(body), limit)
+	}
+
+	dg := d.pending[key]
+	if dg == nil {
+		d.seq++
+		dg = &datagram{seq: d.seq, first: pkt.CaptureInfo.Timestamp, totalLen: -1}
+		d.pending[key] = dg
+	}
+	if fragOff == 0 {
+		dg.link = append([]byte(nil), pkt.Data[:off]...)
+		dg.header = append([]byte(nil), header...)
+	}
+	if !more {
+		dg.totalLen = fragOff + len(body)
+	}
+	dg.pieces = append(dg.pieces, piece{offset: fragOff, data: append([]byte(nil), body...)})
+	dg.buffered += len(body)
+	d.buffered += len(body)
+
+	data, complete, err := dg.assemble(key)
+	if err != nil {
+		d.discard(key)
+		return nil, err
+	}
+	if !complete {
+		d.evict()
+		return nil, nil
+	}
+	d.discard(key)
+	d.Completed++
+	pkt.Data = data
+	pkt.CaptureInfo.CaptureLength = len(data)
+	pkt.CaptureInfo.Length = len(data)
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage. Incomplete datagrams are discarded.
+func (d *Defragmenter) Flush() ([]common.Packet, error) {
+	d.Expired += len(d.pending)
+	d.pending = make(map[datagramKey]*datagram)
+	d.buffered = 0
+	return nil, nil
+}
+
+func (d *Defragmenter) expire(now time.Time) {
+	for k, dg := range d.pending {
+		if now.Sub(dg.first) > d.timeout {
+			d.discard(k)
+			d.Expired++
+		}
+	}
+}
+
+// evict discards the oldest incomplete datagrams while the pending table
+// is over its limits.
+func (d *Defragmenter) evict() {
+	for len(d.pending) > maxPending || d.buffered > maxPendingBytes {
+		var oldest datagramKey
+		var seq uint64
+		for k, dg := range d.pending {
+			if seq == 0 || dg.seq < seq {
+				oldest, seq = k, dg.seq
+			}
+		}
+		d.discard(oldest)
+		d.Expired++
+	}
+}
+
+func (d *Defragmenter) discard(key datagramKey) {
+	if dg := d.pending[key]; dg != nil {
+		d.buffered -= dg.buffered
+		delete(d.pending, key)
+	}
+}
+
+// assemble returns the reassembled frame once every byte of the datagram
+// has arrived, or an error if it does not fit the IP length field.
+func (dg *datagram) assemble(key datagramKey) ([]byte, bool, error) {
+	if dg.header == nil || dg.totalLen < 0 || dg.buffered < dg.totalLen {
+		return nil, false, nil
+	}
+	sort.Slice(dg.pieces, func(i, j int) bool { return dg.pieces[i].offset < dg.pieces[j].offset })
+	payload := make([]byte, dg.totalLen)
+	var covered int
+	for _, p := range dg.pieces {
+		if p.offset > covered {
+			return nil, false, nil // hole
+		}
+		if end := p.offset + len(p.data); end > covered {
+			if end > dg.totalLen {
+				end = dg.totalLen
+			}
+			copy(payload[p.offset:end], p.data)
+			covered = end
+		}
+	}
+	if covered < dg.totalLen {
+		return nil, false, nil
+	}
+
+	hdr := append([]byte(nil), dg.header...)
+	if key.version == 4 && len(hdr)+len(payload) > maxLength {
+		return nil, false, fmt.Errorf("reassembled IPv4 datagram of %d bytes exceeds %d", len(hdr)+len(payload), maxLength)
+	}
+	if key.version == 4 {
+		setIPv4Fields(hdr, len(hdr)+len(payload), 0, false)
+	} else {
+		hdr[6] = key.proto
+		binary.BigEndian.PutUint16(hdr[4:], uint16(len(payload)))
+	}
+	frame := make([]byte, 0, len(dg.link)+len(hdr)+len(payload))
+	frame = append(frame, dg.link...)
+	frame = append(frame, hdr...)
+	return append(frame, payload...), true, nil
+}
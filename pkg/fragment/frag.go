@@ -0,0 +1,136 @@
+package fragment
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"osi-replay/pkg/common"
+)
+
+// Fragmenter is a common.Stage that splits IPv4 and IPv6 packets larger
+// than an IP MTU into fragments. The IPv4 DF bit is ignored, since the
+// point is to produce frames that fit the target link. IPv6 extension
+// headers, if any, are treated as part of the fragmentable payload.
+type Fragmenter struct {
+	mtu    int
+	nextID uint32
+	Split  int
+}
+
+// NewFragmenter returns a Fragmenter for the given IP MTU (the largest IP
+// packet, excluding link-layer headers).
+func NewFragmenter(mtu int) (*Fragmenter, error) {
+	if mtu < 68 {
+		return nil, fmt.Errorf("MTU %d is below the IPv4 minimum of 68", mtu)
+	}
+	return &Fragmenter{mtu: mtu, nextID: 1}, nil
+}
+
+// Name implements common.Stage.
+func (f *Fragmenter) Name() string { return "fragment" }
+
+// Process implements common.Stage.
+func (f *Fragmenter) Process(pkt common.Packet) ([]common.Packet, error) {
+	off, version, ok := locateIP(pkt.Data)
+	if !ok {
+		return []common.Packet{pkt}, nil
+	}
+	link := pkt.Data[:off]
+	ip := pkt.Data[off:]
+
+	var frames [][]byte
+	switch version {
+	case 4:
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if total <= f.mtu {
+			// Fits: pass it on even if snaplen cut it short.
+			return []common.Packet{pkt}, nil
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		if ihl < ipv4MinHeader || total < ihl || total > len(ip) {
+			return nil, fmt.Errorf("malformed or truncated IPv4 packet larger than the MTU")
+		}
+		flags := binary.BigEndian.Uint16(ip[6:])
+		baseOff := int(flags&0x1fff) * 8
+		origMore := flags&0x2000 != 0
+		frames = f.splitIPv4(link, ip[:ihl], ip[ihl:total], baseOff, origMore)
+	case 6:
+		payloadLen := int(binary.BigEndian.Uint16(ip[4:]))
+		if ipv6Header+payloadLen <= f.mtu {
+			return []common.Packet{pkt}, nil
+		}
+		if ipv6Header+payloadLen > len(ip) {
+			return nil, fmt.Errorf("truncated IPv6 packet larger than the MTU")
+		}
+		if ip[6] == ipProtoFragment {
+			return nil, fmt.Errorf("IPv6 packet is already a fragment")
+		}
+		frames = f.splitIPv6(link, ip[:ipv6Header], ip[ipv6Header:ipv6Header+payloadLen])
+	}
+
+	f.Split++
+	out := make([]common.Packet, 0, len(frames))
+	for _, data := range frames {
+		p := pkt
+		p.Data = data
+		p.CaptureInfo.CaptureLength = len(data)
+		p.CaptureInfo.Length = len(data)
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// Flush implements common.Stage.
+func (f *Fragmenter) Flush() ([]common.Packet, error) { return nil, nil }
+
+func (f *Fragmenter) splitIPv4(link, hdr, payload []byte, baseOff int, origMore bool) [][]byte {
+	chunk := (f.mtu - len(hdr)) &^ 7
+	var frames [][]byte
+	for start := 0; start < len(payload); start += chunk {
+		end := start + chunk
+		more := true
+		if end >= len(payload) {
+			end = len(payload)
+			more = origMore
+		}
+		frame := make([]byte, 0, len(link)+len(hdr)+end-start)
+		frame = append(frame, link...)
+		frame = append(frame, hdr...)
+		frame = append(frame, payload[start:end]...)
+		setIPv4Fields(frame[len(link):len(link)+len(hdr)], len(hdr)+end-start, baseOff+start, more)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func (f *Fragmenter) splitIPv6(link, hdr, payload []byte) [][]byte {
+	chunk := (f.mtu - ipv6Header - ipv6FragHeader) &^ 7
+	id := f.nextID
+	f.nextID++
+	nextHeader := hdr[6]
+
+	var frames [][]byte
+	for start := 0; start < len(payload); start += chunk {
+		end := start + chunk
+		more := uint16(1)
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+		frame := make([]byte, 0, len(link)+ipv6Header+ipv6FragHeader+end-start)
+		frame = append(frame, link...)
+		frame = append(frame, hdr...)
+		ip := frame[len(link):]
+		ip[6] = ipProtoFragment
+		binary.BigEndian.PutUint16(ip[4:], uint16(ipv6FragHeader+end-start))
+
+		var fh [ipv6FragHeader]byte
+		fh[0] = nextHeader
+		binary.BigEndian.PutUint16(fh[2:], uint16(start)|more)
+		binary.BigEndian.PutUint32(fh[4:], id)
+		frame = append(frame, fh[:]...)
+		frame = append(frame, payload[start:end]...)
+		frames = append(frames, frame)
+	}
+	return frames
+}
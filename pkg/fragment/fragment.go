@@ -0,0 +1,67 @@
+package fragment
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	ipv4MinHeader   = 20
+	ipv6Header      = 40
+	ipv6FragHeader  = 8
+	ipProtoFragment = uint8(layers.IPProtocolIPv6Fragment)
+)
+
+// locateIP returns the offset of the IP header inside an Ethernet frame,
+// skipping any VLAN tags, and the IP version (4 or 6). ok is false for
+// non-IP frames.
+func locateIP(frame []byte) (off int, version int, ok bool) {
+	off = 12
+	for off+2 <= len(frame) {
+		switch layers.EthernetType(binary.BigEndian.Uint16(frame[off:])) {
+		case layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ:
+			off += 4
+		case layers.EthernetTypeIPv4:
+			if off+2+ipv4MinHeader > len(frame) {
+				return 0, 0, false
+			}
+			return off + 2, 4, true
+		case layers.EthernetTypeIPv6:
+			if off+2+ipv6Header > len(frame) {
+				return 0, 0, false
+			}
+			return off + 2, 6, true
+		default:
+			return 0, 0, false
+		}
+	}
+	return 0, 0, false
+}
+
+// ipv4Checksum returns the header checksum of hdr, ignoring its current value.
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		if i == 10 {
+			continue
+		}
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// setIPv4Fields updates the total length, fragment fields and checksum of
+// the IPv4 header hdr. offset is in bytes and must be a multiple of 8.
+func setIPv4Fields(hdr []byte, totalLen int, offset int, more bool) {
+	binary.BigEndian.PutUint16(hdr[2:], uint16(totalLen))
+	flags := binary.BigEndian.Uint16(hdr[6:]) & 0x4000 // keep DF
+	if more {
+		flags |= 0x2000
+	}
+	binary.BigEndian.PutUint16(hdr[6:], flags|uint16(offset/8))
+	binary.BigEndian.PutUint16(hdr[10:], ipv4Checksum(hdr))
+}
@@ -0,0 +1,202 @@
+package fragment_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/fragment"
+)
+
+func buildUDP(t *testing.T, v6 bool, payloadLen int) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+	}
+	udp := &layers.UDP{SrcPort: 4000, DstPort: 5000}
+	payload := make([]byte, payloadLen)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	var ip gopacket.SerializableLayer
+	if v6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip6 := &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      net.ParseIP("2001:db8::1"),
+			DstIP:      net.ParseIP("2001:db8::2"),
+		}
+		udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv4
+		ip4 := &layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Id:       99,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.ParseIP("192.168.1.1"),
+			DstIP:    net.ParseIP("192.168.1.2"),
+		}
+		udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	}
+
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("Error serializing layers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func toPacket(data []byte, i int) common.Packet {
+	return common.Packet{
+		Data:  data,
+		Index: i,
+		CaptureInfo: gopacket.CaptureInfo{
+			Timestamp:     time.Unix(1700000000, 0),
+			CaptureLength: len(data),
+			Length:        len(data),
+		},
+	}
+}
+
+func TestFragmentDefrag_RoundTrip(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		orig := buildUDP(t, v6, 3000)
+
+		fr, err := fragment.NewFragmenter(1280)
+		if err != nil {
+			t.Fatalf("NewFragmenter returned error: %v", err)
+		}
+		frags, err := fr.Process(toPacket(orig, 1))
+		if err != nil {
+			t.Fatalf("v6=%v: Fragmenter returned error: %v", v6, err)
+		}
+		if len(frags) != 3 {
+			t.Fatalf("v6=%v: expected 3 fragments, got %d", v6, len(frags))
+		}
+		for i, f := range frags {
+			if len(f.Data)-14 > 1280 {
+				t.Errorf("v6=%v: fragment %d is %d bytes, exceeds MTU", v6, i, len(f.Data)-14)
+			}
+			p := gopacket.NewPacket(f.Data, layers.LayerTypeEthernet, gopacket.Default)
+			if p.ErrorLayer() != nil {
+				t.Errorf("v6=%v: fragment %d does not decode: %v", v6, i, p.ErrorLayer().Error())
+			}
+		}
+
+		// Feed fragments out of order.
+		df := fragment.NewDefragmenter(0)
+		var out []common.Packet
+		for _, i := range []int{2, 0, 1} {
+			got, err := df.Process(frags[i])
+			if err != nil {
+				t.Fatalf("v6=%v: Defragmenter returned error: %v", v6, err)
+			}
+			out = append(out, got...)
+		}
+		if len(out) != 1 {
+			t.Fatalf("v6=%v: expected 1 reassembled packet, got %d", v6, len(out))
+		}
+		if !bytes.Equal(out[0].Data, orig) {
+			t.Errorf("v6=%v: reassembled packet differs from original", v6)
+		}
+	}
+}
+
+func TestFragmenter_SmallPacketPassesThrough(t *testing.T) {
+	fr, _ := fragment.NewFragmenter(1500)
+	orig := buildUDP(t, false, 100)
+	out, err := fr.Process(toPacket(orig, 1))
+	if err != nil || len(out) != 1 || !bytes.Equal(out[0].Data, orig) {
+		t.Errorf("Expected small packet to pass through unchanged")
+	}
+}
+
+func TestTruncatedFramePassesThrough(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		// A 1000-byte datagram cut to 96 bytes by the capture snaplen.
+		orig := buildUDP(t, v6, 1000)[:96]
+		fr, _ := fragment.NewFragmenter(1500)
+		stages := []common.Stage{fragment.NewDefragmenter(0), fr}
+		for _, st := range stages {
+			out, err := st.Process(toPacket(orig, 1))
+			if err != nil || len(out) != 1 || !bytes.Equal(out[0].Data, orig) {
+				t.Errorf("v6=%v: %s: expected truncated frame to pass through, got %d packets, err %v", v6, st.Name(), len(out), err)
+			}
+		}
+	}
+}
+
+func TestDefragmenter_FlushDropsIncomplete(t *testing.T) {
+	fr, _ := fragment.NewFragmenter(576)
+	frags, _ := fr.Process(toPacket(buildUDP(t, false, 2000), 1))
+
+	df := fragment.NewDefragmenter(0)
+	for _, f := range frags[:len(frags)-1] {
+		if out, _ := df.Process(f); len(out) != 0 {
+			t.Fatalf("Incomplete datagram was emitted")
+		}
+	}
+	if out, _ := df.Flush(); len(out) != 0 || df.Expired != 1 {
+		t.Errorf("Expected incomplete datagram to be expired on flush")
+	}
+}
+
+func TestDefragmenter_RejectsOversizeIPv4(t *testing.T) {
+	// A last fragment at the highest offset makes the datagram longer
+	// than the 16-bit total length can describe.
+	data := buildUDP(t, false, 100)
+	data[14+6], data[14+7] = 0x1f, 0xff
+
+	df := fragment.NewDefragmenter(0)
+	if out, err := df.Process(toPacket(data, 1)); err == nil || len(out) != 0 {
+		t.Fatalf("Expected an error for an oversize datagram, got %d packets, err %v", len(out), err)
+	}
+	if df.Flush(); df.Expired != 0 {
+		t.Errorf("Expected the oversize datagram to be discarded, %d still pending", df.Expired)
+	}
+}
+
+func TestDefragmenter_EvictsOldest(t *testing.T) {
+	fr, _ := fragment.NewFragmenter(576)
+	frags, _ := fr.Process(toPacket(buildUDP(t, false, 1000), 1))
+	withID := func(p common.Packet, id int) common.Packet {
+		p.Data = append([]byte(nil), p.Data...)
+		p.Data[14+4], p.Data[14+5] = byte(id>>8), byte(id)
+		return p
+	}
+
+	df := fragment.NewDefragmenter(0)
+	const n = 1025
+	for id := 0; id < n; id++ {
+		if _, err := df.Process(withID(frags[0], id)); err != nil {
+			t.Fatalf("Process returned error: %v", err)
+		}
+	}
+	if df.Expired != 1 {
+		t.Errorf("Expected 1 datagram evicted, got %d", df.Expired)
+	}
+	if out, _ := df.Process(withID(frags[1], 0)); len(out) != 0 {
+		t.Error("Expected the oldest datagram to have been evicted")
+	}
+	if out, _ := df.Process(withID(frags[1], n-1)); len(out) != 1 {
+		t.Error("Expected the newest datagram to complete")
+	}
+}
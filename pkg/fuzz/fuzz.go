@@ -0,0 +1,376 @@
+package fuzz
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"osi-replay/pkg/common"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Mutation kinds.
+const (
+	KindBitFlip  = "bitflip"
+	KindBoundary = "boundary"
+	KindTruncate = "truncate"
+	KindChecksum = "checksum"
+	KindOptions  = "options"
+	KindLength   = "length"
+)
+
+// AllKinds lists every mutation kind.
+var AllKinds = []string{KindBitFlip, KindBoundary, KindTruncate, KindChecksum, KindOptions, KindLength}
+
+// Config controls the Fuzzer.
+type Config struct {
+	// Seed makes the mutations reproducible for a given input.
+	Seed int64
+	// Rate is the probability that a packet is mutated; 0 means 1.
+	Rate float64
+	// Kinds restricts the mutations applied; all when empty.
+	Kinds []string
+	// Log, if set, receives one JSON Mutation per mutated packet.
+	Log io.Writer
+	// LinkType of the frames; Ethernet when zero.
+	LinkType layers.LinkType
+}
+
+// Mutation records a change made to a packet. Offset is the first byte
+// changed; Detail describes the change.
+type Mutation struct {
+	Index  int    `json:"index"`
+	Kind   string `json:"kind"`
+	Offset int    `json:"offset"`
+	Detail string `json:"detail"`
+}
+
+// String formats m for a packet comment.
+func (m Mutation) String() string {
+	return fmt.Sprintf("fuzz %s @%d: %s", m.Kind, m.Offset, m.Detail)
+}
+
+// Fuzzer mutates packets with a seeded RNG. It implements common.Stage;
+// mutated packets carry their Mutation as a comment.
+type Fuzzer struct {
+	rate     float64
+	kinds    map[string]bool
+	rng      *rand.Rand
+	log      *json.Encoder
+	linkType layers.LinkType
+	Mutated  int
+}
+
+// New validates cfg and returns a Fuzzer.
+func New(cfg Config) (*Fuzzer, error) {
+	if cfg.Rate < 0 || cfg.Rate > 1 {
+		return nil, fmt.Errorf("fuzz rate must be between 0 and 1")
+	}
+	f := &Fuzzer{
+		rate:     cfg.Rate,
+		kinds:    make(map[string]bool),
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		linkType: cfg.LinkType,
+	}
+	if f.rate == 0 {
+		f.rate = 1
+	}
+	if f.linkType == 0 {
+		f.linkType = layers.LinkTypeEthernet
+	}
+	if cfg.Log != nil {
+		f.log = json.NewEncoder(cfg.Log)
+	}
+	kinds := cfg.Kinds
+	if len(kinds) == 0 {
+		kinds = AllKinds
+	}
+	for _, k := range kinds {
+		valid := false
+		for _, known := range AllKinds {
+			valid = valid || k == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown mutation kind %q", k)
+		}
+		f.kinds[k] = true
+	}
+	return f, nil
+}
+
+// Name implements common.Stage.
+func (f *Fuzzer) Name() string { return "fuzz" }
+
+// Process mutates pkt with probability Rate.
+func (f *Fuzzer) Process(pkt common.Packet) ([]common.Packet, error) {
+	if f.rng.Float64() >= f.rate {
+		return []common.Packet{pkt}, nil
+	}
+	data, m := f.Mutate(pkt.Data)
+	if m == nil {
+		return []common.Packet{pkt}, nil
+	}
+	m.Index = pkt.Index
+	f.Mutated++
+	if f.log != nil {
+		if err := f.log.Encode(m); err != nil {
+			return nil, fmt.Errorf("error writing mutation log: %w", err)
+		}
+	}
+	// Truncation models a short capture and keeps the wire length;
+	// mutations that insert or remove bytes change it by as much.
+	if m.Kind != KindTruncate {
+		pkt.CaptureInfo.Length += len(data) - len(pkt.Data)
+	}
+	pkt.Data = data
+	pkt.CaptureInfo.CaptureLength = len(data)
+	pkt.Comment = m.String()
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage; the Fuzzer holds no packets.
+func (f *Fuzzer) Flush() ([]common.Packet, error) { return nil, nil }
+
+// field is a header field a mutation can target, by absolute offset.
+type field struct {
+	name   string
+	offset int
+	size   int
+}
+
+// frame is a decoded copy of the packet being mutated.
+type frame struct {
+	data      []byte
+	ip4       int // offset of the IPv4 header, or -1
+	ip6       int
+	tcp       int
+	udp       int
+	fields    []field // boundary candidates
+	checksums []field
+	lengths   []field
+}
+
+func (f *Fuzzer) decode(data []byte) *frame {
+	fr := &frame{data: append([]byte(nil), data...), ip4: -1, ip6: -1, tcp: -1, udp: -1}
+	packet := gopacket.NewPacket(fr.data, f.linkType, gopacket.DecodeOptions{NoCopy: true})
+	var off int
+	for _, l := range packet.Layers() {
+		add := func(dst *[]field, name string, rel, size int) {
+			*dst = append(*dst, field{name: l.LayerType().String() + "." + name, offset: off + rel, size: size})
+		}
+		switch l.(type) {
+		case *layers.IPv4:
+			fr.ip4 = off
+			add(&fr.fields, "TOS", 1, 1)
+			add(&fr.fields, "Length", 2, 2)
+			add(&fr.fields, "Id", 4, 2)
+			add(&fr.fields, "FlagsFragOffset", 6, 2)
+			add(&fr.fields, "TTL", 8, 1)
+			add(&fr.fields, "Protocol", 9, 1)
+			add(&fr.checksums, "Checksum", 10, 2)
+			add(&fr.lengths, "Length", 2, 2)
+		case *layers.IPv6:
+			fr.ip6 = off
+			add(&fr.fields, "Length", 4, 2)
+			add(&fr.fields, "NextHeader", 6, 1)
+			add(&fr.fields, "HopLimit", 7, 1)
+			add(&fr.lengths, "Length", 4, 2)
+		case *layers.TCP:
+			fr.tcp = off
+			add(&fr.fields, "SrcPort", 0, 2)
+			add(&fr.fields, "DstPort", 2, 2)
+			add(&fr.fields, "Seq", 4, 4)
+			add(&fr.fields, "Ack", 8, 4)
+			add(&fr.fields, "DataOffset", 12, 1)
+			add(&fr.fields, "Flags", 13, 1)
+			add(&fr.fields, "Window", 14, 2)
+			add(&fr.fields, "Urgent", 18, 2)
+			add(&fr.checksums, "Checksum", 16, 2)
+		case *layers.UDP:
+			fr.udp = off
+			add(&fr.fields, "SrcPort", 0, 2)
+			add(&fr.fields, "DstPort", 2, 2)
+			add(&fr.fields, "Length", 4, 2)
+			add(&fr.checksums, "Checksum", 6, 2)
+			add(&fr.lengths, "Length", 4, 2)
+		case *layers.ICMPv4:
+			add(&fr.fields, "Type", 0, 1)
+			add(&fr.fields, "Code", 1, 1)
+			add(&fr.checksums, "Checksum", 2, 2)
+		case *layers.ICMPv6:
+			add(&fr.fields, "Type", 0, 1)
+			add(&fr.fields, "Code", 1, 1)
+			add(&fr.checksums, "Checksum", 2, 2)
+		}
+		off += len(l.LayerContents())
+	}
+	return fr
+}
+
+// Mutate returns a mutated copy of data and a record of the change, or
+// nil if no enabled mutation applies to the packet.
+func (f *Fuzzer) Mutate(data []byte) ([]byte, *Mutation) {
+	fr := f.decode(data)
+
+	var candidates []string
+	for _, k := range AllKinds {
+		if f.kinds[k] && fr.applies(k) {
+			candidates = append(candidates, k)
+		}
+	}
+	if len(candidates) == 0 {
+		return data, nil
+	}
+
+	m := &Mutation{Kind: candidates[f.rng.Intn(len(candidates))]}
+	switch m.Kind {
+	case KindBitFlip:
+		f.bitFlip(fr, m)
+	case KindBoundary:
+		f.boundary(fr, m)
+	case KindTruncate:
+		n := 1 + f.rng.Intn(len(fr.data)-1)
+		m.Offset, m.Detail = n, fmt.Sprintf("truncated %d bytes to %d", len(fr.data), n)
+		fr.data = fr.data[:n]
+	case KindChecksum:
+		fl := fr.checksums[f.rng.Intn(len(fr.checksums))]
+		old := getUint(fr.data, fl)
+		v := old ^ uint64(1+f.rng.Intn(0xffff))
+		putUint(fr.data, fl, v)
+		m.Offset, m.Detail = fl.offset, fmt.Sprintf("%s 0x%04x -> 0x%04x", fl.name, old, v)
+	case KindOptions:
+		f.options(fr, m)
+	case KindLength:
+		fl := fr.lengths[f.rng.Intn(len(fr.lengths))]
+		old := getUint(fr.data, fl)
+		delta := int64(1 + f.rng.Intn(64))
+		if f.rng.Intn(2) == 0 && int64(old) >= delta {
+			delta = -delta
+		}
+		v := uint64(int64(old)+delta) & 0xffff
+		putUint(fr.data, fl, v)
+		m.Offset, m.Detail = fl.offset, fmt.Sprintf("%s %d -> %d", fl.name, old, v)
+	}
+	return fr.data, m
+}
+
+func (fr *frame) applies(kind string) bool {
+	switch kind {
+	case KindBitFlip:
+		return len(fr.data) > 0
+	case KindTruncate:
+		return len(fr.data) > 1
+	case KindBoundary:
+		return len(fr.fields) > 0
+	case KindChecksum:
+		return len(fr.checksums) > 0
+	case KindLength:
+		return len(fr.lengths) > 0
+	case KindOptions:
+		return fr.ip4 >= 0 || fr.tcp >= 0
+	}
+	return false
+}
+
+func (f *Fuzzer) bitFlip(fr *frame, m *Mutation) {
+	n := 1 + f.rng.Intn(8)
+	m.Offset = len(fr.data)
+	bits := make([]int, n)
+	for i := range bits {
+		bit := f.rng.Intn(len(fr.data) * 8)
+		fr.data[bit/8] ^= 1 << (bit % 8)
+		bits[i] = bit
+		if bit/8 < m.Offset {
+			m.Offset = bit / 8
+		}
+	}
+	m.Detail = fmt.Sprintf("flipped bits %v", bits)
+}
+
+func (f *Fuzzer) boundary(fr *frame, m *Mutation) {
+	fl := fr.fields[f.rng.Intn(len(fr.fields))]
+	max := uint64(1)<<(8*fl.size) - 1
+	half := (max + 1) / 2
+	values := []uint64{0, 1, half - 1, half, max - 1, max}
+	old := getUint(fr.data, fl)
+	v := values[f.rng.Intn(len(values))]
+	putUint(fr.data, fl, v)
+	m.Offset, m.Detail = fl.offset, fmt.Sprintf("%s %d -> %d", fl.name, old, v)
+}
+
+// options corrupts the first TCP or IPv4 option, or inserts a malformed
+// IPv4 option when the packet has none.
+func (f *Fuzzer) options(fr *frame, m *Mutation) {
+	badLen := []byte{0, 1, 0xff}[f.rng.Intn(3)]
+	if fr.tcp >= 0 && len(fr.data) > fr.tcp+12 {
+		if doff := int(fr.data[fr.tcp+12]>>4) * 4; doff > 20 && fr.tcp+doff <= len(fr.data) {
+			kind := []byte{2, 3, 4, 8}[f.rng.Intn(4)]
+			m.Offset = fr.tcp + 20
+			fr.data[m.Offset], fr.data[m.Offset+1] = kind, badLen
+			m.Detail = fmt.Sprintf("TCP option kind %d length %d", kind, badLen)
+			return
+		}
+	}
+	if fr.ip4 < 0 {
+		// TCP without options over IPv6: fall back to a bad data offset.
+		fl := field{name: "TCP.DataOffset", offset: fr.tcp + 12, size: 1}
+		old := getUint(fr.data, fl)
+		putUint(fr.data, fl, 0xf0|old&0x0f)
+		m.Offset, m.Detail = fl.offset, "TCP data offset 15 without options"
+		return
+	}
+
+	ihl := int(fr.data[fr.ip4]&0x0f) * 4
+	if ihl > 20 {
+		m.Offset = fr.ip4 + 20
+		fr.data[m.Offset+1] = badLen
+		m.Detail = fmt.Sprintf("IPv4 option kind %d length %d", fr.data[m.Offset], badLen)
+	} else {
+		// Timestamp option with a length below its 4-byte minimum.
+		opt := []byte{0x44, 0x02, 0x00, 0x00}
+		at := fr.ip4 + 20
+		fr.data = append(fr.data[:at], append(opt, fr.data[at:]...)...)
+		fr.data[fr.ip4] = fr.data[fr.ip4]&0xf0 | byte(ihl/4+1)
+		total := binary.BigEndian.Uint16(fr.data[fr.ip4+2:])
+		binary.BigEndian.PutUint16(fr.data[fr.ip4+2:], total+4)
+		m.Offset, m.Detail = at, "inserted IPv4 timestamp option with length 2"
+		ihl += 4
+	}
+	// Keep the header checksum valid so only the option is malformed.
+	hdr := fr.data[fr.ip4 : fr.ip4+ihl]
+	binary.BigEndian.PutUint16(hdr[10:], 0)
+	binary.BigEndian.PutUint16(hdr[10:], checksum(hdr))
+}
+
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func getUint(b []byte, fl field) uint64 {
+	var v uint64
+	for _, c := range b[fl.offset : fl.offset+fl.size] {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func putUint(b []byte, fl field, v uint64) {
+	for i := fl.size - 1; i >= 0; i-- {
+		b[fl.offset+i] = byte(v)
+		v >>= 8
+	}
+}
@@ -0,0 +1,194 @@
+package fuzz_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/fuzz"
+)
+
+func buildTCP(t *testing.T) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP("10.0.0.1"),
+		DstIP:    net.ParseIP("10.0.0.2"),
+	}
+	tcp := &layers.TCP{
+		SrcPort: 1234,
+		DstPort: 80,
+		SYN:     true,
+		Window:  65535,
+		Options: []layers.TCPOption{{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}}},
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload("hello")); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// snapped is how much longer the input packets were on the wire than
+// captured.
+const snapped = 100
+
+func run(t *testing.T, cfg fuzz.Config, n int) ([]common.Packet, []fuzz.Mutation) {
+	t.Helper()
+	var log bytes.Buffer
+	cfg.Log = &log
+	f, err := fuzz.New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	data := buildTCP(t)
+	var out []common.Packet
+	for i := 1; i <= n; i++ {
+		pkt := common.Packet{Data: data, Index: i}
+		pkt.CaptureInfo.CaptureLength = len(data)
+		pkt.CaptureInfo.Length = len(data) + snapped
+		res, err := f.Process(pkt)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		out = append(out, res...)
+	}
+	var muts []fuzz.Mutation
+	dec := json.NewDecoder(&log)
+	for dec.More() {
+		var m fuzz.Mutation
+		if err := dec.Decode(&m); err != nil {
+			t.Fatalf("decode log: %v", err)
+		}
+		muts = append(muts, m)
+	}
+	if len(muts) != f.Mutated {
+		t.Fatalf("logged %d mutations, Mutated = %d", len(muts), f.Mutated)
+	}
+	return out, muts
+}
+
+func TestReproducible(t *testing.T) {
+	a, ma := run(t, fuzz.Config{Seed: 42}, 50)
+	b, mb := run(t, fuzz.Config{Seed: 42}, 50)
+	for i := range a {
+		if !bytes.Equal(a[i].Data, b[i].Data) {
+			t.Fatalf("packet %d differs between runs with the same seed", i+1)
+		}
+	}
+	if len(ma) != 50 || len(mb) != 50 {
+		t.Fatalf("expected every packet mutated, got %d and %d", len(ma), len(mb))
+	}
+	for i := range ma {
+		if ma[i] != mb[i] {
+			t.Fatalf("mutation %d differs: %+v vs %+v", i, ma[i], mb[i])
+		}
+		if ma[i].Index != i+1 || a[i].Comment == "" {
+			t.Errorf("mutation %d: index %d comment %q", i, ma[i].Index, a[i].Comment)
+		}
+	}
+
+	c, _ := run(t, fuzz.Config{Seed: 43}, 50)
+	same := true
+	for i := range a {
+		same = same && bytes.Equal(a[i].Data, c[i].Data)
+	}
+	if same {
+		t.Error("different seeds produced identical output")
+	}
+}
+
+func TestKinds(t *testing.T) {
+	orig := buildTCP(t)
+	for _, kind := range fuzz.AllKinds {
+		out, muts := run(t, fuzz.Config{Seed: 7, Kinds: []string{kind}}, 20)
+		for i, m := range muts {
+			if m.Kind != kind {
+				t.Errorf("%s: got mutation kind %s", kind, m.Kind)
+			}
+			if kind == fuzz.KindTruncate && len(out[i].Data) >= len(orig) {
+				t.Errorf("truncate: length %d not below %d", len(out[i].Data), len(orig))
+			}
+			if out[i].CaptureInfo.CaptureLength != len(out[i].Data) {
+				t.Errorf("%s: capture length %d, data %d", kind, out[i].CaptureInfo.CaptureLength, len(out[i].Data))
+			}
+			want := len(out[i].Data) + snapped
+			if kind == fuzz.KindTruncate {
+				want = len(orig) + snapped
+			}
+			if out[i].CaptureInfo.Length != want {
+				t.Errorf("%s: wire length %d, want %d", kind, out[i].CaptureInfo.Length, want)
+			}
+		}
+		if len(muts) != 20 {
+			t.Errorf("%s: %d mutations, want 20", kind, len(muts))
+		}
+	}
+}
+
+func TestInsertIPv4Option(t *testing.T) {
+	f, err := fuzz.New(fuzz.Config{Kinds: []string{fuzz.KindOptions}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A frame without TCP options so the IPv4 path is taken.
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{6, 7, 8, 9, 10, 11},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("10.0.0.1"), DstIP: net.ParseIP("10.0.0.2")}
+	udp := &layers.UDP{SrcPort: 1, DstPort: 2}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		eth, ip, udp, gopacket.Payload("data")); err != nil {
+		t.Fatal(err)
+	}
+	data, m := f.Mutate(buf.Bytes())
+	if m == nil || m.Kind != fuzz.KindOptions {
+		t.Fatalf("unexpected mutation %+v", m)
+	}
+	if len(data) != len(buf.Bytes())+4 {
+		t.Fatalf("length %d, want %d", len(data), len(buf.Bytes())+4)
+	}
+	hdr := data[14:38]
+	if hdr[0] != 0x46 || hdr[3] != 20+4+8+4 || hdr[20] != 0x44 || hdr[21] != 2 {
+		t.Errorf("unexpected header % x", hdr)
+	}
+	var sum uint32
+	for i := 0; i < len(hdr); i += 2 {
+		sum += uint32(hdr[i])<<8 | uint32(hdr[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	if sum != 0xffff {
+		t.Errorf("IPv4 header checksum invalid after option insert")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := fuzz.New(fuzz.Config{Kinds: []string{"nope"}}); err == nil {
+		t.Error("expected error for unknown kind")
+	}
+	if _, err := fuzz.New(fuzz.Config{Rate: 2}); err == nil {
+		t.Error("expected error for rate > 1")
+	}
+}
@@ -0,0 +1,391 @@
+package generate
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/craft"
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Conversation kinds.
+const (
+	KindTCP  = "tcp"
+	KindHTTP = "http"
+	KindDNS  = "dns"
+	KindICMP = "icmp"
+)
+
+// Defaults applied by New.
+const (
+	DefaultCount    = 100
+	DefaultRate     = 10
+	DefaultRTT      = 20 * time.Millisecond
+	DefaultDataSize = 512
+	DefaultTCPPort  = 5001
+	// TCP payload limits that keep packets within a 1500-byte MTU after
+	// the 40-byte IPv4+TCP or 60-byte IPv6+TCP headers.
+	mssIPv4 = 1460
+	mssIPv6 = 1440
+)
+
+// Config describes the traffic to generate.
+type Config struct {
+	// Clients and Servers are IPv4 or IPv6 addresses; each conversation
+	// picks one of each at random. Mixing families is not supported
+	// within a conversation, so pairs are drawn from the same family.
+	Clients []string
+	Servers []string
+	// Kinds lists the conversation types to draw from; all when empty.
+	Kinds []string
+	// Count is the number of conversations.
+	Count int
+	// Rate is the mean number of conversations started per second of
+	// capture time; arrivals are Poisson.
+	Rate float64
+	// RTT is the round-trip time between client and server.
+	RTT time.Duration
+	// DataSize is the payload each side sends in a plain TCP conversation
+	// and the HTTP response body size.
+	DataSize int
+	// Start is the time of the first packet; zero means the Unix epoch.
+	Start time.Time
+	// Seed makes the output reproducible.
+	Seed int64
+}
+
+// Generator produces the packets of Config's conversations in timestamp
+// order. Only conversations that overlap in time are held in memory.
+type Generator struct {
+	cfg     Config
+	rng     *rand.Rand
+	clients []net.IP
+	servers []net.IP
+	next    time.Time // start of the next conversation
+	started int
+	pending []common.Packet
+	index   int
+}
+
+// New validates cfg and returns a Generator.
+func New(cfg Config) (*Generator, error) {
+	if cfg.Count <= 0 {
+		cfg.Count = DefaultCount
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = DefaultRate
+	}
+	if cfg.RTT <= 0 {
+		cfg.RTT = DefaultRTT
+	}
+	if cfg.DataSize <= 0 {
+		cfg.DataSize = DefaultDataSize
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = time.Unix(0, 0).UTC()
+	}
+	if len(cfg.Kinds) == 0 {
+		cfg.Kinds = []string{KindTCP, KindHTTP, KindDNS, KindICMP}
+	}
+	for _, k := range cfg.Kinds {
+		switch k {
+		case KindTCP, KindHTTP, KindDNS, KindICMP:
+		default:
+			return nil, fmt.Errorf("unknown conversation kind %q", k)
+		}
+	}
+
+	g := &Generator{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed)), next: cfg.Start}
+	var err error
+	if g.clients, err = parseHosts(cfg.Clients); err != nil {
+		return nil, fmt.Errorf("clients: %w", err)
+	}
+	if g.servers, err = parseHosts(cfg.Servers); err != nil {
+		return nil, fmt.Errorf("servers: %w", err)
+	}
+	for _, c := range g.clients {
+		if len(g.peers(c)) > 0 {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("no client and server share an address family")
+}
+
+func parseHosts(hosts []string) ([]net.IP, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("at least one address is required")
+	}
+	out := make([]net.IP, len(hosts))
+	for i, h := range hosts {
+		if out[i] = net.ParseIP(strings.TrimSpace(h)); out[i] == nil {
+			return nil, fmt.Errorf("invalid IP address %q", h)
+		}
+	}
+	return out, nil
+}
+
+// peers returns the servers in the same address family as client.
+func (g *Generator) peers(client net.IP) []net.IP {
+	var out []net.IP
+	for _, s := range g.servers {
+		if (s.To4() == nil) == (client.To4() == nil) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Next returns the next packet, or io.EOF when all conversations are done.
+func (g *Generator) Next() (common.Packet, error) {
+	for g.started < g.cfg.Count && (len(g.pending) == 0 || !g.pending[0].CaptureInfo.Timestamp.Before(g.next)) {
+		pkts, err := g.conversation(g.next)
+		if err != nil {
+			return common.Packet{}, err
+		}
+		g.started++
+		g.next = g.next.Add(time.Duration(g.rng.ExpFloat64() / g.cfg.Rate * float64(time.Second)))
+		g.pending = append(g.pending, pkts...)
+		sort.SliceStable(g.pending, func(i, j int) bool {
+			return g.pending[i].CaptureInfo.Timestamp.Before(g.pending[j].CaptureInfo.Timestamp)
+		})
+	}
+	if len(g.pending) == 0 {
+		return common.Packet{}, io.EOF
+	}
+	pkt := g.pending[0]
+	g.pending = g.pending[1:]
+	g.index++
+	pkt.Index = g.index
+	return pkt, nil
+}
+
+// endpoint is one side of a conversation.
+type endpoint struct {
+	ip   net.IP
+	mac  string
+	port uint16
+}
+
+// conv builds the packets of one conversation.
+type conv struct {
+	g        *Generator
+	ts       time.Time
+	client   endpoint
+	server   endpoint
+	pkts     []common.Packet
+	seq, ack uint32 // client's next sequence number and acknowledgment
+}
+
+func (g *Generator) conversation(start time.Time) ([]common.Packet, error) {
+	var client, server net.IP
+	for server == nil {
+		client = g.clients[g.rng.Intn(len(g.clients))]
+		if peers := g.peers(client); len(peers) > 0 {
+			server = peers[g.rng.Intn(len(peers))]
+		}
+	}
+	c := &conv{
+		g:      g,
+		ts:     start,
+		client: endpoint{ip: client, mac: macFor(client), port: uint16(49152 + g.rng.Intn(16384))},
+		server: endpoint{ip: server, mac: macFor(server)},
+	}
+
+	var err error
+	switch kind := g.cfg.Kinds[g.rng.Intn(len(g.cfg.Kinds))]; kind {
+	case KindTCP:
+		c.server.port = DefaultTCPPort
+		err = c.tcp(g.randomBytes(g.cfg.DataSize), g.randomBytes(g.cfg.DataSize))
+	case KindHTTP:
+		c.server.port = 80
+		body := strings.Repeat("x", g.cfg.DataSize)
+		req := fmt.Sprintf("GET /item/%d HTTP/1.1\r\nHost: %s\r\nUser-Agent: osi-replay\r\nAccept: */*\r\n\r\n", g.rng.Intn(10000), server)
+		resp := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		err = c.tcp([]byte(req), []byte(resp))
+	case KindDNS:
+		c.server.port = 53
+		err = c.dns()
+	case KindICMP:
+		err = c.icmp()
+	}
+	return c.pkts, err
+}
+
+// macFor derives a stable locally administered MAC from ip.
+func macFor(ip net.IP) string {
+	b := ip.To16()
+	return net.HardwareAddr{0x02, 0x00, b[12], b[13], b[14], b[15]}.String()
+}
+
+func (g *Generator) randomBytes(n int) []byte {
+	b := make([]byte, n)
+	g.rng.Read(b)
+	return b
+}
+
+// add serializes p from src to dst and advances the clock by half an RTT.
+func (c *conv) add(fromClient bool, p craft.Packet) error {
+	src, dst := c.client, c.server
+	if !fromClient {
+		src, dst = dst, src
+	}
+	p.Ethernet = &craft.Ethernet{Src: src.mac, Dst: dst.mac}
+	if src.ip.To4() != nil {
+		p.IPv4 = &craft.IPv4{Src: src.ip.String(), Dst: dst.ip.String(), ID: uint16(c.g.rng.Intn(65536))}
+	} else {
+		p.IPv6 = &craft.IPv6{Src: src.ip.String(), Dst: dst.ip.String()}
+	}
+	if p.TCP != nil {
+		p.TCP.Src, p.TCP.Dst = src.port, dst.port
+	}
+	if p.UDP != nil {
+		p.UDP.Src, p.UDP.Dst = src.port, dst.port
+	}
+
+	data, err := p.Serialize(layers.LinkTypeEthernet)
+	if err != nil {
+		return err
+	}
+	c.pkts = append(c.pkts, common.Packet{
+		Data:        data,
+		CaptureInfo: gopacket.CaptureInfo{Timestamp: c.ts, CaptureLength: len(data), Length: len(data)},
+	})
+	c.ts = c.ts.Add(c.g.cfg.RTT / 2)
+	return nil
+}
+
+// segment sends a TCP segment and advances the sender's sequence number.
+func (c *conv) segment(fromClient bool, flags string, payload []byte) error {
+	seq, ack := c.seq, c.ack
+	if !fromClient {
+		seq, ack = c.ack, c.seq
+	}
+	if err := c.add(fromClient, craft.Packet{TCP: &craft.TCP{Seq: seq, Ack: ack, Flags: flags}, Payload: string(payload)}); err != nil {
+		return err
+	}
+	n := uint32(len(payload))
+	if strings.ContainsAny(flags, "SF") {
+		n++
+	}
+	if fromClient {
+		c.seq += n
+	} else {
+		c.ack += n
+	}
+	return nil
+}
+
+// tcp runs a handshake, one request and response, and a FIN teardown.
+func (c *conv) tcp(request, response []byte) error {
+	// Until the SYN-ACK, ack holds the server's initial sequence number.
+	c.seq, c.ack = c.g.rng.Uint32(), c.g.rng.Uint32()
+	if err := c.add(true, craft.Packet{TCP: &craft.TCP{Seq: c.seq, Flags: "S"}}); err != nil {
+		return err
+	}
+	c.seq++
+
+	for _, s := range []struct {
+		fromClient bool
+		flags      string
+		payload    []byte
+	}{
+		{false, "SA", nil},
+		{true, "A", nil},
+		{true, "PA", request},
+		{false, "A", nil},
+		{false, "PA", response},
+		{true, "A", nil},
+		{true, "FA", nil},
+		{false, "FA", nil},
+		{true, "A", nil},
+	} {
+		payload := s.payload
+		mss := mssIPv4
+		if c.client.ip.To4() == nil {
+			mss = mssIPv6
+		}
+		for len(payload) > mss {
+			if err := c.segment(s.fromClient, "A", payload[:mss]); err != nil {
+				return err
+			}
+			payload = payload[mss:]
+		}
+		if err := c.segment(s.fromClient, s.flags, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conv) dns() error {
+	id := uint16(c.g.rng.Intn(65536))
+	name := fmt.Sprintf("host%d.example.com", c.g.rng.Intn(1000))
+	qtype, answer := "A", fmt.Sprintf("192.0.2.%d", 1+c.g.rng.Intn(254))
+	if c.client.ip.To4() == nil {
+		qtype, answer = "AAAA", fmt.Sprintf("2001:db8::%x", 1+c.g.rng.Intn(0xfffe))
+	}
+	q := []craft.DNSRecord{{Name: name, Type: qtype}}
+	if err := c.add(true, craft.Packet{UDP: &craft.UDP{}, DNS: &craft.DNS{ID: id, Questions: q}}); err != nil {
+		return err
+	}
+	return c.add(false, craft.Packet{UDP: &craft.UDP{}, DNS: &craft.DNS{ID: id, Response: true, Questions: q,
+		Answers: []craft.DNSRecord{{Name: name, Type: qtype, TTL: 300, Data: answer}}}})
+}
+
+func (c *conv) icmp() error {
+	id, seq := uint16(c.g.rng.Intn(65536)), uint16(1)
+	payload := string(c.g.randomBytes(56))
+	req, rep := &craft.ICMP{Type: layers.ICMPv4TypeEchoRequest, ID: id, Seq: seq}, &craft.ICMP{Type: layers.ICMPv4TypeEchoReply, ID: id, Seq: seq}
+	if c.client.ip.To4() != nil {
+		if err := c.add(true, craft.Packet{ICMPv4: req, Payload: payload}); err != nil {
+			return err
+		}
+		return c.add(false, craft.Packet{ICMPv4: rep, Payload: payload})
+	}
+	req.Type, rep.Type = layers.ICMPv6TypeEchoRequest, layers.ICMPv6TypeEchoReply
+	if err := c.add(true, craft.Packet{ICMPv6: req, Payload: payload}); err != nil {
+		return err
+	}
+	return c.add(false, craft.Packet{ICMPv6: rep, Payload: payload})
+}
+
+// WriteFile writes all generated packets to outFile, as pcapng when the
+// name ends in ".pcapng". It returns the number of packets written.
+func (g *Generator) WriteFile(outFile string, logger *common.Logger) (int, error) {
+	f, err := os.Create(outFile)
+	if err != nil {
+		return 0, fmt.Errorf("error creating output file: %w", err)
+	}
+	defer f.Close()
+
+	writer, err := pcapio.NewWriter(f, outFile, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for {
+		pkt, err := g.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := writer.WritePacket(pkt.CaptureInfo, pkt.Data); err != nil {
+			return n, fmt.Errorf("error writing packet: %w", err)
+		}
+		n++
+	}
+	logger.Info("Generated traffic", "conversations", g.started, "packets", n)
+	return n, nil
+}
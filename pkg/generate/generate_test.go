@@ -0,0 +1,173 @@
+package generate_test
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/generate"
+	"osi-replay/pkg/pcapio"
+)
+
+func collect(t *testing.T, cfg generate.Config) []common.Packet {
+	t.Helper()
+	g, err := generate.New(cfg)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	var out []common.Packet
+	for {
+		pkt, err := g.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next returned error: %v", err)
+		}
+		out = append(out, pkt)
+	}
+}
+
+func TestGenerate_TCPSequenceNumbers(t *testing.T) {
+	pkts := collect(t, generate.Config{
+		Clients: []string{"10.0.0.1"}, Servers: []string{"10.0.0.2"},
+		Kinds: []string{generate.KindTCP}, Count: 1, DataSize: 3000,
+	})
+	// SYN, SYN-ACK, ACK, 3 request segments, ACK, 3 response segments,
+	// ACK, FIN, FIN, ACK.
+	if len(pkts) != 14 {
+		t.Fatalf("Expected 14 packets, got %d", len(pkts))
+	}
+
+	// next[true] is the client's next expected sequence number.
+	next := map[bool]uint32{}
+	for i, pkt := range pkts {
+		p := gopacket.NewPacket(pkt.Data, layers.LayerTypeEthernet, gopacket.Default)
+		tcp, _ := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		ip, _ := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if tcp == nil || ip == nil {
+			t.Fatalf("Packet %d is not TCP/IPv4", i+1)
+		}
+		if len(pkt.Data) > 1514 {
+			t.Errorf("Packet %d exceeds the MTU: %d bytes", i+1, len(pkt.Data))
+		}
+		fromClient := ip.SrcIP.String() == "10.0.0.1"
+		if seq, ok := next[fromClient]; ok && tcp.Seq != seq {
+			t.Errorf("Packet %d: expected seq %d, got %d", i+1, seq, tcp.Seq)
+		}
+		if peer, ok := next[!fromClient]; ok && tcp.ACK && tcp.Ack != peer {
+			t.Errorf("Packet %d: expected ack %d, got %d", i+1, peer, tcp.Ack)
+		}
+		n := uint32(len(tcp.Payload))
+		if tcp.SYN || tcp.FIN {
+			n++
+		}
+		next[fromClient] = tcp.Seq + n
+		if i > 0 && pkt.CaptureInfo.Timestamp.Before(pkts[i-1].CaptureInfo.Timestamp) {
+			t.Errorf("Packet %d is out of order", i+1)
+		}
+	}
+	if first := gopacket.NewPacket(pkts[0].Data, layers.LayerTypeEthernet, gopacket.Default).Layer(layers.LayerTypeTCP).(*layers.TCP); !first.SYN || first.ACK {
+		t.Errorf("Expected a bare SYN first, got %+v", first)
+	}
+}
+
+func TestGenerate_IPv6SegmentsFitMTU(t *testing.T) {
+	pkts := collect(t, generate.Config{
+		Clients: []string{"2001:db8::1"}, Servers: []string{"2001:db8::2"},
+		Kinds: []string{generate.KindTCP}, Count: 1, DataSize: 3000,
+	})
+	for i, pkt := range pkts {
+		if len(pkt.Data) > 1514 {
+			t.Errorf("Packet %d exceeds the MTU: %d bytes", i+1, len(pkt.Data))
+		}
+	}
+}
+
+func TestGenerate_Kinds(t *testing.T) {
+	pkts := collect(t, generate.Config{
+		Clients: []string{"10.0.0.1", "2001:db8::1"}, Servers: []string{"10.0.0.53", "2001:db8::53"},
+		Kinds: []string{generate.KindDNS, generate.KindHTTP, generate.KindICMP}, Count: 30, Seed: 7,
+	})
+	var dns, http, echo, v6 int
+	for i, pkt := range pkts {
+		if pkt.Index != i+1 {
+			t.Fatalf("Packet %d has index %d", i+1, pkt.Index)
+		}
+		if i > 0 && pkt.CaptureInfo.Timestamp.Before(pkts[i-1].CaptureInfo.Timestamp) {
+			t.Errorf("Packet %d is out of order", i+1)
+		}
+		p := gopacket.NewPacket(pkt.Data, layers.LayerTypeEthernet, gopacket.Default)
+		if p.ErrorLayer() != nil {
+			t.Fatalf("Packet %d decode error: %v", i+1, p.ErrorLayer().Error())
+		}
+		if p.Layer(layers.LayerTypeIPv6) != nil {
+			v6++
+		}
+		if d, ok := p.Layer(layers.LayerTypeDNS).(*layers.DNS); ok && d.QR {
+			dns++
+			if len(d.Answers) != 1 {
+				t.Errorf("DNS response without an answer")
+			}
+		}
+		if app := p.ApplicationLayer(); app != nil && bytes.HasPrefix(app.Payload(), []byte("HTTP/1.1 200 OK")) {
+			http++
+		}
+		if p.Layer(layers.LayerTypeICMPv4) != nil || p.Layer(layers.LayerTypeICMPv6Echo) != nil {
+			echo++
+		}
+	}
+	if dns == 0 || http == 0 || echo == 0 || v6 == 0 {
+		t.Errorf("Expected every kind and family, got dns=%d http=%d echo=%d v6=%d", dns, http, echo, v6)
+	}
+}
+
+func TestWriteFile_Deterministic(t *testing.T) {
+	dir := t.TempDir()
+	cfg := generate.Config{Clients: []string{"10.0.0.1"}, Servers: []string{"10.0.0.2"}, Count: 10, Seed: 42}
+	var files [2][]byte
+	for i := range files {
+		g, err := generate.New(cfg)
+		if err != nil {
+			t.Fatalf("New returned error: %v", err)
+		}
+		path := filepath.Join(dir, "gen.pcap")
+		if _, err := g.WriteFile(path, common.NewLogger("test")); err != nil {
+			t.Fatalf("WriteFile returned error: %v", err)
+		}
+		f, err := pcapio.Open(path)
+		if err != nil {
+			t.Fatalf("Error opening output: %v", err)
+		}
+		for {
+			data, _, err := f.ReadPacketData()
+			if err != nil {
+				break
+			}
+			files[i] = append(files[i], data...)
+		}
+		f.Close()
+	}
+	if len(files[0]) == 0 || !bytes.Equal(files[0], files[1]) {
+		t.Error("Expected identical output for the same seed")
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]generate.Config{
+		"no servers":   {Clients: []string{"10.0.0.1"}},
+		"bad address":  {Clients: []string{"nope"}, Servers: []string{"10.0.0.2"}},
+		"bad kind":     {Clients: []string{"10.0.0.1"}, Servers: []string{"10.0.0.2"}, Kinds: []string{"smtp"}},
+		"mixed family": {Clients: []string{"10.0.0.1"}, Servers: []string{"2001:db8::2"}},
+	} {
+		if _, err := generate.New(cfg); err == nil || strings.TrimSpace(err.Error()) == "" {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package info
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/google/gopacket"
+
+	"osi-replay/pkg/pcapio"
+)
+
+// sizeBuckets are the upper bounds (exclusive) of the packet size
+// histogram, matching Wireshark's packet length statistics.
+var sizeBuckets = []int{20, 40, 80, 160, 320, 640, 1280, 2560, 5120}
+
+// SizeBucket counts packets whose captured length falls in [Min, Max].
+// Max is -1 for the open-ended last bucket.
+type SizeBucket struct {
+	Min     int `json:"min"`
+	Max     int `json:"max"`
+	Packets int `json:"packets"`
+}
+
+// Summary describes the contents of a capture file.
+type Summary struct {
+	File               string       `json:"file"`
+	Format             string       `json:"format"`
+	LinkType           string       `json:"link_type"`
+	Snaplen            uint32       `json:"snaplen"`
+	TimestampPrecision string       `json:"timestamp_precision"`
+	Packets            int          `json:"packets"`
+	Bytes              int64        `json:"bytes"`
+	WireBytes          int64        `json:"wire_bytes"`
+	First              time.Time    `json:"first"`
+	Last               time.Time    `json:"last"`
+	Duration           float64      `json:"duration_seconds"`
+	BytesPerSecond     float64      `json:"bytes_per_second"`
+	BitsPerSecond      float64      `json:"bits_per_second"`
+	PacketsPerSecond   float64      `json:"packets_per_second"`
+	AveragePacketSize  float64      `json:"average_packet_size"`
+	MinPacketSize      int          `json:"min_packet_size"`
+	MaxPacketSize      int          `json:"max_packet_size"`
+	Sizes              []SizeBucket `json:"size_distribution"`
+}
+
+// Summarize reads the capture at path and returns its Summary.
+func Summarize(path string) (*Summary, error) {
+	reader, err := pcapio.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer reader.Close()
+
+	s := &Summary{
+		File:               path,
+		Format:             reader.Format,
+		LinkType:           reader.LinkType().String(),
+		Snaplen:            reader.Snaplen,
+		TimestampPrecision: precision(reader.Resolution()),
+		MinPacketSize:      math.MaxInt,
+	}
+	lo := 0
+	for _, hi := range sizeBuckets {
+		s.Sizes = append(s.Sizes, SizeBucket{Min: lo, Max: hi - 1})
+		lo = hi
+	}
+	s.Sizes = append(s.Sizes, SizeBucket{Min: lo, Max: -1})
+
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading packet %d: %w", s.Packets+1, err)
+		}
+		s.add(len(data), ci)
+	}
+
+	if s.Packets == 0 {
+		s.MinPacketSize = 0
+		return s, nil
+	}
+	s.AveragePacketSize = float64(s.Bytes) / float64(s.Packets)
+	s.Duration = s.Last.Sub(s.First).Seconds()
+	if s.Duration > 0 {
+		s.BytesPerSecond = float64(s.Bytes) / s.Duration
+		s.BitsPerSecond = s.BytesPerSecond * 8
+		s.PacketsPerSecond = float64(s.Packets) / s.Duration
+	}
+	return s, nil
+}
+
+func (s *Summary) add(size int, ci gopacket.CaptureInfo) {
+	if s.Packets == 0 || ci.Timestamp.Before(s.First) {
+		s.First = ci.Timestamp
+	}
+	if ci.Timestamp.After(s.Last) {
+		s.Last = ci.Timestamp
+	}
+	s.Packets++
+	s.Bytes += int64(size)
+	s.WireBytes += int64(ci.Length)
+	s.MinPacketSize = min(s.MinPacketSize, size)
+	s.MaxPacketSize = max(s.MaxPacketSize, size)
+
+	i := 0
+	for i < len(sizeBuckets) && size >= sizeBuckets[i] {
+		i++
+	}
+	s.Sizes[i].Packets++
+}
+
+// WriteText writes s to w in a capinfos-like layout.
+func (s *Summary) WriteText(w io.Writer) error {
+	const layout = "2006-01-02 15:04:05.000000000 MST"
+	lines := []struct {
+		label string
+		value any
+	}{
+		{"File name", s.File},
+		{"File format", s.Format},
+		{"Link type", s.LinkType},
+		{"Snapshot length", s.Snaplen},
+		{"Timestamp precision", s.TimestampPrecision},
+		{"Number of packets", s.Packets},
+		{"Data size (captured)", fmt.Sprintf("%d bytes", s.Bytes)},
+		{"Data size (on wire)", fmt.Sprintf("%d bytes", s.WireBytes)},
+		{"First packet time", s.First.UTC().Format(layout)},
+		{"Last packet time", s.Last.UTC().Format(layout)},
+		{"Capture duration", fmt.Sprintf("%.6f seconds", s.Duration)},
+		{"Data byte rate", fmt.Sprintf("%.2f bytes/s", s.BytesPerSecond)},
+		{"Data bit rate", fmt.Sprintf("%.2f bits/s", s.BitsPerSecond)},
+		{"Average packet size", fmt.Sprintf("%.2f bytes", s.AveragePacketSize)},
+		{"Average packet rate", fmt.Sprintf("%.2f packets/s", s.PacketsPerSecond)},
+		{"Packet size range", fmt.Sprintf("%d - %d bytes", s.MinPacketSize, s.MaxPacketSize)},
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintf(w, "%-22s %v\n", l.label+":", l.value); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintln(w, "Packet size distribution:"); err != nil {
+		return err
+	}
+	for _, b := range s.Sizes {
+		label := fmt.Sprintf("%d-%d", b.Min, b.Max)
+		if b.Max < 0 {
+			label = fmt.Sprintf("%d+", b.Min)
+		}
+		if _, err := fmt.Fprintf(w, "  %-12s %d\n", label, b.Packets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func precision(r gopacket.TimestampResolution) string {
+	switch r {
+	case gopacket.TimestampResolutionNanosecond:
+		return "nanoseconds"
+	case gopacket.TimestampResolutionMicrosecond:
+		return "microseconds"
+	case gopacket.TimestampResolutionMillisecond:
+		return "milliseconds"
+	}
+	return r.String()
+}
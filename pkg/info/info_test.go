@@ -0,0 +1,82 @@
+package info_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/info"
+)
+
+func TestSummarize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.pcap")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, size := range []int{60, 100, 1500} {
+		data := make([]byte, size)
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * time.Second),
+			CaptureLength: size,
+			Length:        size,
+		}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("Error writing packet: %v", err)
+		}
+	}
+	f.Close()
+
+	s, err := info.Summarize(path)
+	if err != nil {
+		t.Fatalf("Summarize returned error: %v", err)
+	}
+	if s.Packets != 3 || s.Bytes != 1660 || s.Snaplen != 65535 {
+		t.Errorf("Unexpected totals: %d packets, %d bytes, snaplen %d", s.Packets, s.Bytes, s.Snaplen)
+	}
+	if s.Duration != 2 || s.PacketsPerSecond != 1.5 {
+		t.Errorf("Expected 2s duration at 1.5 pps, got %vs at %v pps", s.Duration, s.PacketsPerSecond)
+	}
+	if s.LinkType != "Ethernet" || s.TimestampPrecision != "microseconds" {
+		t.Errorf("Unexpected link type %q or precision %q", s.LinkType, s.TimestampPrecision)
+	}
+	if s.MinPacketSize != 60 || s.MaxPacketSize != 1500 {
+		t.Errorf("Unexpected size range %d-%d", s.MinPacketSize, s.MaxPacketSize)
+	}
+	var bucketed int
+	for _, b := range s.Sizes {
+		bucketed += b.Packets
+		if b.Min == 40 && b.Packets != 1 {
+			t.Errorf("Expected one packet in 40-79 bucket, got %d", b.Packets)
+		}
+	}
+	if bucketed != 3 {
+		t.Errorf("Expected 3 packets in size distribution, got %d", bucketed)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Number of packets:     3") {
+		t.Errorf("Unexpected text output:\n%s", buf.String())
+	}
+}
+
+func TestSummarize_NoSuchFile(t *testing.T) {
+	if _, err := info.Summarize("no_such_file.pcap"); err == nil {
+		t.Errorf("Expected error with nonexistent input file, got nil")
+	}
+}
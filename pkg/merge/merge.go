@@ -0,0 +1,164 @@
+package merge
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+)
+
+// Input is one capture to merge.
+type Input struct {
+	Path string
+	// Offset is added to every timestamp read from Path, to correct for
+	// clock skew between capture points.
+	Offset time.Duration
+}
+
+// Options controls Merge.
+type Options struct {
+	// Concatenate writes the inputs one after another in the order given
+	// instead of interleaving them by timestamp.
+	Concatenate bool
+}
+
+// source is an open input with its next packet buffered.
+type source struct {
+	order  int
+	file   *pcapio.File
+	offset time.Duration
+	data   []byte
+	ci     gopacket.CaptureInfo
+}
+
+// next buffers the following packet; it returns io.EOF at the end. A
+// read error is logged and ends the input.
+func (s *source) next(logger *common.Logger) error {
+	data, ci, err := s.file.ReadPacketData()
+	if err == io.EOF {
+		return err
+	}
+	if err != nil {
+		logger.Error(fmt.Errorf("error reading packet data: %w", err))
+		return io.EOF
+	}
+	ci.Timestamp = ci.Timestamp.Add(s.offset)
+	s.data, s.ci = data, ci
+	return nil
+}
+
+// sourceHeap orders sources by their buffered timestamp, breaking ties
+// by input order so equal timestamps keep a stable order.
+type sourceHeap []*source
+
+func (h sourceHeap) Len() int { return len(h) }
+func (h sourceHeap) Less(i, j int) bool {
+	if h[i].ci.Timestamp.Equal(h[j].ci.Timestamp) {
+		return h[i].order < h[j].order
+	}
+	return h[i].ci.Timestamp.Before(h[j].ci.Timestamp)
+}
+func (h sourceHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x any)   { *h = append(*h, x.(*source)) }
+func (h *sourceHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// Merge writes the packets of all inputs to outFile. Only one packet per
+// input is held in memory at a time. All inputs must share a link type;
+// pcap output uses nanosecond timestamps if any input has them.
+func Merge(inputs []Input, outFile string, opts *Options, logger *common.Logger) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("no input files")
+	}
+
+	sources := make([]*source, 0, len(inputs))
+	defer func() {
+		for _, s := range sources {
+			s.file.Close()
+		}
+	}()
+	var snaplen uint32
+	res := gopacket.TimestampResolutionMicrosecond
+	for i, in := range inputs {
+		f, err := pcapio.Open(in.Path)
+		if err != nil {
+			return fmt.Errorf("error opening input file %s: %w", in.Path, err)
+		}
+		sources = append(sources, &source{order: i, file: f, offset: in.Offset})
+		if f.LinkType() != sources[0].file.LinkType() {
+			return fmt.Errorf("input %s has link type %s, expected %s",
+				in.Path, f.LinkType(), sources[0].file.LinkType())
+		}
+		if f.Snaplen > snaplen {
+			snaplen = f.Snaplen
+		}
+		if f.Resolution() == gopacket.TimestampResolutionNanosecond {
+			res = f.Resolution()
+		}
+	}
+	if snaplen == 0 {
+		snaplen = 65536
+	}
+
+	fOut, err := os.Create(outFile)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer fOut.Close()
+
+	writer, err := pcapio.NewResolutionWriter(fOut, outFile, snaplen, sources[0].file.LinkType(), res)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	write := func(s *source) error {
+		if err := writer.WritePacket(s.ci, s.data); err != nil {
+			return fmt.Errorf("error writing packet: %w", err)
+		}
+		count++
+		return nil
+	}
+
+	if opts.Concatenate {
+		for _, s := range sources {
+			for s.next(logger) == nil {
+				if err := write(s); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		h := make(sourceHeap, 0, len(sources))
+		for _, s := range sources {
+			if s.next(logger) == nil {
+				h = append(h, s)
+			}
+		}
+		heap.Init(&h)
+		for h.Len() > 0 {
+			s := h[0]
+			if err := write(s); err != nil {
+				return err
+			}
+			if s.next(logger) == nil {
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+		}
+	}
+
+	logger.Info("Merge complete", "packets", count, "inputs", len(inputs), "file", outFile)
+	return nil
+}
@@ -0,0 +1,132 @@
+package merge_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/merge"
+	"osi-replay/pkg/pcapio"
+)
+
+var base = time.Unix(1700000000, 0)
+
+// writeCapture writes one packet per offset; each packet's first byte
+// carries tag so the output order can be checked.
+func writeCapture(t *testing.T, path string, linkType layers.LinkType, tag byte, offsets ...time.Duration) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriterNanos(f)
+	if err := w.WriteFileHeader(65536, linkType); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	for i, off := range offsets {
+		data := make([]byte, 60)
+		data[0], data[1] = tag, byte(i)
+		ci := gopacket.CaptureInfo{Timestamp: base.Add(off), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("Error writing packet: %v", err)
+		}
+	}
+}
+
+func readTags(t *testing.T, path string) ([]byte, []time.Time) {
+	t.Helper()
+	f, err := pcapio.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening output: %v", err)
+	}
+	defer f.Close()
+	var tags []byte
+	var times []time.Time
+	for {
+		data, ci, err := f.ReadPacketData()
+		if err != nil {
+			break
+		}
+		tags = append(tags, data[0])
+		times = append(times, ci.Timestamp)
+	}
+	return tags, times
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.pcap"), filepath.Join(dir, "b.pcap")
+	writeCapture(t, a, layers.LinkTypeEthernet, 'a', 0, 2*time.Second, 4*time.Second)
+	writeCapture(t, b, layers.LinkTypeEthernet, 'b', time.Second, 3*time.Second)
+	logger := common.NewLogger("test")
+
+	tests := []struct {
+		name   string
+		inputs []merge.Input
+		opts   merge.Options
+		want   string
+	}{
+		{"interleave", []merge.Input{{Path: a}, {Path: b}}, merge.Options{}, "ababa"},
+		{"offset", []merge.Input{{Path: a}, {Path: b, Offset: 1500 * time.Millisecond}}, merge.Options{}, "aabab"},
+		{"tie keeps input order", []merge.Input{{Path: b, Offset: time.Second}, {Path: a}}, merge.Options{}, "ababa"},
+		{"concatenate", []merge.Input{{Path: b}, {Path: a}}, merge.Options{Concatenate: true}, "bbaaa"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := filepath.Join(dir, "out.pcap")
+			if err := merge.Merge(tc.inputs, out, &tc.opts, logger); err != nil {
+				t.Fatalf("Merge returned error: %v", err)
+			}
+			tags, _ := readTags(t, out)
+			if string(tags) != tc.want {
+				t.Errorf("Expected order %q, got %q", tc.want, string(tags))
+			}
+		})
+	}
+}
+
+func TestMerge_AppliesOffsetToTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.pcap")
+	writeCapture(t, a, layers.LinkTypeEthernet, 'a', 0)
+	out := filepath.Join(dir, "out.pcapng")
+	if err := merge.Merge([]merge.Input{{Path: a, Offset: -250 * time.Millisecond}}, out, &merge.Options{}, common.NewLogger("test")); err != nil {
+		t.Fatalf("Merge returned error: %v", err)
+	}
+	_, times := readTags(t, out)
+	if len(times) != 1 || !times[0].Equal(base.Add(-250*time.Millisecond)) {
+		t.Errorf("Unexpected timestamps %v", times)
+	}
+}
+
+func TestMerge_KeepsNanoseconds(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.pcap")
+	writeCapture(t, a, layers.LinkTypeEthernet, 'a', 1234567*time.Nanosecond)
+	out := filepath.Join(dir, "out.pcap")
+	if err := merge.Merge([]merge.Input{{Path: a}}, out, &merge.Options{}, common.NewLogger("test")); err != nil {
+		t.Fatalf("Merge returned error: %v", err)
+	}
+	_, times := readTags(t, out)
+	if len(times) != 1 || !times[0].Equal(base.Add(1234567*time.Nanosecond)) {
+		t.Errorf("Unexpected timestamps %v", times)
+	}
+}
+
+func TestMerge_LinkTypeMismatch(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.pcap"), filepath.Join(dir, "b.pcap")
+	writeCapture(t, a, layers.LinkTypeEthernet, 'a', 0)
+	writeCapture(t, b, layers.LinkTypeRaw, 'b', 0)
+	err := merge.Merge([]merge.Input{{Path: a}, {Path: b}}, filepath.Join(dir, "out.pcap"), &merge.Options{}, common.NewLogger("test"))
+	if err == nil {
+		t.Error("Expected error for mismatched link types")
+	}
+}
@@ -0,0 +1,181 @@
+// Package metrics keeps counters and gauges for long-running commands and
+// serves them over HTTP in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"osi-replay/pkg/common"
+)
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v atomic.Uint64
+}
+
+// Add increases the counter by n.
+func (c *Counter) Add(n uint64) { c.v.Add(n) }
+
+// Inc increases the counter by one.
+func (c *Counter) Inc() { c.v.Add(1) }
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Info is a gauge fixed at 1 whose single label carries a changing value,
+// such as the file currently being written.
+type Info struct {
+	mu    sync.Mutex
+	value string
+}
+
+// Set replaces the label value.
+func (i *Info) Set(value string) {
+	i.mu.Lock()
+	i.value = value
+	i.mu.Unlock()
+}
+
+// Value returns the label value.
+func (i *Info) Value() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.value
+}
+
+// metric is one registered series.
+type metric struct {
+	name, help, typ string
+	// label names the Info label; value reports everything else.
+	label string
+	info  *Info
+	value func() float64
+}
+
+// Registry holds the metrics of one process.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	names   map[string]bool
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) add(m *metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name] {
+		panic("metrics: duplicate metric " + m.name)
+	}
+	r.names[m.name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Counter registers and returns a new counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.add(&metric{name: name, help: help, typ: "counter", value: func() float64 { return float64(c.Value()) }})
+	return c
+}
+
+// CounterFunc registers a counter whose value f reports when scraped.
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+	r.add(&metric{name: name, help: help, typ: "counter", value: f})
+}
+
+// Gauge registers and returns a new gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.add(&metric{name: name, help: help, typ: "gauge", value: g.Value})
+	return g
+}
+
+// GaugeFunc registers a gauge whose value f reports when scraped.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.add(&metric{name: name, help: help, typ: "gauge", value: f})
+}
+
+// Info registers and returns a new info gauge labelled label.
+func (r *Registry) Info(name, help, label string) *Info {
+	i := &Info{}
+	r.add(&metric{name: name, help: help, typ: "gauge", label: label, info: i})
+	return i
+}
+
+// WriteText writes every metric to w in the Prometheus text format.
+// Info gauges without a value are left out.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		var sample string
+		if m.info != nil {
+			v := m.info.Value()
+			if v == "" {
+				continue
+			}
+			sample = fmt.Sprintf("%s{%s=\"%s\"} 1", m.name, m.label, escapeLabel(v))
+		} else {
+			sample = m.name + " " + strconv.FormatFloat(m.value(), 'g', -1, 64)
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s\n", m.name, m.help, m.name, m.typ, sample)
+	}
+	return bw.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// ServeHTTP implements http.Handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+// Serve listens on addr and serves r at /metrics until the returned
+// server is closed; its Addr is the address actually bound. Listening
+// errors are returned; later ones are logged.
+func Serve(addr string, r *Registry, logger *common.Logger) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting metrics listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(fmt.Errorf("metrics server: %w", err))
+		}
+	}()
+	logger.Info("Serving metrics", "url", "http://"+srv.Addr+"/metrics")
+	return srv, nil
+}
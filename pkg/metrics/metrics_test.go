@@ -0,0 +1,77 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"osi-replay/pkg/metrics"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := reg.Counter("test_packets_total", "Packets seen.")
+	g := reg.Gauge("test_lag_seconds", "Lag.")
+	info := reg.Info("test_file_info", "Current file.", "path")
+	reg.CounterFunc("test_drops_total", "Drops.", func() float64 { return 7 })
+
+	c.Add(3)
+	c.Inc()
+	g.Set(0.25)
+
+	var b strings.Builder
+	if err := reg.WriteText(&b); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# HELP test_packets_total Packets seen.\n# TYPE test_packets_total counter\ntest_packets_total 4\n",
+		"# TYPE test_lag_seconds gauge\ntest_lag_seconds 0.25\n",
+		"test_drops_total 7\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "test_file_info") {
+		t.Errorf("Unset info gauge should be left out:\n%s", out)
+	}
+
+	info.Set(`/data/"a".pcap`)
+	b.Reset()
+	reg.WriteText(&b)
+	if want := `test_file_info{path="/data/\"a\".pcap"} 1`; !strings.Contains(b.String(), want) {
+		t.Errorf("Output missing %q:\n%s", want, b.String())
+	}
+}
+
+func TestServe(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Counter("test_up_total", "Test.").Inc()
+	srv, err := metrics.Serve("127.0.0.1:0", reg, nil)
+	if err != nil {
+		t.Fatalf("Serve returned error: %v", err)
+	}
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + srv.Addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if !strings.Contains(string(body), "test_up_total 1\n") {
+		t.Errorf("Unexpected body:\n%s", body)
+	}
+
+	if _, err := metrics.Serve(srv.Addr, reg, nil); err == nil {
+		t.Errorf("Expected an error listening on a busy address")
+	}
+}
@@ -0,0 +1,188 @@
+package pcapio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"osi-replay/pkg/common"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// PacketWriter is implemented by all capture file writers in this package.
+type PacketWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// CommentWriter is a PacketWriter that can attach a comment to a packet.
+type CommentWriter interface {
+	PacketWriter
+	WritePacketComment(ci gopacket.CaptureInfo, data []byte, comment string) error
+}
+
+// IsPcapng reports whether path names a pcapng file by its extension.
+func IsPcapng(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pcapng")
+}
+
+// FormatOf returns the capture format for path by its extension.
+func FormatOf(path string) string {
+	if IsPcapng(path) {
+		return FormatPcapng
+	}
+	return FormatPcap
+}
+
+// NewWriter writes a file header to w and returns a writer for path's
+// format: pcapng when path ends in ".pcapng", classic pcap otherwise.
+func NewWriter(w io.Writer, path string, snaplen uint32, linkType layers.LinkType) (PacketWriter, error) {
+	return NewFormatWriter(w, FormatOf(path), snaplen, linkType)
+}
+
+// NewResolutionWriter is NewWriter with the timestamp resolution of a
+// classic pcap output set to res, e.g. an input's Resolution(). pcapng
+// outputs always carry nanoseconds.
+func NewResolutionWriter(w io.Writer, path string, snaplen uint32, linkType layers.LinkType, res gopacket.TimestampResolution) (PacketWriter, error) {
+	return newWriter(w, FormatOf(path), snaplen, linkType, res)
+}
+
+// NewFormatWriter writes a file header to w and returns a writer for
+// format, FormatPcap or FormatPcapng. An empty format means FormatPcap.
+func NewFormatWriter(w io.Writer, format string, snaplen uint32, linkType layers.LinkType) (PacketWriter, error) {
+	return newWriter(w, format, snaplen, linkType, gopacket.TimestampResolutionMicrosecond)
+}
+
+func newWriter(w io.Writer, format string, snaplen uint32, linkType layers.LinkType, res gopacket.TimestampResolution) (PacketWriter, error) {
+	switch format {
+	case FormatPcapng:
+		return NewNgWriter(w, snaplen, linkType)
+	case FormatPcap, "":
+		pw := pcapWriter(w, res)
+		if err := pw.WriteFileHeader(snaplen, linkType); err != nil {
+			return nil, fmt.Errorf("error writing pcap header: %w", err)
+		}
+		return pw, nil
+	default:
+		return nil, fmt.Errorf("unknown capture format %q (want %s or %s)", format, FormatPcap, FormatPcapng)
+	}
+}
+
+// WritePacket writes pkt to w, keeping its comment when w supports them.
+func WritePacket(w PacketWriter, pkt common.Packet) error {
+	if cw, ok := w.(CommentWriter); ok && pkt.Comment != "" {
+		return cw.WritePacketComment(pkt.CaptureInfo, pkt.Data, pkt.Comment)
+	}
+	return w.WritePacket(pkt.CaptureInfo, pkt.Data)
+}
+
+// AppendWriter returns a writer for path's format that writes packets to
+// w without a file header, for appending to a file NewWriter or
+// NewResolutionWriter created with resolution res.
+func AppendWriter(w io.Writer, path string, res gopacket.TimestampResolution) PacketWriter {
+	if IsPcapng(path) {
+		return &NgWriter{w: w}
+	}
+	return pcapWriter(w, res)
+}
+
+func pcapWriter(w io.Writer, res gopacket.TimestampResolution) *pcapgo.Writer {
+	if res == gopacket.TimestampResolutionNanosecond {
+		return pcapgo.NewWriterNanos(w)
+	}
+	return pcapgo.NewWriter(w)
+}
+
+// pcapng block types and option codes used by NgWriter.
+const (
+	ngBlockSectionHeader  = 0x0A0D0D0A
+	ngBlockInterface      = 0x00000001
+	ngBlockEnhancedPacket = 0x00000006
+	ngByteOrderMagic      = 0x1A2B3C4D
+	ngOptEnd              = 0
+	ngOptComment          = 1
+	ngOptIfTsResol        = 9
+)
+
+// NgWriter writes a single-interface pcapng file with nanosecond
+// timestamps and optional per-packet comments.
+type NgWriter struct {
+	w io.Writer
+}
+
+// NewNgWriter writes the section header and interface description to w.
+func NewNgWriter(w io.Writer, snaplen uint32, linkType layers.LinkType) (*NgWriter, error) {
+	nw := &NgWriter{w: w}
+
+	shb := make([]byte, 0, 16)
+	shb = binary.LittleEndian.AppendUint32(shb, ngByteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor version
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	if err := nw.writeBlock(ngBlockSectionHeader, shb); err != nil {
+		return nil, fmt.Errorf("error writing pcapng section header: %w", err)
+	}
+
+	idb := make([]byte, 0, 20)
+	idb = binary.LittleEndian.AppendUint16(idb, uint16(linkType))
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	idb = binary.LittleEndian.AppendUint32(idb, snaplen)
+	idb = appendOption(idb, ngOptIfTsResol, []byte{9})
+	idb = appendOption(idb, ngOptEnd, nil)
+	if err := nw.writeBlock(ngBlockInterface, idb); err != nil {
+		return nil, fmt.Errorf("error writing pcapng interface block: %w", err)
+	}
+	return nw, nil
+}
+
+// WritePacket writes data as an enhanced packet block.
+func (nw *NgWriter) WritePacket(ci gopacket.CaptureInfo, data []byte) error {
+	return nw.WritePacketComment(ci, data, "")
+}
+
+// WritePacketComment writes data with comment attached as opt_comment.
+func (nw *NgWriter) WritePacketComment(ci gopacket.CaptureInfo, data []byte, comment string) error {
+	if ci.CaptureLength != len(data) {
+		return fmt.Errorf("capture length %d does not match data length %d", ci.CaptureLength, len(data))
+	}
+	ts := uint64(ci.Timestamp.UnixNano())
+	body := make([]byte, 0, 20+len(data)+len(comment)+16)
+	body = binary.LittleEndian.AppendUint32(body, 0) // interface ID
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ci.CaptureLength))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ci.Length))
+	body = append(body, data...)
+	body = append(body, make([]byte, pad4(len(data)))...)
+	if comment != "" {
+		body = appendOption(body, ngOptComment, []byte(comment))
+		body = appendOption(body, ngOptEnd, nil)
+	}
+	return nw.writeBlock(ngBlockEnhancedPacket, body)
+}
+
+func (nw *NgWriter) writeBlock(blockType uint32, body []byte) error {
+	total := uint32(12 + len(body))
+	block := make([]byte, 0, total)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	block = append(block, body...)
+	block = binary.LittleEndian.AppendUint32(block, total)
+	_, err := nw.w.Write(block)
+	return err
+}
+
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, pad4(len(value)))...)
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
@@ -0,0 +1,207 @@
+package pcapio_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+)
+
+func TestNgWriter_ReadBack(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := pcapio.NewNgWriter(&buf, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatalf("NewNgWriter returned error: %v", err)
+	}
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	data := []byte{1, 2, 3, 4, 5}
+	ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)}
+	if err := w.WritePacketComment(ci, data, "vxlan vni=42"); err != nil {
+		t.Fatalf("WritePacketComment returned error: %v", err)
+	}
+	if err := w.WritePacket(ci, data); err != nil {
+		t.Fatalf("WritePacket returned error: %v", err)
+	}
+
+	r, err := pcapgo.NewNgReader(&buf, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatalf("NewNgReader returned error: %v", err)
+	}
+	if r.LinkType() != layers.LinkTypeEthernet {
+		t.Errorf("Expected Ethernet link type, got %v", r.LinkType())
+	}
+	for i := 0; i < 2; i++ {
+		got, gotCI, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatalf("Error reading packet %d: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Packet %d: expected data %v, got %v", i, data, got)
+		}
+		if !gotCI.Timestamp.Equal(ts) {
+			t.Errorf("Packet %d: expected timestamp %v, got %v", i, ts, gotCI.Timestamp)
+		}
+	}
+}
+
+func TestIsPcapng(t *testing.T) {
+	if !pcapio.IsPcapng("out.PCAPNG") || pcapio.IsPcapng("out.pcap") {
+		t.Errorf("IsPcapng misclassified file extensions")
+	}
+}
+
+func TestOpen_DetectsFormat(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte{1, 2, 3, 4, 5}
+	ci := gopacket.CaptureInfo{Timestamp: time.Unix(1700000000, 0), CaptureLength: len(data), Length: len(data)}
+
+	for _, name := range []string{"a.pcap", "a.pcapng"} {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Error creating %s: %v", name, err)
+		}
+		w, err := pcapio.NewWriter(f, path, 1500, layers.LinkTypeEthernet)
+		if err != nil {
+			t.Fatalf("NewWriter(%s) returned error: %v", name, err)
+		}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket(%s) returned error: %v", name, err)
+		}
+		f.Close()
+
+		file, err := pcapio.Open(path)
+		if err != nil {
+			t.Fatalf("Open(%s) returned error: %v", name, err)
+		}
+		wantFormat := pcapio.FormatPcap
+		if pcapio.IsPcapng(path) {
+			wantFormat = pcapio.FormatPcapng
+		}
+		wantRes := gopacket.TimestampResolutionMicrosecond
+		if wantFormat == pcapio.FormatPcapng {
+			wantRes = gopacket.TimestampResolutionNanosecond
+		}
+		if file.Resolution() != wantRes {
+			t.Errorf("%s: expected resolution %v, got %v", name, wantRes, file.Resolution())
+		}
+		if file.Format != wantFormat || file.Snaplen != 1500 || file.LinkType() != layers.LinkTypeEthernet {
+			t.Errorf("%s: unexpected format %s, snaplen %d, link type %v", name, file.Format, file.Snaplen, file.LinkType())
+		}
+		got, _, err := file.ReadPacketData()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: expected packet %v, got %v (err=%v)", name, data, got, err)
+		}
+		file.Close()
+	}
+}
+
+func TestNewReader_PacketsAndComments(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := pcapio.NewFormatWriter(&buf, pcapio.FormatPcapng, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatalf("NewFormatWriter returned error: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		data := []byte{byte(i), 0, 0, 0}
+		pkt := common.Packet{
+			Data:        data,
+			CaptureInfo: gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), CaptureLength: len(data), Length: len(data)},
+			Comment:     "note",
+		}
+		if err := pcapio.WritePacket(w, pkt); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+	}
+
+	file, err := pcapio.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader returned error: %v", err)
+	}
+	if file.Format != pcapio.FormatPcapng {
+		t.Errorf("Expected pcapng, got %s", file.Format)
+	}
+	var n int
+	for pkt, err := range pcapio.Packets(file) {
+		if err != nil {
+			t.Fatalf("Packets yielded error: %v", err)
+		}
+		n++
+		if pkt.Index != n || pkt.Data[0] != byte(n) {
+			t.Errorf("Packet %d: unexpected index %d, data %v", n, pkt.Index, pkt.Data)
+		}
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 packets, got %d", n)
+	}
+	if err := file.Close(); err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+}
+
+func TestPackets_TruncatedInput(t *testing.T) {
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("WriteFileHeader returned error: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		data := make([]byte, 60)
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+	}
+	plain := buf.Bytes()[:buf.Len()-10]
+	var gz bytes.Buffer
+	// Stored blocks keep the cut predictable: the 8-byte trailer and the
+	// same 10 bytes of packet data go missing.
+	zw, _ := gzip.NewWriterLevel(&gz, gzip.NoCompression)
+	zw.Write(buf.Bytes())
+	zw.Close()
+
+	for name, data := range map[string][]byte{"pcap": plain, "pcap.gz": gz.Bytes()[:gz.Len()-18]} {
+		file, err := pcapio.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: NewReader returned error: %v", name, err)
+		}
+		var n, errs int
+		for _, err := range pcapio.Packets(file) {
+			if err != nil {
+				errs++
+			} else {
+				n++
+			}
+			if errs > 1 {
+				t.Fatalf("%s: iteration did not end after the read error", name)
+			}
+		}
+		if n != 19 || errs != 1 {
+			t.Errorf("%s: expected 19 packets and 1 error, got %d and %d", name, n, errs)
+		}
+	}
+}
+
+func TestNewReader_FormatError(t *testing.T) {
+	_, err := pcapio.NewReader(strings.NewReader("not a capture"))
+	var fe *pcapio.FormatError
+	if !errors.As(err, &fe) {
+		t.Errorf("Expected *FormatError, got %v", err)
+	}
+	if _, err := pcapio.NewFormatWriter(io.Discard, "erf", 65536, layers.LinkTypeEthernet); err == nil {
+		t.Errorf("Expected error for unknown format")
+	}
+}
@@ -0,0 +1,144 @@
+package pcapio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+
+	"osi-replay/pkg/common"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// File formats reported by File.Format.
+const (
+	FormatPcap   = "pcap"
+	FormatPcapng = "pcapng"
+)
+
+// Reader is implemented by both the pcap and pcapng readers.
+type Reader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+	Resolution() gopacket.TimestampResolution
+}
+
+// File is an open capture file of either format.
+type File struct {
+	Reader
+	Format  string
+	Snaplen uint32
+	f       *os.File
+}
+
+// FormatError reports input that is not a readable pcap or pcapng capture.
+type FormatError struct {
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("not a pcap or pcapng capture: %v", e.Err)
+}
+
+func (e *FormatError) Unwrap() error { return e.Err }
+
+// Open opens a pcap (optionally gzipped) or pcapng file, detecting the
+// format from its first block.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	file.f = f
+	return file, nil
+}
+
+// NewReader reads a pcap (optionally gzipped) or pcapng capture from r,
+// detecting the format from its first block. Close on the result does not
+// close r. Unreadable input is reported as a *FormatError.
+func NewReader(r io.Reader) (*File, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, &FormatError{Err: fmt.Errorf("unable to read file header: %w", err)}
+	}
+
+	file := &File{}
+	if binary.LittleEndian.Uint32(magic) == ngBlockSectionHeader {
+		r, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, &FormatError{Err: err}
+		}
+		file.Reader = r
+		file.Format = FormatPcapng
+		if intf, err := r.Interface(0); err == nil {
+			file.Snaplen = intf.SnapLength
+		}
+		return file, nil
+	}
+
+	pr, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, &FormatError{Err: err}
+	}
+	file.Reader = pr
+	file.Format = FormatPcap
+	file.Snaplen = pr.Snaplen()
+	return file, nil
+}
+
+// Packets returns an iterator over the packets of r, numbered from 1.
+// Iteration ends at io.EOF, when the caller stops, or after a read error
+// is yielded with a zero Packet: a truncated or corrupt file cannot be
+// read past the failure, so retrying would fail the same way forever.
+func Packets(r Reader) iter.Seq2[common.Packet, error] {
+	return func(yield func(common.Packet, error) bool) {
+		var index int
+		for {
+			data, ci, err := r.ReadPacketData()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(common.Packet{}, fmt.Errorf("error reading packet: %w", err))
+				return
+			}
+			index++
+			if !yield(common.Packet{Data: data, CaptureInfo: ci, Index: index}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Resolution returns the timestamp resolution stored in the file.
+func (f *File) Resolution() gopacket.TimestampResolution {
+	res := f.Reader.Resolution()
+	if f.Format != FormatPcap {
+		return res
+	}
+	// pcapgo.Reader (gopacket v1.1.19) reports the two pcap resolutions
+	// the wrong way round.
+	if res == gopacket.TimestampResolutionNanosecond {
+		return gopacket.TimestampResolutionMicrosecond
+	}
+	return gopacket.TimestampResolutionNanosecond
+}
+
+// Close closes the underlying file, if Open opened one.
+func (f *File) Close() error {
+	if f.f == nil {
+		return nil
+	}
+	return f.f.Close()
+}
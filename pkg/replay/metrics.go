@@ -0,0 +1,114 @@
+package replay
+
+import (
+	"sync"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/metrics"
+)
+
+const (
+	// rateWindow is the span the achieved rate gauges average over.
+	rateWindow = time.Second
+	// rateSlot is the granularity sends are counted at within the window.
+	rateSlot = 100 * time.Millisecond
+)
+
+// Metrics are the replay metrics. A nil *Metrics records nothing.
+type Metrics struct {
+	Packets     *metrics.Counter
+	Bytes       *metrics.Counter
+	WriteErrors *metrics.Counter
+	Lag         *metrics.Gauge
+
+	rate rate
+}
+
+// NewMetrics registers the replay metrics with reg. The rate gauges are
+// computed when scraped, so they fall to zero once sending stops.
+func NewMetrics(reg *metrics.Registry) *Metrics {
+	m := &Metrics{
+		Packets:     reg.Counter("osi_replay_replay_packets_total", "Packets replayed."),
+		Bytes:       reg.Counter("osi_replay_replay_bytes_total", "Bytes replayed."),
+		WriteErrors: reg.Counter("osi_replay_replay_write_errors_total", "Packets that could not be sent."),
+		Lag:         reg.Gauge("osi_replay_replay_lag_seconds", "How far behind its paced schedule the last packet was sent."),
+	}
+	reg.GaugeFunc("osi_replay_replay_packets_per_second", "Packets sent per second over the last second.", func() float64 {
+		packets, _ := m.rate.perSecond(time.Now())
+		return packets
+	})
+	reg.GaugeFunc("osi_replay_replay_bytes_per_second", "Bytes sent per second over the last second.", func() float64 {
+		_, bytes := m.rate.perSecond(time.Now())
+		return bytes
+	})
+	return m
+}
+
+func (m *Metrics) sent(pkt common.Packet) {
+	if m == nil {
+		return
+	}
+	m.Packets.Inc()
+	m.Bytes.Add(uint64(len(pkt.Data)))
+	m.rate.add(time.Now(), len(pkt.Data))
+}
+
+func (m *Metrics) writeFailed() {
+	if m != nil {
+		m.WriteErrors.Inc()
+	}
+}
+
+func (m *Metrics) lagging(lag time.Duration) {
+	if m != nil {
+		m.Lag.Set(lag.Seconds())
+	}
+}
+
+// rate counts what was sent during the last rateWindow in rateSlot-sized
+// slots, oldest first.
+type rate struct {
+	mu    sync.Mutex
+	slots []rateCount
+}
+
+type rateCount struct {
+	start          time.Time
+	packets, bytes int
+}
+
+func (r *rate) add(now time.Time, bytes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := now.Truncate(rateSlot)
+	if n := len(r.slots); n == 0 || !r.slots[n-1].start.Equal(start) {
+		r.prune(now)
+		r.slots = append(r.slots, rateCount{start: start})
+	}
+	last := &r.slots[len(r.slots)-1]
+	last.packets++
+	last.bytes += bytes
+}
+
+// perSecond returns the packet and byte rates over the window ending now.
+func (r *rate) perSecond(now time.Time) (packets, bytes float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(now)
+	for _, s := range r.slots {
+		packets += float64(s.packets)
+		bytes += float64(s.bytes)
+	}
+	return packets / rateWindow.Seconds(), bytes / rateWindow.Seconds()
+}
+
+// prune drops slots that started before the window ending now.
+func (r *rate) prune(now time.Time) {
+	cutoff := now.Add(-rateWindow)
+	i := 0
+	for i < len(r.slots) && r.slots[i].start.Before(cutoff) {
+		i++
+	}
+	r.slots = append(r.slots[:0], r.slots[i:]...)
+}
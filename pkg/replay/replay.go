@@ -3,55 +3,243 @@ package replay
 import (
 	"fmt"
 	"io"
+	"iter"
 	"os"
+	"time"
 
 	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/pcapgo"
 
 	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/slice"
 )
 
+// Options controls optional behaviour of the replay functions.
+type Options struct {
+	// Stages run in order on every packet before it is injected, e.g. to
+	// encapsulate traffic for an overlay test bed.
+	Stages []common.Stage
+	// Select, if set, limits the replay to the packets it matches.
+	// Reading stops once no later packet can match.
+	Select *slice.Selector
+	// Format is the capture format Stream writes: pcapio.FormatPcap (the
+	// default) or pcapio.FormatPcapng.
+	Format string
+	// Speed, if positive, paces packets by their capture timestamps: 1
+	// keeps the original timing, 2 replays twice as fast. Zero sends as
+	// fast as possible.
+	Speed float64
+	// Metrics, if set, is updated as packets are sent.
+	Metrics *Metrics
+}
+
+// Source supplies packets to ReplaySource. Next returns io.EOF once
+// there are no more packets; any other error is logged and ends the
+// replay.
+type Source interface {
+	Next() (common.Packet, error)
+}
+
 // ReplayPackets reads from cfg.PcapFile and writes raw frames to cfg.InterfaceName.
 func ReplayPackets(cfg *common.CaptureConfig, logger *common.Logger) error {
+	return ReplayPacketsWithOptions(cfg, &Options{}, logger)
+}
+
+// ReplayPacketsWithOptions behaves like ReplayPackets and additionally honours opts.
+func ReplayPacketsWithOptions(cfg *common.CaptureConfig, opts *Options, logger *common.Logger) error {
 	f, err := os.Open(cfg.PcapFile)
 	if err != nil {
 		return fmt.Errorf("could not open pcap file %s: %w", cfg.PcapFile, err)
 	}
 	defer f.Close()
+	if err := ReplayReader(cfg, f, opts, logger); err != nil {
+		return fmt.Errorf("could not replay %s: %w", cfg.PcapFile, err)
+	}
+	return nil
+}
+
+// ReplayReader writes the packets of the pcap or pcapng capture read from
+// r to cfg.InterfaceName; cfg.PcapFile is not used. Malformed input is
+// reported as a *pcapio.FormatError.
+func ReplayReader(cfg *common.CaptureConfig, r io.Reader, opts *Options, logger *common.Logger) error {
+	reader, err := pcapio.NewReader(r)
+	if err != nil {
+		return err
+	}
+	return ReplaySeq(cfg, pcapio.Packets(reader), opts, logger)
+}
 
-	reader, err := pcapgo.NewReader(f)
+// ReplayToFile applies opts to the packets of inFile and writes the result
+// to outFile instead of an interface, e.g. to keep a fuzzed stream for
+// later replay. Packet comments are kept when outFile is pcapng.
+func ReplayToFile(inFile, outFile string, opts *Options, logger *common.Logger) error {
+	fIn, err := os.Open(inFile)
 	if err != nil {
-		return fmt.Errorf("could not create pcapgo reader: %w", err)
+		return fmt.Errorf("could not open pcap file %s: %w", inFile, err)
 	}
+	defer fIn.Close()
 
+	f, err := os.Create(outFile)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer f.Close()
+
+	o := *opts
+	o.Format = pcapio.FormatOf(outFile)
+	if _, err := Stream(fIn, f, &o, logger); err != nil {
+		return fmt.Errorf("could not replay %s: %w", inFile, err)
+	}
+	return nil
+}
+
+// Stream applies opts to the packets of the capture read from r and
+// writes the result to w in opts.Format, returning the number of packets
+// written. The output keeps the input's link type.
+func Stream(r io.Reader, w io.Writer, opts *Options, logger *common.Logger) (int, error) {
+	reader, err := pcapio.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := pcapio.NewFormatWriter(w, opts.Format, 65536, reader.LinkType())
+	if err != nil {
+		return 0, err
+	}
+	return run(pcapio.Packets(reader), opts, logger, func(pkt common.Packet) error {
+		return pcapio.WritePacket(writer, pkt)
+	}), nil
+}
+
+// ReplaySource writes the packets from src to cfg.InterfaceName;
+// cfg.PcapFile is not used.
+func ReplaySource(cfg *common.CaptureConfig, src Source, opts *Options, logger *common.Logger) error {
+	return ReplaySeq(cfg, sourcePackets(src), opts, logger)
+}
+
+// ReplaySeq writes the packets from seq to cfg.InterfaceName; cfg.PcapFile
+// is not used. An error yielded by seq is logged and ends the replay.
+// Failing to open the interface is reported as a *common.InterfaceError.
+func ReplaySeq(cfg *common.CaptureConfig, seq iter.Seq2[common.Packet, error], opts *Options, logger *common.Logger) error {
 	handle, err := pcap.OpenLive(cfg.InterfaceName, cfg.SnapLen, cfg.Promiscuous, cfg.Timeout)
 	if err != nil {
-		return fmt.Errorf("error opening interface %s: %w", cfg.InterfaceName, err)
+		return &common.InterfaceError{Interface: cfg.InterfaceName, Err: err}
 	}
 	defer handle.Close()
 
+	run(seq, opts, logger, func(pkt common.Packet) error {
+		return handle.WritePacketData(pkt.Data)
+	})
+	return nil
+}
+
+// ChanSource returns a Source reading packets from ch until it is closed.
+func ChanSource(ch <-chan common.Packet) Source {
+	return chanSource(ch)
+}
+
+type chanSource <-chan common.Packet
+
+func (c chanSource) Next() (common.Packet, error) {
+	pkt, ok := <-c
+	if !ok {
+		return common.Packet{}, io.EOF
+	}
+	return pkt, nil
+}
+
+// sourcePackets adapts src to an iterator that ends after the first
+// error.
+func sourcePackets(src Source) iter.Seq2[common.Packet, error] {
+	return func(yield func(common.Packet, error) bool) {
+		for {
+			pkt, err := src.Next()
+			if err == io.EOF || !yield(pkt, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run passes the packets of seq through opts, hands the result to write
+// and returns how many packets were written.
+func run(seq iter.Seq2[common.Packet, error], opts *Options, logger *common.Logger, write func(common.Packet) error) int {
 	var count int
-	for {
-		data, _, err := reader.ReadPacketData()
-		if err == io.EOF {
-			break
+	pace := &pacer{speed: opts.Speed}
+	dropped := func(st common.Stage, pkt common.Packet) {
+		logger.Debug("Stage dropped packet", "stage", st.Name(), "index", pkt.Index)
+	}
+	send := func(pkts []common.Packet) {
+		for _, pkt := range pkts {
+			if err := write(pkt); err != nil {
+				opts.Metrics.writeFailed()
+				logger.Error(fmt.Errorf("error writing packet: %w", err))
+				continue
+			}
+			opts.Metrics.sent(pkt)
+
+			count++
+			if count%1000 == 0 {
+				logger.Info("Replay progress", "packets", count)
+			}
 		}
+	}
+
+	for pkt, err := range seq {
 		if err != nil {
-			logger.Error(fmt.Errorf("error reading packet: %w", err))
-			continue
+			logger.Error(err)
+			break
 		}
 
-		if err := handle.WritePacketData(data); err != nil {
-			logger.Error(fmt.Errorf("error writing packet: %w", err))
-			continue
+		sel := opts.Select
+		ts := pkt.CaptureInfo.Timestamp
+		if sel == nil || sel.Match(pkt.Index, ts) {
+			// Pace before the stages so ones that record send times,
+			// such as verify's, see when the packet actually goes out.
+			opts.Metrics.lagging(pace.wait(ts))
+			out, err := common.RunStages(opts.Stages, []common.Packet{pkt}, dropped)
+			if err != nil {
+				logger.Error(err)
+			} else {
+				send(out)
+			}
 		}
-
-		count++
-		if count % 1000 == 0 {
-			logger.Info(fmt.Sprintf("Replayed %d packets so far...", count))
+		if sel != nil && sel.Done(pkt.Index, ts) {
+			break
 		}
 	}
 
-	logger.Info(fmt.Sprintf("Replay complete. Total packets replayed: %d", count))
-	return nil
+	out, err := common.FlushStages(opts.Stages, dropped)
+	if err != nil {
+		logger.Error(err)
+	}
+	send(out)
+
+	logger.Info("Replay complete", "packets", count)
+	return count
+}
+
+// pacer holds packets back until their capture time, scaled by speed,
+// has elapsed since the first one was sent.
+type pacer struct {
+	speed float64
+	first time.Time
+	start time.Time
+}
+
+// wait sleeps until the packet captured at ts is due and returns how far
+// behind schedule it is.
+func (p *pacer) wait(ts time.Time) time.Duration {
+	if p.speed <= 0 {
+		return 0
+	}
+	if p.start.IsZero() {
+		p.first, p.start = ts, time.Now()
+		return 0
+	}
+	due := p.start.Add(time.Duration(float64(ts.Sub(p.first)) / p.speed))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+		return 0
+	}
+	return time.Since(due)
 }
@@ -1,12 +1,23 @@
 package replay_test
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 
 	"osi-replay/pkg/common"
+	"osi-replay/pkg/metrics"
+	"osi-replay/pkg/pcapio"
 	"osi-replay/pkg/replay"
+	"osi-replay/pkg/slice"
 )
 
 // Test missing PCAP file
@@ -48,3 +59,124 @@ func TestReplayPackets_Basic(t *testing.T) {
 		t.Errorf("Unexpected error replaying pcap: %v", err)
 	}
 }
+
+func TestStream_SelectAndStages(t *testing.T) {
+	var in bytes.Buffer
+	w, err := pcapio.NewFormatWriter(&in, pcapio.FormatPcap, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatalf("NewFormatWriter returned error: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		data := []byte{byte(i), 0, 0, 0}
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(int64(i), 0), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+	}
+
+	sel, err := slice.NewSelector("2-3", "", "", 0)
+	if err != nil {
+		t.Fatalf("NewSelector returned error: %v", err)
+	}
+	var out bytes.Buffer
+	opts := &replay.Options{Select: sel, Format: pcapio.FormatPcapng}
+	n, err := replay.Stream(&in, &out, opts, nil)
+	if err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 packets written, got %d", n)
+	}
+
+	file, err := pcapio.NewReader(&out)
+	if err != nil {
+		t.Fatalf("Output is not a capture: %v", err)
+	}
+	var got []byte
+	for pkt, err := range pcapio.Packets(file) {
+		if err != nil {
+			t.Fatalf("Error reading output: %v", err)
+		}
+		got = append(got, pkt.Data[0])
+	}
+	if !bytes.Equal(got, []byte{2, 3}) {
+		t.Errorf("Expected packets 2 and 3, got %v", got)
+	}
+}
+
+func TestReplaySource_InterfaceError(t *testing.T) {
+	ch := make(chan common.Packet)
+	close(ch)
+	cfg := &common.CaptureConfig{InterfaceName: "does-not-exist", SnapLen: 65535}
+	err := replay.ReplaySource(cfg, replay.ChanSource(ch), &replay.Options{}, nil)
+	var ie *common.InterfaceError
+	if !errors.As(err, &ie) || ie.Interface != "does-not-exist" {
+		t.Errorf("Expected *common.InterfaceError, got %v", err)
+	}
+}
+
+func TestStream_SpeedAndMetrics(t *testing.T) {
+	var in bytes.Buffer
+	w, err := pcapio.NewFormatWriter(&in, pcapio.FormatPcap, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatalf("NewFormatWriter returned error: %v", err)
+	}
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		data := []byte{byte(i), 0, 0, 0}
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(i) * 100 * time.Millisecond), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+	}
+
+	reg := metrics.NewRegistry()
+	m := replay.NewMetrics(reg)
+	stamps := &stampStage{}
+	opts := &replay.Options{Speed: 2, Metrics: m, Stages: []common.Stage{stamps}}
+	if _, err := replay.Stream(&in, io.Discard, opts, nil); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	// 200ms of capture time at twice the speed, paced before the stages
+	// see each packet.
+	if len(stamps.at) != 3 {
+		t.Fatalf("Expected the stage to see 3 packets, got %d", len(stamps.at))
+	}
+	if gap := stamps.at[2].Sub(stamps.at[0]); gap < 100*time.Millisecond {
+		t.Errorf("Expected the stage to see packets at least 100ms apart, got %v", gap)
+	}
+	if m.Packets.Value() != 3 || m.Bytes.Value() != 12 || m.WriteErrors.Value() != 0 {
+		t.Errorf("Unexpected metrics: %d packets, %d bytes, %d errors",
+			m.Packets.Value(), m.Bytes.Value(), m.WriteErrors.Value())
+	}
+
+	scrape := func() string {
+		var sb strings.Builder
+		if err := reg.WriteText(&sb); err != nil {
+			t.Fatalf("WriteText returned error: %v", err)
+		}
+		return sb.String()
+	}
+	if out := scrape(); !strings.Contains(out, "osi_replay_replay_packets_per_second 3\n") {
+		t.Errorf("Expected a rate of 3 packets per second, got:\n%s", out)
+	}
+	// Once sending stops the rate falls to zero instead of holding.
+	time.Sleep(1100 * time.Millisecond)
+	if out := scrape(); !strings.Contains(out, "osi_replay_replay_packets_per_second 0\n") {
+		t.Errorf("Expected the rate to fall to 0 after the replay, got:\n%s", out)
+	}
+}
+
+// stampStage records when each packet reaches it.
+type stampStage struct {
+	at []time.Time
+}
+
+func (s *stampStage) Name() string { return "stamp" }
+
+func (s *stampStage) Process(pkt common.Packet) ([]common.Packet, error) {
+	s.at = append(s.at, time.Now())
+	return []common.Packet{pkt}, nil
+}
+
+func (s *stampStage) Flush() ([]common.Packet, error) { return nil, nil }
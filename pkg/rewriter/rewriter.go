@@ -7,10 +7,10 @@ import (
 	"os"
 
 	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcapgo"
 )
 
 type RewriteConfig struct {
@@ -20,6 +20,13 @@ type RewriteConfig struct {
 	MACMapDst map[string]string
 }
 
+// Options controls optional behaviour of Stream.
+type Options struct {
+	// Format is the capture format Stream writes: pcapio.FormatPcap (the
+	// default) or pcapio.FormatPcapng.
+	Format string
+}
+
 func Run(cfg *RewriteConfig, inFile, outFile string, logger *common.Logger) error {
 	fIn, err := os.Open(inFile)
 	if err != nil {
@@ -27,48 +34,76 @@ func Run(cfg *RewriteConfig, inFile, outFile string, logger *common.Logger) erro
 	}
 	defer fIn.Close()
 
-	reader, err := pcapgo.NewReader(fIn)
-	if err != nil {
-		return fmt.Errorf("error creating pcapgo reader: %w", err)
-	}
-
 	fOut, err := os.Create(outFile)
 	if err != nil {
 		return fmt.Errorf("error creating output pcap file %s: %w", outFile, err)
 	}
 	defer fOut.Close()
 
-	writer := pcapgo.NewWriter(fOut)
-	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
-		return fmt.Errorf("error writing pcap header: %w", err)
+	if _, err := Stream(fIn, fOut, cfg, &Options{Format: pcapio.FormatOf(outFile)}, logger); err != nil {
+		return fmt.Errorf("error reading %s: %w", inFile, err)
 	}
+	return nil
+}
 
-	var count int
-	for {
-		data, ci, err := reader.ReadPacketData()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			logger.Error(fmt.Errorf("error reading packet data: %w", err))
-			continue
-		}
+// Stream rewrites the pcap or pcapng capture read from r and writes it to
+// w in opts.Format, returning the number of packets written. Malformed
+// input is reported as a *pcapio.FormatError; packets that fail to
+// rewrite are logged and skipped.
+func Stream(r io.Reader, w io.Writer, cfg *RewriteConfig, opts *Options, logger *common.Logger) (int, error) {
+	reader, err := pcapio.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := pcapio.NewFormatWriter(w, opts.Format, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		return 0, err
+	}
 
-		newData, err := RewritePacket(data, cfg)
+	var count int
+	for pkt, err := range common.Apply(pcapio.Packets(reader), []common.Stage{New(cfg)}, nil) {
 		if err != nil {
-			logger.Error(fmt.Errorf("rewrite error: %w", err))
+			logger.Error(err)
 			continue
 		}
-		if err := writer.WritePacket(ci, newData); err != nil {
+		if err := pcapio.WritePacket(writer, pkt); err != nil {
 			logger.Error(fmt.Errorf("error writing rewritten packet: %w", err))
 			continue
 		}
 		count++
 	}
-	logger.Info(fmt.Sprintf("Rewrite complete. %d packets processed.", count))
-	return nil
+	logger.Info("Rewrite complete", "packets", count)
+	return count, nil
+}
+
+// Rewriter is a pipeline stage that applies a RewriteConfig to each frame.
+type Rewriter struct {
+	cfg *RewriteConfig
 }
 
+// New returns a rewriting stage for cfg.
+func New(cfg *RewriteConfig) *Rewriter {
+	return &Rewriter{cfg: cfg}
+}
+
+// Name implements common.Stage.
+func (r *Rewriter) Name() string { return "rewrite" }
+
+// Process implements common.Stage.
+func (r *Rewriter) Process(pkt common.Packet) ([]common.Packet, error) {
+	data, err := RewritePacket(pkt.Data, r.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite error: %w", err)
+	}
+	pkt.Data = data
+	pkt.CaptureInfo.CaptureLength = len(data)
+	pkt.CaptureInfo.Length = len(data)
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage; the Rewriter holds no packets.
+func (r *Rewriter) Flush() ([]common.Packet, error) { return nil, nil }
+
 func RewritePacket(data []byte, cfg *RewriteConfig) ([]byte, error) {
 	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
 	if packet.ErrorLayer() != nil {
@@ -152,6 +187,7 @@ func serializePacket(packet gopacket.Packet) ([]byte, error) {
 	}
 
 	var (
+		vlanTags   []gopacket.SerializableLayer
 		arpLayer   *layers.ARP
 		ipv4Layer  *layers.IPv4
 		ipv6Layer  *layers.IPv6
@@ -161,6 +197,11 @@ func serializePacket(packet gopacket.Packet) ([]byte, error) {
 		icmp6Layer *layers.ICMPv6
 	)
 
+	for _, l := range packet.Layers() {
+		if tag, ok := l.(*layers.Dot1Q); ok {
+			vlanTags = append(vlanTags, tag)
+		}
+	}
 	if l := packet.Layer(layers.LayerTypeARP); l != nil {
 		arpLayer = l.(*layers.ARP)
 	}
@@ -185,6 +226,7 @@ func serializePacket(packet gopacket.Packet) ([]byte, error) {
 
 	var layersToSerialize []gopacket.SerializableLayer
 	layersToSerialize = append(layersToSerialize, eth)
+	layersToSerialize = append(layersToSerialize, vlanTags...)
 
 	if arpLayer != nil {
 		layersToSerialize = append(layersToSerialize, arpLayer)
@@ -194,6 +236,24 @@ func serializePacket(packet gopacket.Packet) ([]byte, error) {
 		layersToSerialize = append(layersToSerialize, ipv6Layer)
 	}
 
+	var netLayer gopacket.NetworkLayer
+	if ipv4Layer != nil {
+		netLayer = ipv4Layer
+	} else if ipv6Layer != nil {
+		netLayer = ipv6Layer
+	}
+	if netLayer != nil {
+		if tcpLayer != nil {
+			tcpLayer.SetNetworkLayerForChecksum(netLayer)
+		}
+		if udpLayer != nil {
+			udpLayer.SetNetworkLayerForChecksum(netLayer)
+		}
+		if icmp6Layer != nil {
+			icmp6Layer.SetNetworkLayerForChecksum(netLayer)
+		}
+	}
+
 	switch {
 	case tcpLayer != nil:
 		layersToSerialize = append(layersToSerialize, tcpLayer)
@@ -205,6 +265,11 @@ func serializePacket(packet gopacket.Packet) ([]byte, error) {
 		layersToSerialize = append(layersToSerialize, icmp6Layer)
 	}
 
+	// Carry whatever sits above the last layer we rebuilt as raw bytes.
+	if last, ok := layersToSerialize[len(layersToSerialize)-1].(gopacket.Layer); ok && len(last.LayerPayload()) > 0 {
+		layersToSerialize = append(layersToSerialize, gopacket.Payload(last.LayerPayload()))
+	}
+
 	if err := gopacket.SerializeLayers(buf, opts, layersToSerialize...); err != nil {
 		return nil, fmt.Errorf("serialize error: %w", err)
 	}
@@ -1,12 +1,15 @@
 package rewriter_test
 
 import (
+	"bytes"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 
+	"osi-replay/pkg/pcapio"
 	"osi-replay/pkg/rewriter"
 )
 
@@ -79,3 +82,122 @@ func TestRewritePacket_Basic(t *testing.T) {
 		t.Errorf("Expected IP dst 10.0.0.10, got %s", ip4Out.DstIP)
 	}
 }
+
+func TestRewritePacket_PreservesVLANTags(t *testing.T) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeQinQ,
+	}
+	outer := &layers.Dot1Q{VLANIdentifier: 200, Type: layers.EthernetTypeDot1Q}
+	inner := &layers.Dot1Q{VLANIdentifier: 100, Priority: 5, Type: layers.EthernetTypeIPv4}
+	ip4 := &layers.IPv4{
+		SrcIP:    net.ParseIP("192.168.1.100"),
+		DstIP:    net.ParseIP("192.168.1.200"),
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+	}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 9999}
+	udp.SetNetworkLayerForChecksum(ip4)
+	payload := gopacket.Payload("hello")
+
+	if err := gopacket.SerializeLayers(buf, opts, eth, outer, inner, ip4, udp, payload); err != nil {
+		t.Fatalf("Error serializing layers: %v", err)
+	}
+
+	cfg := &rewriter.RewriteConfig{
+		IPMapSrc: map[string]string{"192.168.1.100": "10.0.0.5"},
+	}
+	newData, err := rewriter.RewritePacket(buf.Bytes(), cfg)
+	if err != nil {
+		t.Fatalf("RewritePacket returned error: %v", err)
+	}
+
+	newPacket := gopacket.NewPacket(newData, layers.LayerTypeEthernet, gopacket.Default)
+	var tags []*layers.Dot1Q
+	for _, l := range newPacket.Layers() {
+		if tag, ok := l.(*layers.Dot1Q); ok {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) != 2 {
+		t.Fatalf("Expected 2 VLAN tags after rewrite, got %d", len(tags))
+	}
+	if tags[0].VLANIdentifier != 200 || tags[1].VLANIdentifier != 100 || tags[1].Priority != 5 {
+		t.Errorf("VLAN tags not preserved: outer=%d inner=%d/%d",
+			tags[0].VLANIdentifier, tags[1].VLANIdentifier, tags[1].Priority)
+	}
+
+	ip4Out, _ := newPacket.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if ip4Out == nil || ip4Out.SrcIP.String() != "10.0.0.5" {
+		t.Errorf("Expected IP src 10.0.0.5 in rewritten packet")
+	}
+	if app := newPacket.ApplicationLayer(); app == nil || string(app.Payload()) != "hello" {
+		t.Errorf("Expected UDP payload to be preserved")
+	}
+}
+
+func TestStream_SkipsUndecodable(t *testing.T) {
+	buf := gopacket.NewSerializeBuffer()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{
+		SrcIP:    net.ParseIP("192.168.1.100"),
+		DstIP:    net.ParseIP("192.168.1.200"),
+		Version:  4,
+		IHL:      5,
+		Protocol: layers.IPProtocolTCP,
+	}
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip4); err != nil {
+		t.Fatalf("Error serializing layers: %v", err)
+	}
+	good := buf.Bytes()
+	truncated := good[:17]
+
+	var in bytes.Buffer
+	w, err := pcapio.NewFormatWriter(&in, pcapio.FormatPcap, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatalf("NewFormatWriter returned error: %v", err)
+	}
+	for _, data := range [][]byte{good, truncated} {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1700000000, 0), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("WritePacket returned error: %v", err)
+		}
+	}
+
+	cfg := &rewriter.RewriteConfig{IPMapSrc: map[string]string{"192.168.1.100": "10.0.0.5"}}
+	var out bytes.Buffer
+	n, err := rewriter.Stream(&in, &out, cfg, &rewriter.Options{}, nil)
+	if err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 rewritten packet, got %d", n)
+	}
+
+	file, err := pcapio.NewReader(&out)
+	if err != nil {
+		t.Fatalf("Output is not a capture: %v", err)
+	}
+	data, _, err := file.ReadPacketData()
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	ip := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default).Layer(layers.LayerTypeIPv4)
+	if ip == nil || ip.(*layers.IPv4).SrcIP.String() != "10.0.0.5" {
+		t.Errorf("Expected rewritten source 10.0.0.5, got %v", ip)
+	}
+}
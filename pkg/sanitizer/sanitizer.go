@@ -3,6 +3,14 @@ package sanitizer
 import (
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+)
+
+// Rule names reported in a Decision.
+const (
+	RuleDefault   = "default"
+	RuleBlockedIP = "blocked-ip"
 )
 
 // Example simple map for blocked IP addresses
@@ -10,15 +18,52 @@ var blockedIPs = map[string]bool{
 	"10.0.0.1": true,
 }
 
-// SanitizePacket returns (nil, false) if the packet should be dropped,
-// or (packet, true) otherwise. You can extend logic for more filtering.
-func SanitizePacket(packet gopacket.Packet) (gopacket.Packet, bool) {
+// Decision records whether a packet is kept and which rule decided it.
+type Decision struct {
+	Keep bool
+	Rule string
+}
+
+// Evaluate runs the sanitizer rules against packet and reports the first
+// rule that matched. Packets matching no rule are kept under RuleDefault.
+func Evaluate(packet gopacket.Packet) Decision {
 	if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
 		ip4, _ := ipLayer.(*layers.IPv4)
 		if blockedIPs[ip4.SrcIP.String()] || blockedIPs[ip4.DstIP.String()] {
-			// drop the packet
-			return nil, false
+			return Decision{Keep: false, Rule: RuleBlockedIP}
 		}
 	}
+	return Decision{Keep: true, Rule: RuleDefault}
+}
+
+// SanitizePacket returns (nil, false) if the packet should be dropped,
+// or (packet, true) otherwise. You can extend logic for more filtering.
+func SanitizePacket(packet gopacket.Packet) (gopacket.Packet, bool) {
+	if d := Evaluate(packet); !d.Keep {
+		// drop the packet
+		return nil, false
+	}
 	return packet, true
 }
+
+// Filter is a pipeline stage that drops the Ethernet frames Evaluate
+// rejects, and frames that fail to decode.
+type Filter struct{}
+
+// NewFilter returns a sanitizing stage.
+func NewFilter() *Filter { return &Filter{} }
+
+// Name implements common.Stage.
+func (f *Filter) Name() string { return "sanitize" }
+
+// Process implements common.Stage.
+func (f *Filter) Process(pkt common.Packet) ([]common.Packet, error) {
+	packet := gopacket.NewPacket(pkt.Data, layers.LayerTypeEthernet, gopacket.Default)
+	if packet.ErrorLayer() != nil || !Evaluate(packet).Keep {
+		return nil, nil
+	}
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage; the Filter holds no packets.
+func (f *Filter) Flush() ([]common.Packet, error) { return nil, nil }
@@ -61,3 +61,31 @@ func TestSanitizePacket_AllowedIP(t *testing.T) {
 		t.Errorf("Expected packet to pass sanitizer, but was dropped")
 	}
 }
+
+func TestEvaluate_ReportsRule(t *testing.T) {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	ip := &layers.IPv4{
+		Version: 4,
+		IHL:     5,
+		SrcIP:   net.ParseIP("192.168.1.10"),
+		DstIP:   net.ParseIP("10.0.0.1"),
+	}
+
+	if err := gopacket.SerializeLayers(buf, opts, ip); err != nil {
+		t.Fatalf("Error serializing IP packet: %v", err)
+	}
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	d := sanitizer.Evaluate(packet)
+	if d.Keep {
+		t.Errorf("Expected packet destined to blocked IP 10.0.0.1 to be dropped")
+	}
+	if d.Rule != sanitizer.RuleBlockedIP {
+		t.Errorf("Expected rule %q, got %q", sanitizer.RuleBlockedIP, d.Rule)
+	}
+}
@@ -0,0 +1,229 @@
+package slice
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+)
+
+// Range is an inclusive range of 1-based packet indexes. End 0 means the
+// range is open-ended.
+type Range struct {
+	Start, End int
+}
+
+// ParseRanges parses a list such as "1-100,250,1000-".
+func ParseRanges(s string) ([]Range, error) {
+	var out []Range
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("invalid packet range %q", item)
+		}
+		r := Range{Start: start, End: start}
+		if isRange {
+			r.End = 0
+			if hi != "" {
+				if r.End, err = strconv.Atoi(hi); err != nil || r.End < start {
+					return nil, fmt.Errorf("invalid packet range %q", item)
+				}
+			}
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// TimeBound is a point in capture time, either absolute or relative to the
+// first packet.
+type TimeBound struct {
+	Time     time.Time
+	Offset   time.Duration
+	Relative bool
+}
+
+// ParseTimeBound accepts an RFC 3339 timestamp or a duration relative to
+// the first packet, such as "90s" or "+1m30s".
+func ParseTimeBound(s string) (TimeBound, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return TimeBound{Time: t}, nil
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(s, "+"))
+	if err != nil {
+		return TimeBound{}, fmt.Errorf("invalid time %q: want RFC 3339 or a duration", s)
+	}
+	return TimeBound{Offset: d, Relative: true}, nil
+}
+
+func (b *TimeBound) resolve(first time.Time) time.Time {
+	if b.Relative {
+		return first.Add(b.Offset)
+	}
+	return b.Time
+}
+
+// Selector picks packets by index, time window and sampling. All set
+// criteria must match. A Selector keeps state and is used for one pass.
+type Selector struct {
+	// Ranges, if any, restrict packets to these indexes.
+	Ranges []Range
+	// From and To, if set, bound timestamps to [From, To).
+	From, To *TimeBound
+	// Every, if above 1, keeps packets 1, Every+1, 2*Every+1, ...
+	Every int
+
+	started  bool
+	from, to time.Time
+}
+
+// NewSelector builds a Selector from command-line style values; empty
+// values leave a criterion unset. It returns nil when nothing is set.
+func NewSelector(ranges, from, to string, every int) (*Selector, error) {
+	if ranges == "" && from == "" && to == "" && every <= 1 {
+		return nil, nil
+	}
+	sel := &Selector{Every: every}
+	var err error
+	if sel.Ranges, err = ParseRanges(ranges); err != nil {
+		return nil, err
+	}
+	for _, b := range []struct {
+		s   string
+		dst **TimeBound
+	}{{from, &sel.From}, {to, &sel.To}} {
+		if b.s == "" {
+			continue
+		}
+		tb, err := ParseTimeBound(b.s)
+		if err != nil {
+			return nil, err
+		}
+		*b.dst = &tb
+	}
+	return sel, nil
+}
+
+// Match reports whether the packet at 1-based index, captured at ts, is
+// selected. It must be called for every packet, in order.
+func (s *Selector) Match(index int, ts time.Time) bool {
+	if !s.started {
+		s.started = true
+		if s.From != nil {
+			s.from = s.From.resolve(ts)
+		}
+		if s.To != nil {
+			s.to = s.To.resolve(ts)
+		}
+	}
+	if s.Every > 1 && (index-1)%s.Every != 0 {
+		return false
+	}
+	if s.From != nil && ts.Before(s.from) {
+		return false
+	}
+	if s.To != nil && !ts.Before(s.to) {
+		return false
+	}
+	if len(s.Ranges) == 0 {
+		return true
+	}
+	for _, r := range s.Ranges {
+		if index >= r.Start && (r.End == 0 || index <= r.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// Done reports whether no packet after index can match, so a reader can
+// stop early. The time bound assumes timestamps are non-decreasing.
+func (s *Selector) Done(index int, ts time.Time) bool {
+	if s.started && s.To != nil && !ts.Before(s.to) {
+		return true
+	}
+	if len(s.Ranges) == 0 {
+		return false
+	}
+	for _, r := range s.Ranges {
+		if r.End == 0 || index < r.End {
+			return false
+		}
+	}
+	return true
+}
+
+// Name implements common.Stage.
+func (s *Selector) Name() string { return "slice" }
+
+// Process implements common.Stage, dropping packets Match rejects.
+func (s *Selector) Process(pkt common.Packet) ([]common.Packet, error) {
+	if !s.Match(pkt.Index, pkt.CaptureInfo.Timestamp) {
+		return nil, nil
+	}
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage; the Selector holds no packets.
+func (s *Selector) Flush() ([]common.Packet, error) { return nil, nil }
+
+// Run streams the packets of inFile that sel selects into outFile and
+// returns how many were written. Reading stops once nothing more can
+// match.
+func Run(inFile, outFile string, sel *Selector, logger *common.Logger) (int, error) {
+	reader, err := pcapio.Open(inFile)
+	if err != nil {
+		return 0, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer reader.Close()
+
+	fOut, err := os.Create(outFile)
+	if err != nil {
+		return 0, fmt.Errorf("error creating output file: %w", err)
+	}
+	defer fOut.Close()
+
+	snaplen := reader.Snaplen
+	if snaplen == 0 {
+		snaplen = 65536
+	}
+	writer, err := pcapio.NewResolutionWriter(fOut, outFile, snaplen, reader.LinkType(), reader.Resolution())
+	if err != nil {
+		return 0, err
+	}
+
+	var index, kept int
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error(fmt.Errorf("error reading packet data: %w", err))
+			break
+		}
+		index++
+		if sel.Match(index, ci.Timestamp) {
+			if err := writer.WritePacket(ci, data); err != nil {
+				return kept, fmt.Errorf("error writing packet: %w", err)
+			}
+			kept++
+		}
+		if sel.Done(index, ci.Timestamp) {
+			break
+		}
+	}
+
+	logger.Info("Slice complete", "selected", kept, "read", index)
+	return kept, nil
+}
@@ -0,0 +1,174 @@
+package slice_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/slice"
+)
+
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestParseRanges(t *testing.T) {
+	got, err := slice.ParseRanges("1-3, 7,10-")
+	if err != nil {
+		t.Fatalf("ParseRanges returned error: %v", err)
+	}
+	want := []slice.Range{{1, 3}, {7, 7}, {10, 0}}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Range %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+	for _, bad := range []string{"0", "5-2", "a-b", "-4"} {
+		if _, err := slice.ParseRanges(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestSelector(t *testing.T) {
+	abs := base.Add(3 * time.Second).Format(time.RFC3339)
+	tests := []struct {
+		name             string
+		ranges, from, to string
+		every            int
+		want             []int
+	}{
+		{"ranges", "2-3,8-", "", "", 0, []int{2, 3, 8, 9, 10}},
+		{"relative window", "", "2s", "+5s", 0, []int{3, 4, 5}},
+		{"absolute from", "", abs, "", 0, []int{4, 5, 6, 7, 8, 9, 10}},
+		{"every", "", "", "", 4, []int{1, 5, 9}},
+		{"combined", "1-6", "1s", "", 2, []int{3, 5}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sel, err := slice.NewSelector(tc.ranges, tc.from, tc.to, tc.every)
+			if err != nil {
+				t.Fatalf("NewSelector returned error: %v", err)
+			}
+			var got []int
+			for i := 1; i <= 10; i++ {
+				ts := base.Add(time.Duration(i-1) * time.Second)
+				if sel.Match(i, ts) {
+					got = append(got, i)
+				}
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("Expected %v, got %v", tc.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSelector_Empty(t *testing.T) {
+	sel, err := slice.NewSelector("", "", "", 0)
+	if err != nil || sel != nil {
+		t.Errorf("Expected nil selector, got %v, %v", sel, err)
+	}
+	if _, err := slice.NewSelector("", "yesterday", "", 0); err == nil {
+		t.Error("Expected error for invalid time")
+	}
+}
+
+// writeCaptureRes writes n two-byte raw-IP packets spaced step apart,
+// with nanosecond timestamps when nanos is set.
+func writeCaptureRes(t *testing.T, path string, nanos bool, n int, step time.Duration) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if nanos {
+		w = pcapgo.NewWriterNanos(f)
+	}
+	if err := w.WriteFileHeader(65536, layers.LinkTypeRaw); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		data := []byte{0x45, byte(i)}
+		ci := gopacket.CaptureInfo{Timestamp: base.Add(time.Duration(i) * step), CaptureLength: 2, Length: 2}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatalf("Error writing packet: %v", err)
+		}
+	}
+}
+
+func TestRun_StopsEarly(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.pcap"), filepath.Join(dir, "out.pcapng")
+	writeCaptureRes(t, in, false, 10, time.Second)
+
+	sel := &slice.Selector{Ranges: []slice.Range{{Start: 2, End: 4}}}
+	n, err := slice.Run(in, out, sel, common.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("Expected 3 packets, got %d", n)
+	}
+	if !sel.Done(4, base) {
+		t.Error("Expected selector to be done after its last range")
+	}
+
+	r, err := pcapio.Open(out)
+	if err != nil {
+		t.Fatalf("Error opening output: %v", err)
+	}
+	defer r.Close()
+	if r.Format != pcapio.FormatPcapng || r.LinkType() != layers.LinkTypeRaw {
+		t.Errorf("Expected raw-IP pcapng output, got %s/%s", r.Format, r.LinkType())
+	}
+	data, _, err := r.ReadPacketData()
+	if err != nil || data[1] != 1 {
+		t.Errorf("Expected packet 2 first, got %v, %v", data, err)
+	}
+}
+
+func TestRun_KeepsNanoseconds(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.pcap"), filepath.Join(dir, "out.pcap")
+	step := time.Second + 1001*time.Nanosecond
+	writeCaptureRes(t, in, true, 4, step)
+
+	sel := &slice.Selector{Ranges: []slice.Range{{Start: 2, End: 3}}}
+	if _, err := slice.Run(in, out, sel, common.NewLogger("test")); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+
+	r, err := pcapio.Open(out)
+	if err != nil {
+		t.Fatalf("Error opening output: %v", err)
+	}
+	defer r.Close()
+	if r.Resolution() != gopacket.TimestampResolutionNanosecond {
+		t.Errorf("Expected nanosecond resolution, got %v", r.Resolution())
+	}
+	for i := 1; i <= 2; i++ {
+		_, ci, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatalf("Error reading packet %d: %v", i+1, err)
+		}
+		if want := base.Add(time.Duration(i) * step); !ci.Timestamp.Equal(want) {
+			t.Errorf("Expected timestamp %v, got %v", want, ci.Timestamp)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package split
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/flow"
+	"osi-replay/pkg/pcapio"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Split modes.
+const (
+	ModeCount = "count"
+	ModeSize  = "size"
+	ModeTime  = "time"
+	ModeFlow  = "flow"
+	ModeHost  = "host"
+)
+
+// DefaultMaxOpen bounds the output files held open in flow and host mode.
+const DefaultMaxOpen = 64
+
+// Options controls Split. Only the field matching Mode is used.
+type Options struct {
+	Mode     string
+	Count    int           // packets per file
+	Size     int64         // bytes per file
+	Interval time.Duration // capture time per file
+	// MaxOpen bounds open files in flow and host mode; the least recently
+	// used file is closed and later reopened for append.
+	MaxOpen int
+}
+
+func (o *Options) validate() error {
+	switch o.Mode {
+	case ModeCount:
+		if o.Count <= 0 {
+			return fmt.Errorf("packet count must be positive")
+		}
+	case ModeSize:
+		if o.Size <= 0 {
+			return fmt.Errorf("file size must be positive")
+		}
+	case ModeTime:
+		if o.Interval <= 0 {
+			return fmt.Errorf("time interval must be positive")
+		}
+	case ModeFlow, ModeHost:
+	default:
+		return fmt.Errorf("unknown split mode %q", o.Mode)
+	}
+	return nil
+}
+
+// Split streams inFile into files in outDir, named after the input file.
+// Outputs keep the input's format, snaplen, link type and timestamp
+// resolution. It returns the paths written, in the order they were created.
+func Split(inFile, outDir string, opts *Options, logger *common.Logger) (created []string, err error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	reader, err := pcapio.Open(inFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer reader.Close()
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %w", err)
+	}
+
+	ext := filepath.Ext(inFile)
+	if ext == "" {
+		ext = ".pcap"
+	}
+	if reader.Format == pcapio.FormatPcapng {
+		ext = ".pcapng"
+	}
+	prefix := filepath.Join(outDir, strings.TrimSuffix(filepath.Base(inFile), filepath.Ext(inFile)))
+
+	snaplen := reader.Snaplen
+	if snaplen == 0 {
+		snaplen = 65536
+	}
+	maxOpen := opts.MaxOpen
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpen
+	}
+	if opts.Mode != ModeFlow && opts.Mode != ModeHost {
+		maxOpen = 1
+	}
+	pool := newPool(maxOpen, snaplen, reader.LinkType(), reader.Resolution())
+	defer func() {
+		if cerr := pool.closeAll(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var (
+		count    int
+		chunk    int
+		inChunk  int
+		chunkEnd time.Time
+	)
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error(fmt.Errorf("error reading packet data: %w", err))
+			break
+		}
+		count++
+
+		var names []string
+		switch opts.Mode {
+		case ModeCount:
+			if chunk == 0 || inChunk >= opts.Count {
+				chunk, inChunk = chunk+1, 0
+			}
+			inChunk++
+			names = []string{fmt.Sprintf("%s-%05d%s", prefix, chunk, ext)}
+		case ModeSize:
+			cur := fmt.Sprintf("%s-%05d%s", prefix, chunk, ext)
+			if chunk == 0 || (pool.written(cur) > 0 && pool.written(cur)+int64(len(data))+32 > opts.Size) {
+				chunk++
+				cur = fmt.Sprintf("%s-%05d%s", prefix, chunk, ext)
+			}
+			names = []string{cur}
+		case ModeTime:
+			if chunk == 0 {
+				chunkEnd = ci.Timestamp
+			}
+			// Windows are aligned to the first packet; empty ones are skipped.
+			if !ci.Timestamp.Before(chunkEnd) {
+				chunk++
+				chunkEnd = chunkEnd.Add(ci.Timestamp.Sub(chunkEnd).Truncate(opts.Interval) + opts.Interval)
+			}
+			names = []string{fmt.Sprintf("%s-%05d%s", prefix, chunk, ext)}
+		case ModeFlow, ModeHost:
+			packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+			for _, label := range labels(packet, opts.Mode) {
+				names = append(names, fmt.Sprintf("%s-%s%s", prefix, label, ext))
+			}
+		}
+
+		for _, name := range names {
+			w, err := pool.get(name)
+			if err != nil {
+				return pool.created, err
+			}
+			if err := w.WritePacket(ci, data); err != nil {
+				return pool.created, fmt.Errorf("error writing packet to %s: %w", name, err)
+			}
+		}
+	}
+
+	if err := pool.closeAll(); err != nil {
+		return pool.created, err
+	}
+	logger.Info("Split complete", "packets", count, "files", len(pool.created))
+	return pool.created, nil
+}
+
+// labels names the files a packet belongs to: its bidirectional flow, or
+// each of its endpoint hosts. Non-IP packets go to "other".
+func labels(packet gopacket.Packet, mode string) []string {
+	k, ok := flow.KeyOf(packet)
+	if !ok {
+		return []string{"other"}
+	}
+	if mode == ModeHost {
+		if k.SrcIP == k.DstIP {
+			return []string{addrLabel(k.SrcIP)}
+		}
+		return []string{addrLabel(k.SrcIP), addrLabel(k.DstIP)}
+	}
+	// Order endpoints so both directions share a file.
+	if c := k.SrcIP.Compare(k.DstIP); c > 0 || (c == 0 && k.SrcPort > k.DstPort) {
+		k = k.Reverse()
+	}
+	proto := strings.ToLower(layers.IPProtocol(k.Proto).String())
+	return []string{fmt.Sprintf("%s-%s_%d-%s_%d", proto, addrLabel(k.SrcIP), k.SrcPort, addrLabel(k.DstIP), k.DstPort)}
+}
+
+// addrLabel formats addr for use in a file name.
+func addrLabel(addr netip.Addr) string {
+	return strings.ReplaceAll(addr.String(), ":", ".")
+}
+
+// countingWriter tracks the bytes written to a file.
+type countingWriter struct {
+	f *os.File
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.f.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type openFile struct {
+	name   string
+	out    *countingWriter
+	writer pcapio.PacketWriter
+}
+
+// pool keeps at most max output files open, closing the least recently
+// used one when another is needed.
+type pool struct {
+	max      int
+	snaplen  uint32
+	linkType layers.LinkType
+	res      gopacket.TimestampResolution
+	lru      *list.List
+	open     map[string]*list.Element
+	sizes    map[string]int64
+	created  []string
+}
+
+func newPool(max int, snaplen uint32, linkType layers.LinkType, res gopacket.TimestampResolution) *pool {
+	return &pool{
+		max:      max,
+		snaplen:  snaplen,
+		linkType: linkType,
+		res:      res,
+		lru:      list.New(),
+		open:     make(map[string]*list.Element),
+		sizes:    make(map[string]int64),
+	}
+}
+
+// written returns the bytes written to name so far.
+func (p *pool) written(name string) int64 {
+	if e, ok := p.open[name]; ok {
+		return e.Value.(*openFile).out.n
+	}
+	return p.sizes[name]
+}
+
+// get returns a writer for name, creating the file with a header the
+// first time and reopening it for append after eviction.
+func (p *pool) get(name string) (pcapio.PacketWriter, error) {
+	if e, ok := p.open[name]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*openFile).writer, nil
+	}
+	if p.lru.Len() >= p.max {
+		if err := p.evict(p.lru.Back()); err != nil {
+			return nil, err
+		}
+	}
+
+	of := &openFile{name: name}
+	if size, seen := p.sizes[name]; seen {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, fmt.Errorf("error reopening output file: %w", err)
+		}
+		of.out = &countingWriter{f: f, n: size}
+		of.writer = pcapio.AppendWriter(of.out, name, p.res)
+	} else {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("error creating output file: %w", err)
+		}
+		of.out = &countingWriter{f: f}
+		w, err := pcapio.NewResolutionWriter(of.out, name, p.snaplen, p.linkType, p.res)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		of.writer = w
+		p.created = append(p.created, name)
+	}
+	p.open[name] = p.lru.PushFront(of)
+	return of.writer, nil
+}
+
+func (p *pool) evict(e *list.Element) error {
+	of := p.lru.Remove(e).(*openFile)
+	delete(p.open, of.name)
+	p.sizes[of.name] = of.out.n
+	if err := of.out.f.Close(); err != nil {
+		return fmt.Errorf("error closing output file: %w", err)
+	}
+	return nil
+}
+
+// closeAll closes every open file and returns the first error. It is
+// safe to call again once the files are closed.
+func (p *pool) closeAll() error {
+	var firstErr error
+	for p.lru.Len() > 0 {
+		if err := p.evict(p.lru.Back()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,184 @@
+package split_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/split"
+)
+
+type pkt struct {
+	src, dst     string
+	sport, dport uint16
+	at           time.Duration
+}
+
+func writeCapture(t *testing.T, path string, pkts []pkt) {
+	t.Helper()
+	writeCaptureRes(t, path, false, pkts)
+}
+
+// writeCaptureRes writes pkts with nanosecond timestamps when nanos is set.
+func writeCaptureRes(t *testing.T, path string, nanos bool, pkts []pkt) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if nanos {
+		w = pcapgo.NewWriterNanos(f)
+	}
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	for i, p := range pkts {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+			SrcIP: net.ParseIP(p.src), DstIP: net.ParseIP(p.dst)}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(p.sport), DstPort: layers.UDPPort(p.dport)}
+		udp.SetNetworkLayerForChecksum(ip4)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload(make([]byte, 100))); err != nil {
+			t.Fatalf("Error serializing packet %d: %v", i, err)
+		}
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1700000000, 0).Add(p.at), CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatalf("Error writing packet %d: %v", i, err)
+		}
+	}
+}
+
+func countPackets(t *testing.T, path string) int {
+	t.Helper()
+	f, err := pcapio.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening %s: %v", path, err)
+	}
+	defer f.Close()
+	if f.LinkType() != layers.LinkTypeEthernet {
+		t.Errorf("%s: expected Ethernet link type, got %s", path, f.LinkType())
+	}
+	var n int
+	for {
+		if _, _, err := f.ReadPacketData(); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pcap")
+	writeCapture(t, in, []pkt{
+		{"10.0.0.1", "10.0.0.2", 1000, 53, 0},
+		{"10.0.0.2", "10.0.0.1", 53, 1000, 100 * time.Millisecond},
+		{"10.0.0.3", "10.0.0.2", 2000, 53, 200 * time.Millisecond},
+		{"10.0.0.1", "10.0.0.2", 1000, 53, 2500 * time.Millisecond},
+		{"10.0.0.3", "10.0.0.4", 3000, 80, 2600 * time.Millisecond},
+	})
+
+	tests := []struct {
+		name string
+		opts split.Options
+		want map[string]int
+	}{
+		{"count", split.Options{Mode: split.ModeCount, Count: 2},
+			map[string]int{"in-00001.pcap": 2, "in-00002.pcap": 2, "in-00003.pcap": 1}},
+		// Each packet is 142 bytes plus a 16-byte record header.
+		{"size", split.Options{Mode: split.ModeSize, Size: 400},
+			map[string]int{"in-00001.pcap": 2, "in-00002.pcap": 2, "in-00003.pcap": 1}},
+		{"time", split.Options{Mode: split.ModeTime, Interval: time.Second},
+			map[string]int{"in-00001.pcap": 3, "in-00002.pcap": 2}},
+		{"flow with eviction", split.Options{Mode: split.ModeFlow, MaxOpen: 1},
+			map[string]int{
+				"in-udp-10.0.0.1_1000-10.0.0.2_53.pcap": 3,
+				"in-udp-10.0.0.2_53-10.0.0.3_2000.pcap": 1,
+				"in-udp-10.0.0.3_3000-10.0.0.4_80.pcap": 1,
+			}},
+		{"host", split.Options{Mode: split.ModeHost},
+			map[string]int{"in-10.0.0.1.pcap": 3, "in-10.0.0.2.pcap": 4, "in-10.0.0.3.pcap": 2, "in-10.0.0.4.pcap": 1}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := filepath.Join(dir, tc.name)
+			files, err := split.Split(in, out, &tc.opts, common.NewLogger("test"))
+			if err != nil {
+				t.Fatalf("Split returned error: %v", err)
+			}
+			if len(files) != len(tc.want) {
+				t.Errorf("Expected %d files, got %v", len(tc.want), files)
+			}
+			for name, n := range tc.want {
+				if got := countPackets(t, filepath.Join(out, name)); got != n {
+					t.Errorf("%s: expected %d packets, got %d", name, n, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSplit_KeepsNanoseconds(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pcap")
+	// Alternating flows with MaxOpen 1 make every write after the first
+	// two reopen a file for append.
+	pkts := []pkt{
+		{"10.0.0.1", "10.0.0.2", 1000, 53, 1},
+		{"10.0.0.3", "10.0.0.4", 1000, 53, 1001},
+		{"10.0.0.1", "10.0.0.2", 1000, 53, 2003},
+		{"10.0.0.3", "10.0.0.4", 1000, 53, 3007},
+	}
+	writeCaptureRes(t, in, true, pkts)
+
+	files, err := split.Split(in, filepath.Join(dir, "out"), &split.Options{Mode: split.ModeFlow, MaxOpen: 1}, common.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("Split returned error: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %v", files)
+	}
+	for i, name := range files {
+		f, err := pcapio.Open(name)
+		if err != nil {
+			t.Fatalf("Error opening %s: %v", name, err)
+		}
+		if f.Resolution() != gopacket.TimestampResolutionNanosecond {
+			t.Errorf("%s: expected nanosecond resolution, got %v", name, f.Resolution())
+		}
+		for j := i; j < len(pkts); j += 2 {
+			_, ci, err := f.ReadPacketData()
+			if err != nil {
+				t.Fatalf("%s: error reading packet: %v", name, err)
+			}
+			if want := time.Unix(1700000000, 0).Add(pkts[j].at); !ci.Timestamp.Equal(want) {
+				t.Errorf("%s: expected timestamp %v, got %v", name, want, ci.Timestamp)
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestSplit_InvalidOptions(t *testing.T) {
+	for _, opts := range []split.Options{{Mode: split.ModeCount}, {Mode: "bogus"}} {
+		if _, err := split.Split("unused.pcap", t.TempDir(), &opts, common.NewLogger("test")); err == nil {
+			t.Errorf("Expected error for options %+v", opts)
+		}
+	}
+}
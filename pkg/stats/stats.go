@@ -0,0 +1,197 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+
+	"osi-replay/pkg/pcapio"
+)
+
+// DefaultTop is the default number of entries kept in each top-N list.
+const DefaultTop = 10
+
+// ProtocolNode is one layer stack in the protocol hierarchy, e.g.
+// "Ethernet:IPv4:TCP", with the frames and bytes that contain it.
+type ProtocolNode struct {
+	Path   string `json:"path"`
+	Depth  int    `json:"depth"`
+	Frames int    `json:"frames"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// Counter is a packet and byte total for one key (address, port or
+// conversation).
+type Counter struct {
+	Key     string `json:"key"`
+	Packets int    `json:"packets"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// Report holds the statistics computed for a capture.
+type Report struct {
+	File             string         `json:"file"`
+	Packets          int            `json:"packets"`
+	Bytes            int64          `json:"bytes"`
+	Hierarchy        []ProtocolNode `json:"protocol_hierarchy"`
+	TopSources       []Counter      `json:"top_sources"`
+	TopDestinations  []Counter      `json:"top_destinations"`
+	TopPorts         []Counter      `json:"top_ports"`
+	TopConversations []Counter      `json:"top_conversations"`
+}
+
+type tally map[string]*Counter
+
+func (t tally) add(key string, size int) {
+	c, ok := t[key]
+	if !ok {
+		c = &Counter{Key: key}
+		t[key] = c
+	}
+	c.Packets++
+	c.Bytes += int64(size)
+}
+
+// top returns the n counters with the most bytes, ties broken by key.
+func (t tally) top(n int) []Counter {
+	out := make([]Counter, 0, len(t))
+	for _, c := range t {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Key < out[j].Key
+	})
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Compute decodes every packet of the capture at path and returns its
+// protocol hierarchy and top-N lists. top <= 0 uses DefaultTop.
+func Compute(path string, top int) (*Report, error) {
+	if top <= 0 {
+		top = DefaultTop
+	}
+	reader, err := pcapio.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer reader.Close()
+
+	r := &Report{File: path}
+	hierarchy := make(map[string]*ProtocolNode)
+	sources, dests, ports, convs := tally{}, tally{}, tally{}, tally{}
+
+	for {
+		data, _, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading packet %d: %w", r.Packets+1, err)
+		}
+		size := len(data)
+		r.Packets++
+		r.Bytes += int64(size)
+
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		var path []string
+		for _, l := range packet.Layers() {
+			path = append(path, l.LayerType().String())
+			key := strings.Join(path, ":")
+			node, ok := hierarchy[key]
+			if !ok {
+				node = &ProtocolNode{Path: key, Depth: len(path) - 1}
+				hierarchy[key] = node
+			}
+			node.Frames++
+			node.Bytes += int64(size)
+		}
+
+		nl := packet.NetworkLayer()
+		if nl == nil {
+			continue
+		}
+		src, dst := nl.NetworkFlow().Endpoints()
+		sources.add(src.String(), size)
+		dests.add(dst.String(), size)
+
+		srcKey, dstKey := src.String(), dst.String()
+		proto := ""
+		if tl := packet.TransportLayer(); tl != nil {
+			proto = strings.ToLower(tl.LayerType().String())
+			sp, dp := tl.TransportFlow().Endpoints()
+			ports.add(proto+"/"+sp.String(), size)
+			if dp != sp {
+				ports.add(proto+"/"+dp.String(), size)
+			}
+			srcKey = joinHostPort(srcKey, sp.String())
+			dstKey = joinHostPort(dstKey, dp.String())
+		}
+		// Conversations are bidirectional: order the endpoints.
+		if dstKey < srcKey {
+			srcKey, dstKey = dstKey, srcKey
+		}
+		conv := srcKey + " <-> " + dstKey
+		if proto != "" {
+			conv = proto + " " + conv
+		}
+		convs.add(conv, size)
+	}
+
+	for _, node := range hierarchy {
+		r.Hierarchy = append(r.Hierarchy, *node)
+	}
+	sort.Slice(r.Hierarchy, func(i, j int) bool { return r.Hierarchy[i].Path < r.Hierarchy[j].Path })
+	r.TopSources = sources.top(top)
+	r.TopDestinations = dests.top(top)
+	r.TopPorts = ports.top(top)
+	r.TopConversations = convs.top(top)
+	return r, nil
+}
+
+func joinHostPort(host, port string) string {
+	if _, err := strconv.Atoi(port); err != nil {
+		return host + ":" + port
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// WriteText writes r to w as human-readable tables.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "File: %s (%d packets, %d bytes)\n\n", r.File, r.Packets, r.Bytes)
+
+	fmt.Fprintln(&b, "Protocol hierarchy:")
+	for _, n := range r.Hierarchy {
+		name := n.Path[strings.LastIndex(n.Path, ":")+1:]
+		fmt.Fprintf(&b, "  %-40s frames:%-8d bytes:%d\n", strings.Repeat("  ", n.Depth)+name, n.Frames, n.Bytes)
+	}
+
+	sections := []struct {
+		title string
+		list  []Counter
+	}{
+		{"Top sources", r.TopSources},
+		{"Top destinations", r.TopDestinations},
+		{"Top ports", r.TopPorts},
+		{"Top conversations", r.TopConversations},
+	}
+	for _, s := range sections {
+		fmt.Fprintf(&b, "\n%s:\n", s.title)
+		for _, c := range s.list {
+			fmt.Fprintf(&b, "  %-60s packets:%-8d bytes:%d\n", c.Key, c.Packets, c.Bytes)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
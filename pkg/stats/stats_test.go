@@ -0,0 +1,110 @@
+package stats_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/stats"
+)
+
+type flow struct {
+	src, dst     string
+	sport, dport uint16
+	payload      int
+}
+
+func writeFlows(t *testing.T, path string, flows []flow) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+	for i, fl := range flows {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP,
+			SrcIP: net.ParseIP(fl.src), DstIP: net.ParseIP(fl.dst)}
+		udp := &layers.UDP{SrcPort: layers.UDPPort(fl.sport), DstPort: layers.UDPPort(fl.dport)}
+		udp.SetNetworkLayerForChecksum(ip4)
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload(make([]byte, fl.payload))); err != nil {
+			t.Fatalf("Error serializing packet %d: %v", i, err)
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(1700000000+int64(i), 0),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatalf("Error writing packet %d: %v", i, err)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.pcap")
+	writeFlows(t, path, []flow{
+		{"10.0.0.1", "10.0.0.2", 5000, 9000, 500},
+		{"10.0.0.2", "10.0.0.1", 9000, 5000, 500},
+		{"10.0.0.3", "10.0.0.2", 6000, 9000, 100},
+	})
+
+	r, err := stats.Compute(path, 2)
+	if err != nil {
+		t.Fatalf("Compute returned error: %v", err)
+	}
+	if r.Packets != 3 {
+		t.Errorf("Expected 3 packets, got %d", r.Packets)
+	}
+
+	var udpNode *stats.ProtocolNode
+	for i, n := range r.Hierarchy {
+		if n.Path == "Ethernet:IPv4:UDP" {
+			udpNode = &r.Hierarchy[i]
+		}
+	}
+	if udpNode == nil || udpNode.Frames != 3 || udpNode.Depth != 2 || udpNode.Bytes != r.Bytes {
+		t.Errorf("Unexpected UDP hierarchy node: %+v", udpNode)
+	}
+
+	if len(r.TopConversations) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(r.TopConversations))
+	}
+	top := r.TopConversations[0]
+	if top.Key != "udp 10.0.0.1:5000 <-> 10.0.0.2:9000" || top.Packets != 2 {
+		t.Errorf("Unexpected top conversation: %+v", top)
+	}
+	if r.TopDestinations[0].Key != "10.0.0.2" || r.TopDestinations[0].Packets != 2 {
+		t.Errorf("Unexpected top destination: %+v", r.TopDestinations[0])
+	}
+	if len(r.TopPorts) != 2 || r.TopPorts[0].Key != "udp/9000" {
+		t.Errorf("Unexpected top ports: %+v", r.TopPorts)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText returned error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Top conversations:") {
+		t.Errorf("Unexpected text output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,259 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+)
+
+// Conversation is one bidirectional TCP connection. The client is the
+// side whose packet was seen first, normally the one sending the SYN.
+type Conversation struct {
+	ID          int       `json:"id"`
+	ClientIP    string    `json:"client_ip"`
+	ClientPort  uint16    `json:"client_port"`
+	ServerIP    string    `json:"server_ip"`
+	ServerPort  uint16    `json:"server_port"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	ClientBytes int64     `json:"client_bytes"`
+	ServerBytes int64     `json:"server_bytes"`
+	// Gaps counts places where missing segments left holes in the data.
+	Gaps int `json:"gaps"`
+
+	open int
+}
+
+// Sink receives reassembled payload for each conversation.
+type Sink interface {
+	// Data is called with payload sent by the client (fromClient) or the
+	// server, in stream order. data is only valid during the call.
+	Data(c *Conversation, fromClient bool, data []byte) error
+	// Close is called once both directions of c are complete.
+	Close(c *Conversation) error
+}
+
+// Limits that keep Reassemble's memory and open files bounded on long
+// captures. Times are capture time, not wall time.
+const (
+	// idleTimeout is how long a conversation may go without packets
+	// before it is closed and handed to the sink.
+	idleTimeout = 2 * time.Minute
+	// flushInterval is how often idle conversations are looked for.
+	flushInterval = 30 * time.Second
+	// Out-of-order data is buffered in pages of about 1900 bytes; past
+	// these limits the assembler skips ahead and records a gap.
+	maxPagesPerConnection = 1000
+	maxPagesTotal         = 100000
+)
+
+// Reassemble reads the capture at inFile, reconstructs every TCP
+// conversation, and streams its payload to sink. Conversations idle for
+// two minutes of capture time are closed early, so the sink never holds
+// more than the live ones. It returns the conversations in the order
+// they were first seen.
+func Reassemble(inFile string, sink Sink, logger *common.Logger) ([]*Conversation, error) {
+	reader, err := pcapio.Open(inFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	factory := &factory{sink: sink, logger: logger, active: make(map[[2]gopacket.Flow]*Conversation)}
+	assembler := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(factory))
+	assembler.MaxBufferedPagesPerConnection = maxPagesPerConnection
+	assembler.MaxBufferedPagesTotal = maxPagesTotal
+
+	var lastFlush time.Time
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Reads after a failed one would fail the same way.
+			logger.Error(fmt.Errorf("error reading packet data: %w", err))
+			break
+		}
+
+		if lastFlush.IsZero() {
+			lastFlush = ci.Timestamp
+		} else if ci.Timestamp.Sub(lastFlush) >= flushInterval {
+			assembler.FlushOlderThan(ci.Timestamp.Add(-idleTimeout))
+			lastFlush = ci.Timestamp
+		}
+
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		tcp, _ := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if tcp == nil || packet.NetworkLayer() == nil {
+			continue
+		}
+		assembler.AssembleWithTimestamp(packet.NetworkLayer().NetworkFlow(), tcp, ci.Timestamp)
+	}
+	assembler.FlushAll()
+
+	logger.Info("Reassembled TCP conversations", "conversations", len(factory.conversations))
+	return factory.conversations, nil
+}
+
+// factory pairs the two directions of each connection into a Conversation.
+type factory struct {
+	sink          Sink
+	logger        *common.Logger
+	active        map[[2]gopacket.Flow]*Conversation
+	conversations []*Conversation
+}
+
+func (f *factory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	reverse := [2]gopacket.Flow{netFlow.Reverse(), tcpFlow.Reverse()}
+	if c, ok := f.active[reverse]; ok {
+		delete(f.active, reverse)
+		c.open++
+		return &halfStream{f: f, c: c, fromClient: false}
+	}
+
+	src, dst := tcpFlow.Endpoints()
+	c := &Conversation{
+		ID:         len(f.conversations) + 1,
+		ClientIP:   netFlow.Src().String(),
+		ClientPort: portOf(src),
+		ServerIP:   netFlow.Dst().String(),
+		ServerPort: portOf(dst),
+		open:       1,
+	}
+	f.conversations = append(f.conversations, c)
+	f.active[[2]gopacket.Flow{netFlow, tcpFlow}] = c
+	return &halfStream{f: f, c: c, fromClient: true}
+}
+
+// halfStream is one direction of a Conversation.
+type halfStream struct {
+	f          *factory
+	c          *Conversation
+	fromClient bool
+}
+
+func (s *halfStream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		if s.c.Start.IsZero() || r.Seen.Before(s.c.Start) {
+			s.c.Start = r.Seen
+		}
+		if r.Seen.After(s.c.End) {
+			s.c.End = r.Seen
+		}
+		if r.Skip > 0 {
+			s.c.Gaps++
+		}
+		if len(r.Bytes) == 0 {
+			continue
+		}
+		if s.fromClient {
+			s.c.ClientBytes += int64(len(r.Bytes))
+		} else {
+			s.c.ServerBytes += int64(len(r.Bytes))
+		}
+		if err := s.f.sink.Data(s.c, s.fromClient, r.Bytes); err != nil {
+			s.f.logger.Error(fmt.Errorf("stream %d: %w", s.c.ID, err))
+		}
+	}
+}
+
+func (s *halfStream) ReassemblyComplete() {
+	s.c.open--
+	if s.c.open > 0 {
+		return
+	}
+	// A server that never answered leaves the client's entry waiting.
+	for k, c := range s.f.active {
+		if c == s.c {
+			delete(s.f.active, k)
+		}
+	}
+	if err := s.f.sink.Close(s.c); err != nil {
+		s.f.logger.Error(fmt.Errorf("stream %d: %w", s.c.ID, err))
+	}
+}
+
+func portOf(e gopacket.Endpoint) uint16 {
+	raw := e.Raw()
+	if len(raw) != 2 {
+		return 0
+	}
+	return uint16(raw[0])<<8 | uint16(raw[1])
+}
+
+// FileSink writes each conversation's client and server payload to
+// separate files in Dir, named stream-NNNN-client.bin and
+// stream-NNNN-server.bin.
+type FileSink struct {
+	Dir   string
+	files map[string]*os.File
+}
+
+// NewFileSink creates dir if needed and returns a FileSink writing to it.
+func NewFileSink(dir string) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create output directory %s: %w", dir, err)
+	}
+	return &FileSink{Dir: dir, files: make(map[string]*os.File)}, nil
+}
+
+// Data implements Sink.
+func (s *FileSink) Data(c *Conversation, fromClient bool, data []byte) error {
+	name := s.FileName(c, fromClient)
+	f, ok := s.files[name]
+	if !ok {
+		var err error
+		f, err = os.Create(filepath.Join(s.Dir, name))
+		if err != nil {
+			return fmt.Errorf("unable to create stream file: %w", err)
+		}
+		s.files[name] = f
+	}
+	_, err := f.Write(data)
+	return err
+}
+
+// Close implements Sink.
+func (s *FileSink) Close(c *Conversation) error {
+	var firstErr error
+	for _, fromClient := range []bool{true, false} {
+		name := s.FileName(c, fromClient)
+		if f, ok := s.files[name]; ok {
+			if err := f.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			delete(s.files, name)
+		}
+	}
+	return firstErr
+}
+
+// FileName returns the file name used for one direction of c.
+func (s *FileSink) FileName(c *Conversation, fromClient bool) string {
+	side := "server"
+	if fromClient {
+		side = "client"
+	}
+	return fmt.Sprintf("stream-%04d-%s.bin", c.ID, side)
+}
+
+// WriteIndex writes conversations to w as indented JSON.
+func WriteIndex(w io.Writer, conversations []*Conversation) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(conversations); err != nil {
+		return fmt.Errorf("error writing stream index: %w", err)
+	}
+	return nil
+}
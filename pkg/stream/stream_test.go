@@ -0,0 +1,182 @@
+package stream_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/stream"
+)
+
+type segment struct {
+	fromClient bool
+	seq, ack   uint32
+	syn, fin   bool
+	payload    string
+	// port is the client port, 40000 when zero.
+	port layers.TCPPort
+	// at is the offset from the capture start, i milliseconds when zero.
+	at time.Duration
+}
+
+func writeConversation(t *testing.T, path string, segs []segment) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating pcap: %v", err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing header: %v", err)
+	}
+
+	client, server := net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.20")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, s := range segs {
+		src, dst := client, server
+		sport, dport := layers.TCPPort(40000), layers.TCPPort(80)
+		if s.port != 0 {
+			sport = s.port
+		}
+		if !s.fromClient {
+			src, dst, sport, dport = server, client, dport, sport
+		}
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+		tcp := &layers.TCP{SrcPort: sport, DstPort: dport, Seq: s.seq, Ack: s.ack, SYN: s.syn, FIN: s.fin, ACK: s.ack != 0, Window: 65535}
+		tcp.SetNetworkLayerForChecksum(ip4)
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip4, tcp, gopacket.Payload(s.payload)); err != nil {
+			t.Fatalf("Error serializing segment %d: %v", i, err)
+		}
+		at := time.Duration(i) * time.Millisecond
+		if s.at != 0 {
+			at = s.at
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(at),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatalf("Error writing segment %d: %v", i, err)
+		}
+	}
+}
+
+func TestReassemble_FileSink(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "conv.pcap")
+	writeConversation(t, in, []segment{
+		{fromClient: true, seq: 100, syn: true},
+		{fromClient: false, seq: 500, ack: 101, syn: true},
+		{fromClient: true, seq: 101, ack: 501},
+		// Out of order: second half of the request arrives first.
+		{fromClient: true, seq: 107, ack: 501, payload: "/ HTTP/1.0\r\n\r\n"},
+		{fromClient: true, seq: 101, ack: 501, payload: "GET hi"},
+		{fromClient: false, seq: 501, ack: 121, payload: "HTTP/1.0 200 OK\r\n\r\n"},
+		{fromClient: true, seq: 121, ack: 520, fin: true},
+		{fromClient: false, seq: 520, ack: 122, fin: true},
+	})
+
+	sink, err := stream.NewFileSink(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatalf("NewFileSink returned error: %v", err)
+	}
+	convs, err := stream.Reassemble(in, sink, common.NewLogger("test-stream"))
+	if err != nil {
+		t.Fatalf("Reassemble returned error: %v", err)
+	}
+	if len(convs) != 1 {
+		t.Fatalf("Expected 1 conversation, got %d", len(convs))
+	}
+	c := convs[0]
+	if c.ClientIP != "192.168.1.10" || c.ClientPort != 40000 || c.ServerIP != "192.168.1.20" || c.ServerPort != 80 {
+		t.Errorf("Unexpected endpoints: %+v", c)
+	}
+	if c.ClientBytes != 20 || c.ServerBytes != 19 {
+		t.Errorf("Expected 20/19 bytes, got %d/%d", c.ClientBytes, c.ServerBytes)
+	}
+	if !c.End.After(c.Start) {
+		t.Errorf("Expected end %v after start %v", c.End, c.Start)
+	}
+
+	got, err := os.ReadFile(filepath.Join(sink.Dir, sink.FileName(c, true)))
+	if err != nil {
+		t.Fatalf("Error reading client stream: %v", err)
+	}
+	if string(got) != "GET hi/ HTTP/1.0\r\n\r\n" {
+		t.Errorf("Unexpected client stream %q", got)
+	}
+	got, _ = os.ReadFile(filepath.Join(sink.Dir, sink.FileName(c, false)))
+	if string(got) != "HTTP/1.0 200 OK\r\n\r\n" {
+		t.Errorf("Unexpected server stream %q", got)
+	}
+
+	var idx bytes.Buffer
+	if err := stream.WriteIndex(&idx, convs); err != nil {
+		t.Fatalf("WriteIndex returned error: %v", err)
+	}
+	if !bytes.Contains(idx.Bytes(), []byte(`"server_port": 80`)) {
+		t.Errorf("Index missing server port: %s", idx.String())
+	}
+}
+
+// eventSink records the order of Data and Close calls.
+type eventSink struct {
+	events []string
+}
+
+func (s *eventSink) Data(c *stream.Conversation, fromClient bool, data []byte) error {
+	s.events = append(s.events, fmt.Sprintf("data %d", c.ID))
+	return nil
+}
+
+func (s *eventSink) Close(c *stream.Conversation) error {
+	s.events = append(s.events, fmt.Sprintf("close %d", c.ID))
+	return nil
+}
+
+func TestReassemble_ClosesIdleConversations(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "idle.pcap")
+	// The first conversation never finishes; the second starts ten
+	// minutes later.
+	writeConversation(t, in, []segment{
+		{fromClient: true, seq: 100, syn: true},
+		{fromClient: false, seq: 500, ack: 101, syn: true},
+		{fromClient: true, seq: 101, ack: 501, payload: "first"},
+		{fromClient: true, seq: 100, syn: true, port: 40001, at: 10 * time.Minute},
+		{fromClient: false, seq: 500, ack: 101, syn: true, port: 40001, at: 10*time.Minute + time.Millisecond},
+		{fromClient: true, seq: 101, ack: 501, payload: "second", port: 40001, at: 10*time.Minute + 2*time.Millisecond},
+	})
+
+	sink := &eventSink{}
+	convs, err := stream.Reassemble(in, sink, common.NewLogger("test-stream"))
+	if err != nil {
+		t.Fatalf("Reassemble returned error: %v", err)
+	}
+	if len(convs) != 2 {
+		t.Fatalf("Expected 2 conversations, got %d", len(convs))
+	}
+	want := "data 1,close 1,data 2,close 2"
+	if got := strings.Join(sink.events, ","); got != want {
+		t.Errorf("Expected events %s, got %s", want, got)
+	}
+}
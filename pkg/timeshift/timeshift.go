@@ -0,0 +1,86 @@
+package timeshift
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"osi-replay/pkg/common"
+)
+
+// Config describes how capture timestamps are rewritten. For each packet
+//
+//	t' = base + (t - first) * Scale + Offset + random
+//
+// where first is the timestamp of the first packet and base is RebaseTo,
+// or first when RebaseTo is zero.
+type Config struct {
+	// Offset is added to every timestamp.
+	Offset time.Duration
+	// RebaseTo moves the first packet to this time, keeping relative spacing.
+	RebaseTo time.Time
+	// Scale multiplies the gap between each packet and the first one.
+	// Zero means 1 (no scaling).
+	Scale float64
+	// RandomMax, if positive, adds a secret offset drawn uniformly from
+	// [-RandomMax, RandomMax]. The drawn value is never exposed.
+	RandomMax time.Duration
+}
+
+// Shifter is a common.Stage that rewrites CaptureInfo.Timestamp.
+type Shifter struct {
+	cfg    Config
+	random time.Duration
+	first  time.Time
+	seen   bool
+}
+
+// New validates cfg and returns a Shifter.
+func New(cfg Config) (*Shifter, error) {
+	if cfg.Scale < 0 {
+		return nil, fmt.Errorf("invalid time scale %v: must not be negative", cfg.Scale)
+	}
+	if cfg.Scale == 0 {
+		cfg.Scale = 1
+	}
+	s := &Shifter{cfg: cfg}
+	if cfg.RandomMax > 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(2*int64(cfg.RandomMax)+1))
+		if err != nil {
+			return nil, fmt.Errorf("unable to draw random offset: %w", err)
+		}
+		s.random = time.Duration(n.Int64()) - cfg.RandomMax
+	}
+	return s, nil
+}
+
+// Name implements common.Stage.
+func (s *Shifter) Name() string { return "timeshift" }
+
+// Process implements common.Stage.
+func (s *Shifter) Process(pkt common.Packet) ([]common.Packet, error) {
+	pkt.CaptureInfo.Timestamp = s.Shift(pkt.CaptureInfo.Timestamp)
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage.
+func (s *Shifter) Flush() ([]common.Packet, error) { return nil, nil }
+
+// Shift returns the rewritten value of ts. The first call fixes the
+// reference point used for rebasing and scaling.
+func (s *Shifter) Shift(ts time.Time) time.Time {
+	if !s.seen {
+		s.first = ts
+		s.seen = true
+	}
+	base := s.first
+	if !s.cfg.RebaseTo.IsZero() {
+		base = s.cfg.RebaseTo
+	}
+	delta := ts.Sub(s.first)
+	if s.cfg.Scale != 1 {
+		delta = time.Duration(float64(delta) * s.cfg.Scale)
+	}
+	return base.Add(delta).Add(s.cfg.Offset).Add(s.random)
+}
@@ -0,0 +1,54 @@
+package timeshift_test
+
+import (
+	"testing"
+	"time"
+
+	"osi-replay/pkg/timeshift"
+)
+
+func TestShift_OffsetRebaseScale(t *testing.T) {
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rebase := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s, err := timeshift.New(timeshift.Config{
+		RebaseTo: rebase,
+		Scale:    2,
+		Offset:   time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	got := s.Shift(first)
+	if want := rebase.Add(time.Minute); !got.Equal(want) {
+		t.Errorf("First packet: expected %v, got %v", want, got)
+	}
+	got = s.Shift(first.Add(3 * time.Second))
+	if want := rebase.Add(6 * time.Second).Add(time.Minute); !got.Equal(want) {
+		t.Errorf("Second packet: expected %v, got %v", want, got)
+	}
+}
+
+func TestShift_RandomKeepsSpacing(t *testing.T) {
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s, err := timeshift.New(timeshift.Config{RandomMax: time.Hour})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	a := s.Shift(first)
+	b := s.Shift(first.Add(time.Second))
+	if d := a.Sub(first); d < -time.Hour || d > time.Hour {
+		t.Errorf("Random offset %v outside [-1h, 1h]", d)
+	}
+	if b.Sub(a) != time.Second {
+		t.Errorf("Expected spacing of 1s to be preserved, got %v", b.Sub(a))
+	}
+}
+
+func TestNew_NegativeScale(t *testing.T) {
+	if _, err := timeshift.New(timeshift.Config{Scale: -1}); err == nil {
+		t.Errorf("Expected error for negative scale, got nil")
+	}
+}
@@ -5,68 +5,232 @@ import (
 	"io"
 	"os"
 
+	"osi-replay/pkg/audit"
 	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
 	"osi-replay/pkg/sanitizer"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcapgo"
 )
 
+// RuleDecodeError is the audit rule recorded for packets that fail to decode.
+const RuleDecodeError = "decode-error"
+
+// Options controls optional behaviour of RunWithOptions, Stream and New.
+type Options struct {
+	// AuditFile, if set, receives one NDJSON entry per packet describing
+	// the rule that matched and the action taken.
+	AuditFile string
+	// AuditReportFile, if set, receives the aggregate per-rule totals.
+	AuditReportFile string
+	// Audit, if set, receives the per-packet audit entries instead of
+	// AuditFile, and enables Result.Audit.
+	Audit io.Writer
+	// Format is the capture format Stream writes: pcapio.FormatPcap (the
+	// default) or pcapio.FormatPcapng. RunWithOptions picks it from the
+	// output file name.
+	Format string
+	// Prepare stages run in order before the sanitizer, e.g. to strip
+	// tunnel headers so the sanitizer sees the inner packet.
+	Prepare []common.Stage
+	// Stages run in order on every packet the sanitizer keeps. A packet
+	// dropped by a stage is audited under the stage's name.
+	Stages []common.Stage
+}
+
+// Result summarises a Stream run.
+type Result struct {
+	Read    int
+	Written int
+	// Audit holds the per-rule totals when auditing was enabled.
+	Audit []audit.RuleCount
+}
+
 // Run reads from inFile, applies sanitizer logic, and writes outFile.
 func Run(inFile, outFile string, logger *common.Logger) error {
+	return RunWithOptions(inFile, outFile, &Options{}, logger)
+}
+
+// RunWithOptions behaves like Run and additionally honours opts.
+func RunWithOptions(inFile, outFile string, opts *Options, logger *common.Logger) error {
 	fIn, err := os.Open(inFile)
 	if err != nil {
 		return fmt.Errorf("error opening input file: %w", err)
 	}
 	defer fIn.Close()
 
-	reader, err := pcapgo.NewReader(fIn)
-	if err != nil {
-		return fmt.Errorf("error creating pcap reader: %w", err)
-	}
-
 	fOut, err := os.Create(outFile)
 	if err != nil {
 		return fmt.Errorf("error creating output file: %w", err)
 	}
 	defer fOut.Close()
 
-	writer := pcapgo.NewWriter(fOut)
-	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
-		return fmt.Errorf("error writing pcap header: %w", err)
+	o := *opts
+	o.Format = pcapio.FormatOf(outFile)
+	if o.Audit == nil && (o.AuditFile != "" || o.AuditReportFile != "") {
+		o.Audit = io.Discard
+		if o.AuditFile != "" {
+			fAudit, err := os.Create(o.AuditFile)
+			if err != nil {
+				return fmt.Errorf("error creating audit file: %w", err)
+			}
+			defer fAudit.Close()
+			o.Audit = fAudit
+		}
 	}
 
-	var total, kept int
-	for {
-		data, ci, err := reader.ReadPacketData()
-		if err == io.EOF {
-			break
+	res, err := Stream(fIn, fOut, &o, logger)
+	if err != nil {
+		return fmt.Errorf("error reading input file: %w", err)
+	}
+
+	for _, rc := range res.Audit {
+		logger.Info("Audit rule total", "rule", rc.Rule, "action", rc.Action, "packets", rc.Packets)
+	}
+	if o.AuditReportFile != "" {
+		fReport, err := os.Create(o.AuditReportFile)
+		if err != nil {
+			return fmt.Errorf("error creating audit report file: %w", err)
+		}
+		defer fReport.Close()
+		if err := audit.WriteReport(fReport, res.Audit); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// Stream reads a pcap or pcapng capture from r, applies the sanitizer and
+// opts, and writes the kept packets to w in opts.Format. Malformed input is
+// reported as a *pcapio.FormatError; per-packet errors are logged and the
+// packet skipped.
+func Stream(r io.Reader, w io.Writer, opts *Options, logger *common.Logger) (*Result, error) {
+	reader, err := pcapio.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := pcapio.NewFormatWriter(w, opts.Format, 65536, layers.LinkTypeEthernet)
+	if err != nil {
+		return nil, err
+	}
+
+	t := New(opts, logger)
+	res := &Result{}
+	for pkt, err := range common.Apply(pcapio.Packets(reader), []common.Stage{t}, nil) {
 		if err != nil {
-			logger.Error(fmt.Errorf("error reading packet data: %w", err))
+			logger.Error(err)
 			continue
 		}
-		total++
+		if err := pcapio.WritePacket(writer, pkt); err != nil {
+			logger.Error(fmt.Errorf("error writing sanitized packet: %w", err))
+			continue
+		}
+		res.Written++
+	}
+	res.Read = t.Read
+	res.Audit = t.Report()
 
-		packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	logger.Info("Transform complete", "read", res.Read, "kept", res.Written)
+	return res, nil
+}
+
+// Transformer is a pipeline stage applying the whole transform: the
+// Prepare stages, the sanitizer, then the post-sanitizer Stages. Use it
+// with common.Apply to transform packets held in memory.
+type Transformer struct {
+	opts     *Options
+	logger   *common.Logger
+	recorder *audit.Recorder
+	// Read counts the packets passed to Process.
+	Read int
+}
+
+// New returns a Transformer for opts. Audit entries go to opts.Audit;
+// AuditFile and AuditReportFile are only used by RunWithOptions.
+func New(opts *Options, logger *common.Logger) *Transformer {
+	t := &Transformer{opts: opts, logger: logger}
+	if opts.Audit != nil {
+		t.recorder = audit.NewRecorder(opts.Audit)
+	}
+	return t
+}
+
+// Name implements common.Stage.
+func (t *Transformer) Name() string { return "transform" }
+
+// Process implements common.Stage.
+func (t *Transformer) Process(pkt common.Packet) ([]common.Packet, error) {
+	t.Read++
+	prepared, err := common.RunStages(t.opts.Prepare, []common.Packet{pkt}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return t.sanitize(prepared)
+}
+
+// Flush implements common.Stage.
+func (t *Transformer) Flush() ([]common.Packet, error) {
+	prepared, err := common.FlushStages(t.opts.Prepare, nil)
+	if err != nil {
+		t.logger.Error(err)
+	}
+	out, err := t.sanitize(prepared)
+	if err != nil {
+		t.logger.Error(err)
+	}
+	rest, err := common.FlushStages(t.opts.Stages, t.dropped)
+	return append(out, rest...), err
+}
+
+// Report returns the per-rule audit totals, or nil when auditing is off.
+func (t *Transformer) Report() []audit.RuleCount {
+	if t.recorder == nil {
+		return nil
+	}
+	return t.recorder.Report()
+}
+
+// sanitize decodes and audits each packet, then passes the ones the
+// sanitizer keeps through the post-sanitizer stages.
+func (t *Transformer) sanitize(pkts []common.Packet) ([]common.Packet, error) {
+	var out []common.Packet
+	for _, pkt := range pkts {
+		packet := gopacket.NewPacket(pkt.Data, layers.LayerTypeEthernet, gopacket.Default)
 		if packet.ErrorLayer() != nil {
-			logger.Warn(fmt.Sprintf("Packet decode error: %v", packet.ErrorLayer().Error()))
+			t.logger.Debug("Dropped undecodable packet", "index", pkt.Index, "error", packet.ErrorLayer().Error())
+			t.record(pkt, RuleDecodeError, audit.ActionDrop)
 			continue
 		}
 
-		newPacket, keep := sanitizer.SanitizePacket(packet)
-		if !keep || newPacket == nil {
+		decision := sanitizer.Evaluate(packet)
+		if !decision.Keep {
+			t.logger.Debug("Sanitizer dropped packet", "index", pkt.Index, "rule", decision.Rule)
+			t.record(pkt, decision.Rule, audit.ActionDrop)
 			continue
 		}
+		t.record(pkt, decision.Rule, audit.ActionKeep)
 
-		if err := writer.WritePacket(ci, newPacket.Data()); err != nil {
-			logger.Error(fmt.Errorf("error writing sanitized packet: %w", err))
-			continue
+		kept, err := common.RunStages(t.opts.Stages, []common.Packet{pkt}, t.dropped)
+		if err != nil {
+			return out, err
 		}
-		kept++
+		out = append(out, kept...)
 	}
+	return out, nil
+}
 
-	logger.Info(fmt.Sprintf("Done. Processed %d packets, kept %d.", total, kept))
-	return nil
+func (t *Transformer) dropped(st common.Stage, pkt common.Packet) {
+	t.logger.Debug("Stage dropped packet", "stage", st.Name(), "index", pkt.Index)
+	t.record(pkt, st.Name(), audit.ActionDrop)
+}
+
+func (t *Transformer) record(pkt common.Packet, rule, action string) {
+	if t.recorder == nil {
+		return
+	}
+	e := audit.Entry{Index: pkt.Index, Timestamp: pkt.CaptureInfo.Timestamp, Rule: rule, Action: action}
+	if err := t.recorder.Record(e); err != nil {
+		t.logger.Error(err)
+	}
 }
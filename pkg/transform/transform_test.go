@@ -1,11 +1,26 @@
 package transform_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/audit"
 	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/sanitizer"
+	"osi-replay/pkg/timeshift"
 	"osi-replay/pkg/transform"
 )
 
@@ -44,3 +59,202 @@ func TestRun_Basic(t *testing.T) {
 	}
 	_ = os.Remove(pcapOut)
 }
+
+// writeTestPcap serializes one Ethernet/IPv4 frame per src/dst pair into path.
+func writeTestPcap(t *testing.T, path string, pairs [][2]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating test pcap: %v", err)
+	}
+	defer f.Close()
+
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("Error writing pcap header: %v", err)
+	}
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, p := range pairs {
+		buf := gopacket.NewSerializeBuffer()
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip4 := &layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    net.ParseIP(p[0]),
+			DstIP:    net.ParseIP(p[1]),
+		}
+		udp := &layers.UDP{SrcPort: 1000, DstPort: 2000}
+		udp.SetNetworkLayerForChecksum(ip4)
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, gopacket.Payload([]byte{byte(i)})); err != nil {
+			t.Fatalf("Error serializing packet: %v", err)
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     start.Add(time.Duration(i) * time.Second),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatalf("Error writing packet: %v", err)
+		}
+	}
+}
+
+func TestRunWithOptions_Audit(t *testing.T) {
+	dir := t.TempDir()
+	pcapIn := filepath.Join(dir, "in.pcap")
+	writeTestPcap(t, pcapIn, [][2]string{
+		{"192.168.1.1", "192.168.1.2"},
+		{"10.0.0.1", "192.168.1.2"},
+	})
+
+	opts := &transform.Options{
+		AuditFile:       filepath.Join(dir, "audit.ndjson"),
+		AuditReportFile: filepath.Join(dir, "report.json"),
+	}
+	logger := common.NewLogger("test-transform")
+	if err := transform.RunWithOptions(pcapIn, filepath.Join(dir, "out.pcap"), opts, logger); err != nil {
+		t.Fatalf("Unexpected error transforming pcap: %v", err)
+	}
+
+	data, err := os.ReadFile(opts.AuditFile)
+	if err != nil {
+		t.Fatalf("Error reading audit file: %v", err)
+	}
+	var entries []audit.Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e audit.Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %d", len(entries))
+	}
+	if entries[0].Action != audit.ActionKeep || entries[0].Rule != sanitizer.RuleDefault {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Index != 2 || entries[1].Action != audit.ActionDrop || entries[1].Rule != sanitizer.RuleBlockedIP {
+		t.Errorf("Unexpected second entry: %+v", entries[1])
+	}
+
+	var report []audit.RuleCount
+	data, err = os.ReadFile(opts.AuditReportFile)
+	if err != nil {
+		t.Fatalf("Error reading audit report: %v", err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Invalid audit report: %v", err)
+	}
+	if len(report) != 2 {
+		t.Errorf("Expected 2 report rows, got %+v", report)
+	}
+}
+
+func TestRunWithOptions_TimeshiftStage(t *testing.T) {
+	dir := t.TempDir()
+	pcapIn := filepath.Join(dir, "in.pcap")
+	pcapOut := filepath.Join(dir, "out.pcap")
+	writeTestPcap(t, pcapIn, [][2]string{
+		{"192.168.1.1", "192.168.1.2"},
+		{"192.168.1.2", "192.168.1.1"},
+	})
+
+	rebase := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	shifter, err := timeshift.New(timeshift.Config{RebaseTo: rebase})
+	if err != nil {
+		t.Fatalf("timeshift.New returned error: %v", err)
+	}
+	opts := &transform.Options{Stages: []common.Stage{shifter}}
+	logger := common.NewLogger("test-transform")
+	if err := transform.RunWithOptions(pcapIn, pcapOut, opts, logger); err != nil {
+		t.Fatalf("Unexpected error transforming pcap: %v", err)
+	}
+
+	f, err := os.Open(pcapOut)
+	if err != nil {
+		t.Fatalf("Error opening output: %v", err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, ci, err := r.ReadPacketData()
+		if err != nil {
+			t.Fatalf("Error reading packet %d: %v", i, err)
+		}
+		if want := rebase.Add(time.Duration(i) * time.Second); !ci.Timestamp.Equal(want) {
+			t.Errorf("Packet %d: expected timestamp %v, got %v", i, want, ci.Timestamp)
+		}
+	}
+}
+
+func TestStream_InMemory(t *testing.T) {
+	pcapIn := filepath.Join(t.TempDir(), "in.pcap")
+	writeTestPcap(t, pcapIn, [][2]string{
+		{"192.168.1.1", "192.168.1.2"},
+		{"10.0.0.1", "192.168.1.2"},
+	})
+	in, err := os.ReadFile(pcapIn)
+	if err != nil {
+		t.Fatalf("Error reading test pcap: %v", err)
+	}
+
+	var out, auditLog bytes.Buffer
+	opts := &transform.Options{Format: pcapio.FormatPcapng, Audit: &auditLog}
+	res, err := transform.Stream(bytes.NewReader(in), &out, opts, nil)
+	if err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if res.Read != 2 || res.Written != 1 || len(res.Audit) != 2 {
+		t.Errorf("Unexpected result: %+v", res)
+	}
+	if n := strings.Count(auditLog.String(), "\n"); n != 2 {
+		t.Errorf("Expected 2 audit entries, got %d", n)
+	}
+
+	file, err := pcapio.NewReader(&out)
+	if err != nil {
+		t.Fatalf("Output is not a capture: %v", err)
+	}
+	if file.Format != pcapio.FormatPcapng {
+		t.Errorf("Expected pcapng output, got %s", file.Format)
+	}
+}
+
+func TestStream_TruncatedInput(t *testing.T) {
+	pcapIn := filepath.Join(t.TempDir(), "in.pcap")
+	writeTestPcap(t, pcapIn, [][2]string{
+		{"192.168.1.1", "192.168.1.2"},
+		{"192.168.1.3", "192.168.1.4"},
+	})
+	in, err := os.ReadFile(pcapIn)
+	if err != nil {
+		t.Fatalf("Error reading test pcap: %v", err)
+	}
+
+	res, err := transform.Stream(bytes.NewReader(in[:len(in)-5]), io.Discard, &transform.Options{}, nil)
+	if err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if res.Read != 1 || res.Written != 1 {
+		t.Errorf("Expected the packet before the truncation only, got %+v", res)
+	}
+}
+
+func TestStream_FormatError(t *testing.T) {
+	_, err := transform.Stream(strings.NewReader("garbage"), io.Discard, &transform.Options{}, nil)
+	var fe *pcapio.FormatError
+	if !errors.As(err, &fe) {
+		t.Errorf("Expected *pcapio.FormatError, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/vlan"
+)
+
+// Ways of keeping the tunnel identifier after decapsulation.
+const (
+	KeepNone    = ""
+	KeepVLAN    = "vlan"
+	KeepComment = "comment"
+)
+
+// DecapConfig controls a Decapsulator.
+type DecapConfig struct {
+	// KeepID preserves the VNI, GRE key or TEID of the stripped tunnel:
+	// KeepVLAN pushes it as an 802.1Q tag (low 12 bits only), KeepComment
+	// stores it in the packet comment written to pcapng outputs.
+	KeepID string
+}
+
+// Decapsulator is a common.Stage that strips the outermost GRE, VXLAN,
+// GENEVE or GTP-U encapsulation and emits the inner frame. Inner IP
+// packets get an Ethernet header built from the outer one. Packets
+// without a recognised tunnel pass through unchanged.
+type Decapsulator struct {
+	cfg      DecapConfig
+	Stripped int
+}
+
+// NewDecapsulator validates cfg and returns a Decapsulator.
+func NewDecapsulator(cfg DecapConfig) (*Decapsulator, error) {
+	switch cfg.KeepID {
+	case KeepNone, KeepVLAN, KeepComment:
+	default:
+		return nil, fmt.Errorf("unknown tunnel ID mode %q", cfg.KeepID)
+	}
+	return &Decapsulator{cfg: cfg}, nil
+}
+
+// Name implements common.Stage.
+func (d *Decapsulator) Name() string { return "decap" }
+
+// Process implements common.Stage.
+func (d *Decapsulator) Process(pkt common.Packet) ([]common.Packet, error) {
+	inner, kind, id, ok := Decapsulate(pkt.Data)
+	if !ok {
+		return []common.Packet{pkt}, nil
+	}
+
+	switch d.cfg.KeepID {
+	case KeepVLAN:
+		tagger, err := vlan.New(vlan.Config{Push: []vlan.Tag{{TPID: vlan.TPIDDot1Q, VID: uint16(id & 0xfff)}}})
+		if err != nil {
+			return nil, err
+		}
+		if inner, err = tagger.Apply(inner); err != nil {
+			return nil, err
+		}
+	case KeepComment:
+		comment := fmt.Sprintf("%s id=%d", kind, id)
+		if pkt.Comment != "" {
+			comment = pkt.Comment + "; " + comment
+		}
+		pkt.Comment = comment
+	}
+
+	d.Stripped++
+	pkt.Data = inner
+	pkt.CaptureInfo.CaptureLength = len(inner)
+	pkt.CaptureInfo.Length = len(inner)
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage.
+func (d *Decapsulator) Flush() ([]common.Packet, error) { return nil, nil }
+
+// Decapsulate returns the Ethernet frame carried inside the outermost
+// tunnel of data, the tunnel kind ("vxlan", "geneve", "gre", "gtpu") and
+// its identifier. ok is false when data is not tunnelled.
+func Decapsulate(data []byte) (inner []byte, kind string, id uint32, ok bool) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	eth, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if eth == nil {
+		return nil, "", 0, false
+	}
+
+	for _, l := range packet.Layers() {
+		switch t := l.(type) {
+		case *layers.VXLAN:
+			return t.LayerPayload(), "vxlan", t.VNI, true
+		case *layers.Geneve:
+			inner, ok := innerFrame(eth, t.Protocol, t.LayerPayload())
+			return inner, "geneve", t.VNI, ok
+		case *layers.GRE:
+			inner, ok := innerFrame(eth, t.Protocol, t.LayerPayload())
+			return inner, "gre", t.Key, ok
+		case *layers.GTPv1U:
+			payload := t.LayerPayload()
+			if len(payload) == 0 {
+				return nil, "", 0, false
+			}
+			proto := layers.EthernetTypeIPv4
+			if payload[0]>>4 == 6 {
+				proto = layers.EthernetTypeIPv6
+			}
+			inner, ok := innerFrame(eth, proto, payload)
+			return inner, "gtpu", t.TEID, ok
+		}
+	}
+	return nil, "", 0, false
+}
+
+// innerFrame returns payload as an Ethernet frame, adding a header copied
+// from outer when the payload is a bare IP packet.
+func innerFrame(outer *layers.Ethernet, proto layers.EthernetType, payload []byte) ([]byte, bool) {
+	switch proto {
+	case layers.EthernetTypeTransparentEthernetBridging:
+		return payload, true
+	case layers.EthernetTypeIPv4, layers.EthernetTypeIPv6:
+		buf := gopacket.NewSerializeBuffer()
+		eth := &layers.Ethernet{
+			SrcMAC:       outer.SrcMAC,
+			DstMAC:       outer.DstMAC,
+			EthernetType: proto,
+		}
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, gopacket.Payload(payload)); err != nil {
+			return nil, false
+		}
+		return buf.Bytes(), true
+	}
+	return nil, false
+}
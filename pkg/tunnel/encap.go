@@ -0,0 +1,210 @@
+package tunnel
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+)
+
+// Encapsulation kinds supported by Encapsulator.
+const (
+	KindVXLAN = "vxlan"
+	KindGRE   = "gre"
+	KindIPIP  = "ipip"
+)
+
+// DefaultVXLANPort is the IANA-assigned VXLAN UDP port.
+const DefaultVXLANPort = 4789
+
+// EncapConfig describes the outer headers added by an Encapsulator.
+type EncapConfig struct {
+	// Kind is KindVXLAN, KindGRE (transparent Ethernet bridging) or
+	// KindIPIP (IPv4/IPv6-in-IP; the inner Ethernet header is dropped).
+	Kind string
+	// SrcIP and DstIP are the outer addresses; both IPv4 or both IPv6.
+	SrcIP, DstIP net.IP
+	// SrcMAC and DstMAC are the outer Ethernet addresses. When unset the
+	// inner frame's addresses are reused.
+	SrcMAC, DstMAC net.HardwareAddr
+	// VNI is the VXLAN network identifier, or the GRE key when non-zero.
+	VNI uint32
+	// SrcPort and DstPort are the outer UDP ports for VXLAN. SrcPort 0
+	// derives a per-flow port from the inner headers; DstPort 0 uses
+	// DefaultVXLANPort.
+	SrcPort, DstPort uint16
+	// TTL is the outer hop limit; 0 means 64.
+	TTL uint8
+}
+
+// Encapsulator is a common.Stage that wraps each Ethernet frame in a
+// VXLAN, GRE or IP-in-IP tunnel with correct lengths and checksums.
+type Encapsulator struct {
+	cfg EncapConfig
+}
+
+// NewEncapsulator validates cfg and returns an Encapsulator.
+func NewEncapsulator(cfg EncapConfig) (*Encapsulator, error) {
+	switch cfg.Kind {
+	case KindVXLAN, KindGRE, KindIPIP:
+	default:
+		return nil, fmt.Errorf("unknown encapsulation %q", cfg.Kind)
+	}
+	if cfg.SrcIP == nil || cfg.DstIP == nil {
+		return nil, fmt.Errorf("outer source and destination IPs are required")
+	}
+	if (cfg.SrcIP.To4() == nil) != (cfg.DstIP.To4() == nil) {
+		return nil, fmt.Errorf("outer addresses %v and %v are of different families", cfg.SrcIP, cfg.DstIP)
+	}
+	if cfg.Kind == KindVXLAN && cfg.VNI > 0xffffff {
+		return nil, fmt.Errorf("VXLAN VNI %d exceeds 24 bits", cfg.VNI)
+	}
+	if cfg.DstPort == 0 {
+		cfg.DstPort = DefaultVXLANPort
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 64
+	}
+	return &Encapsulator{cfg: cfg}, nil
+}
+
+// Name implements common.Stage.
+func (e *Encapsulator) Name() string { return "encap" }
+
+// Process implements common.Stage.
+func (e *Encapsulator) Process(pkt common.Packet) ([]common.Packet, error) {
+	data, err := e.Encapsulate(pkt.Data)
+	if err != nil {
+		return nil, err
+	}
+	pkt.Data = data
+	pkt.CaptureInfo.CaptureLength = len(data)
+	pkt.CaptureInfo.Length = len(data)
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage.
+func (e *Encapsulator) Flush() ([]common.Packet, error) { return nil, nil }
+
+// Encapsulate returns the Ethernet frame data wrapped in the configured tunnel.
+func (e *Encapsulator) Encapsulate(data []byte) ([]byte, error) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.NoCopy)
+	inner, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if inner == nil {
+		return nil, fmt.Errorf("frame has no Ethernet header")
+	}
+
+	outerEth := &layers.Ethernet{SrcMAC: e.cfg.SrcMAC, DstMAC: e.cfg.DstMAC}
+	if outerEth.SrcMAC == nil {
+		outerEth.SrcMAC = inner.SrcMAC
+	}
+	if outerEth.DstMAC == nil {
+		outerEth.DstMAC = inner.DstMAC
+	}
+
+	var (
+		proto   layers.IPProtocol
+		payload []gopacket.SerializableLayer
+	)
+	switch e.cfg.Kind {
+	case KindVXLAN:
+		proto = layers.IPProtocolUDP
+		udp := &layers.UDP{
+			SrcPort: layers.UDPPort(e.cfg.SrcPort),
+			DstPort: layers.UDPPort(e.cfg.DstPort),
+		}
+		if udp.SrcPort == 0 {
+			udp.SrcPort = layers.UDPPort(flowPort(packet, inner))
+		}
+		vx := &layers.VXLAN{ValidIDFlag: true, VNI: e.cfg.VNI}
+		payload = []gopacket.SerializableLayer{udp, vx, gopacket.Payload(data)}
+	case KindGRE:
+		proto = layers.IPProtocolGRE
+		gre := &layers.GRE{Protocol: layers.EthernetTypeTransparentEthernetBridging}
+		if e.cfg.VNI != 0 {
+			gre.KeyPresent = true
+			gre.Key = e.cfg.VNI
+		}
+		payload = []gopacket.SerializableLayer{gre, gopacket.Payload(data)}
+	case KindIPIP:
+		switch inner.EthernetType {
+		case layers.EthernetTypeIPv4:
+			proto = layers.IPProtocolIPv4
+		case layers.EthernetTypeIPv6:
+			proto = layers.IPProtocolIPv6
+		default:
+			return nil, fmt.Errorf("IP-in-IP cannot carry EtherType %v", inner.EthernetType)
+		}
+		payload = []gopacket.SerializableLayer{gopacket.Payload(inner.LayerPayload())}
+	}
+
+	var outerIP gopacket.SerializableLayer
+	if e.cfg.DstIP.To4() != nil {
+		outerEth.EthernetType = layers.EthernetTypeIPv4
+		outerIP = &layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      e.cfg.TTL,
+			Flags:    layers.IPv4DontFragment,
+			Protocol: proto,
+			SrcIP:    e.cfg.SrcIP.To4(),
+			DstIP:    e.cfg.DstIP.To4(),
+		}
+	} else {
+		outerEth.EthernetType = layers.EthernetTypeIPv6
+		outerIP = &layers.IPv6{
+			Version:    6,
+			HopLimit:   e.cfg.TTL,
+			NextHeader: proto,
+			SrcIP:      e.cfg.SrcIP,
+			DstIP:      e.cfg.DstIP,
+		}
+	}
+	if udp, ok := payload[0].(*layers.UDP); ok {
+		udp.SetNetworkLayerForChecksum(outerIP.(gopacket.NetworkLayer))
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	ls := append([]gopacket.SerializableLayer{outerEth, outerIP}, payload...)
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		return nil, fmt.Errorf("serialize error: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// flowPort derives a VXLAN source port in the dynamic range from the
+// inner frame's addresses, protocol and ports, so packets of one flow
+// share a port (RFC 7348 §5). Non-IP frames hash their Ethernet header.
+func flowPort(packet gopacket.Packet, eth *layers.Ethernet) uint16 {
+	h := fnv.New32a()
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		h.Write(ip.SrcIP.To4())
+		h.Write(ip.DstIP.To4())
+		h.Write([]byte{byte(ip.Protocol)})
+	case *layers.IPv6:
+		h.Write(ip.SrcIP.To16())
+		h.Write(ip.DstIP.To16())
+		h.Write([]byte{byte(ip.NextHeader)})
+	default:
+		h.Write(eth.SrcMAC)
+		h.Write(eth.DstMAC)
+		h.Write([]byte{byte(eth.EthernetType >> 8), byte(eth.EthernetType)})
+		return uint16(49152 + h.Sum32()%16384)
+	}
+	// Fragments carry no transport layer and hash on addresses alone.
+	if tl := packet.TransportLayer(); tl != nil {
+		src, dst := tl.TransportFlow().Endpoints()
+		h.Write(src.Raw())
+		h.Write(dst.Raw())
+	}
+	return uint16(49152 + h.Sum32()%16384)
+}
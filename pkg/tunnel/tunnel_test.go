@@ -0,0 +1,288 @@
+package tunnel_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/tunnel"
+)
+
+var (
+	outerSrcMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	outerDstMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+func serialize(t *testing.T, ls ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatalf("Error serializing layers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// innerIPv4 returns the layers of a small UDP datagram 10.1.1.1 -> 10.1.1.2.
+func innerIPv4() (*layers.IPv4, *layers.UDP, gopacket.Payload) {
+	ip4 := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("10.1.1.1"),
+		DstIP:    net.ParseIP("10.1.1.2"),
+	}
+	udp := &layers.UDP{SrcPort: 1111, DstPort: 2222}
+	udp.SetNetworkLayerForChecksum(ip4)
+	return ip4, udp, gopacket.Payload("inner")
+}
+
+func outerIPv4(proto layers.IPProtocol) (*layers.Ethernet, *layers.IPv4) {
+	eth := &layers.Ethernet{
+		SrcMAC:       outerSrcMAC,
+		DstMAC:       outerDstMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: proto,
+		SrcIP:    net.ParseIP("172.16.0.1"),
+		DstIP:    net.ParseIP("172.16.0.2"),
+	}
+	return eth, ip4
+}
+
+func buildVXLAN(t *testing.T, vni uint32) []byte {
+	t.Helper()
+	eth, ip4 := outerIPv4(layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: 50000, DstPort: 4789}
+	udp.SetNetworkLayerForChecksum(ip4)
+	innerEth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	iip, iudp, payload := innerIPv4()
+	return serialize(t, eth, ip4, udp, &layers.VXLAN{ValidIDFlag: true, VNI: vni}, innerEth, iip, iudp, payload)
+}
+
+func TestDecapsulate_VXLAN(t *testing.T) {
+	inner, kind, id, ok := tunnel.Decapsulate(buildVXLAN(t, 4242))
+	if !ok {
+		t.Fatalf("Expected VXLAN packet to be decapsulated")
+	}
+	if kind != "vxlan" || id != 4242 {
+		t.Errorf("Expected vxlan/4242, got %s/%d", kind, id)
+	}
+
+	packet := gopacket.NewPacket(inner, layers.LayerTypeEthernet, gopacket.Default)
+	eth, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ip4, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if eth == nil || eth.SrcMAC.String() != "00:11:22:33:44:55" {
+		t.Errorf("Expected inner Ethernet header to be emitted")
+	}
+	if ip4 == nil || ip4.SrcIP.String() != "10.1.1.1" {
+		t.Errorf("Expected inner IPv4 source 10.1.1.1")
+	}
+}
+
+func TestDecapsulate_GREInnerIP(t *testing.T) {
+	eth, ip4 := outerIPv4(layers.IPProtocolGRE)
+	gre := &layers.GRE{Protocol: layers.EthernetTypeIPv4, KeyPresent: true, Key: 7}
+	iip, iudp, payload := innerIPv4()
+	data := serialize(t, eth, ip4, gre, iip, iudp, payload)
+
+	inner, kind, id, ok := tunnel.Decapsulate(data)
+	if !ok || kind != "gre" || id != 7 {
+		t.Fatalf("Expected gre/7, got %s/%d (ok=%v)", kind, id, ok)
+	}
+	packet := gopacket.NewPacket(inner, layers.LayerTypeEthernet, gopacket.Default)
+	outEth, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if outEth == nil || outEth.SrcMAC.String() != outerSrcMAC.String() {
+		t.Errorf("Expected synthesized Ethernet header with outer MACs")
+	}
+	if ip, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ip == nil || ip.DstIP.String() != "10.1.1.2" {
+		t.Errorf("Expected inner IPv4 destination 10.1.1.2")
+	}
+}
+
+func TestDecapsulator_KeepIDAsVLAN(t *testing.T) {
+	d, err := tunnel.NewDecapsulator(tunnel.DecapConfig{KeepID: tunnel.KeepVLAN})
+	if err != nil {
+		t.Fatalf("NewDecapsulator returned error: %v", err)
+	}
+	data := buildVXLAN(t, 100)
+	out, err := d.Process(common.Packet{Data: data, CaptureInfo: gopacket.CaptureInfo{CaptureLength: len(data), Length: len(data)}})
+	if err != nil || len(out) != 1 {
+		t.Fatalf("Process returned %d packets, err=%v", len(out), err)
+	}
+
+	packet := gopacket.NewPacket(out[0].Data, layers.LayerTypeEthernet, gopacket.Default)
+	tag, _ := packet.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+	if tag == nil || tag.VLANIdentifier != 100 {
+		t.Errorf("Expected VNI 100 to be kept as VLAN tag")
+	}
+	if out[0].CaptureInfo.CaptureLength != len(out[0].Data) {
+		t.Errorf("Capture length %d does not match data length %d", out[0].CaptureInfo.CaptureLength, len(out[0].Data))
+	}
+}
+
+func TestDecapsulator_PassThrough(t *testing.T) {
+	d, _ := tunnel.NewDecapsulator(tunnel.DecapConfig{KeepID: tunnel.KeepComment})
+	eth, ip4 := outerIPv4(layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: 1000, DstPort: 2000}
+	udp.SetNetworkLayerForChecksum(ip4)
+	data := serialize(t, eth, ip4, udp)
+
+	out, err := d.Process(common.Packet{Data: data})
+	if err != nil || len(out) != 1 || out[0].Comment != "" || len(out[0].Data) != len(data) {
+		t.Errorf("Expected untunnelled packet to pass through unchanged")
+	}
+}
+
+func buildInnerFrame(t *testing.T) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	iip, iudp, payload := innerIPv4()
+	return serialize(t, eth, iip, iudp, payload)
+}
+
+func TestEncapsulate_RoundTrip(t *testing.T) {
+	frame := buildInnerFrame(t)
+	for _, kind := range []string{tunnel.KindVXLAN, tunnel.KindGRE} {
+		e, err := tunnel.NewEncapsulator(tunnel.EncapConfig{
+			Kind:  kind,
+			SrcIP: net.ParseIP("192.0.2.1"),
+			DstIP: net.ParseIP("192.0.2.2"),
+			VNI:   5001,
+		})
+		if err != nil {
+			t.Fatalf("%s: NewEncapsulator returned error: %v", kind, err)
+		}
+		wrapped, err := e.Encapsulate(frame)
+		if err != nil {
+			t.Fatalf("%s: Encapsulate returned error: %v", kind, err)
+		}
+
+		packet := gopacket.NewPacket(wrapped, layers.LayerTypeEthernet, gopacket.Default)
+		outer, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		if outer == nil || outer.DstIP.String() != "192.0.2.2" {
+			t.Fatalf("%s: expected outer IPv4 header to 192.0.2.2", kind)
+		}
+		if int(outer.Length) != len(wrapped)-14 {
+			t.Errorf("%s: outer IP length %d, expected %d", kind, outer.Length, len(wrapped)-14)
+		}
+		var sum uint32
+		for i := 0; i < len(outer.Contents); i += 2 {
+			sum += uint32(outer.Contents[i])<<8 | uint32(outer.Contents[i+1])
+		}
+		for sum > 0xffff {
+			sum = sum>>16 + sum&0xffff
+		}
+		if sum != 0xffff {
+			t.Errorf("%s: invalid outer IP checksum %#04x", kind, outer.Checksum)
+		}
+
+		inner, gotKind, id, ok := tunnel.Decapsulate(wrapped)
+		if !ok || gotKind != kind || id != 5001 {
+			t.Fatalf("%s: round trip returned %s/%d (ok=%v)", kind, gotKind, id, ok)
+		}
+		if string(inner) != string(frame) {
+			t.Errorf("%s: inner frame changed by round trip", kind)
+		}
+	}
+}
+
+func TestEncapsulate_VXLANPortPerFlow(t *testing.T) {
+	e, err := tunnel.NewEncapsulator(tunnel.EncapConfig{
+		Kind:  tunnel.KindVXLAN,
+		SrcIP: net.ParseIP("192.0.2.1"),
+		DstIP: net.ParseIP("192.0.2.2"),
+	})
+	if err != nil {
+		t.Fatalf("NewEncapsulator returned error: %v", err)
+	}
+	port := func(id uint16, sport layers.TCPPort, seq uint32) layers.UDPPort {
+		t.Helper()
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip4 := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: id, Protocol: layers.IPProtocolTCP,
+			SrcIP: net.ParseIP("10.1.1.1"), DstIP: net.ParseIP("10.1.1.2")}
+		tcp := &layers.TCP{SrcPort: sport, DstPort: 80, Seq: seq, Ack: seq * 3, ACK: true, Window: 1024}
+		tcp.SetNetworkLayerForChecksum(ip4)
+		wrapped, err := e.Encapsulate(serialize(t, eth, ip4, tcp, gopacket.Payload("segment")))
+		if err != nil {
+			t.Fatalf("Encapsulate returned error: %v", err)
+		}
+		packet := gopacket.NewPacket(wrapped, layers.LayerTypeEthernet, gopacket.Default)
+		return packet.Layer(layers.LayerTypeUDP).(*layers.UDP).SrcPort
+	}
+
+	// Two segments of one connection differ in IP ID, seq, ack and
+	// checksums but must share a port.
+	first, second := port(1, 40000, 1000), port(2, 40000, 2000)
+	if first != second {
+		t.Errorf("Segments of one flow got ports %d and %d", first, second)
+	}
+	if first < 49152 {
+		t.Errorf("Port %d is outside the dynamic range", first)
+	}
+	if other := port(1, 40001, 1000); other == first {
+		t.Logf("Different flows happened to share port %d", first)
+	}
+}
+
+func TestEncapsulate_IPIP(t *testing.T) {
+	e, err := tunnel.NewEncapsulator(tunnel.EncapConfig{
+		Kind:  tunnel.KindIPIP,
+		SrcIP: net.ParseIP("192.0.2.1"),
+		DstIP: net.ParseIP("192.0.2.2"),
+	})
+	if err != nil {
+		t.Fatalf("NewEncapsulator returned error: %v", err)
+	}
+	wrapped, err := e.Encapsulate(buildInnerFrame(t))
+	if err != nil {
+		t.Fatalf("Encapsulate returned error: %v", err)
+	}
+
+	packet := gopacket.NewPacket(wrapped, layers.LayerTypeEthernet, gopacket.Default)
+	var ips []*layers.IPv4
+	for _, l := range packet.Layers() {
+		if ip, ok := l.(*layers.IPv4); ok {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) != 2 || ips[0].Protocol != layers.IPProtocolIPv4 || ips[1].SrcIP.String() != "10.1.1.1" {
+		t.Errorf("Expected IPv4-in-IPv4 packet, got %d IP layers", len(ips))
+	}
+}
+
+func TestNewEncapsulator_Invalid(t *testing.T) {
+	cases := []tunnel.EncapConfig{
+		{Kind: "mpls", SrcIP: net.ParseIP("192.0.2.1"), DstIP: net.ParseIP("192.0.2.2")},
+		{Kind: tunnel.KindVXLAN},
+		{Kind: tunnel.KindGRE, SrcIP: net.ParseIP("192.0.2.1"), DstIP: net.ParseIP("2001:db8::1")},
+	}
+	for _, cfg := range cases {
+		if _, err := tunnel.NewEncapsulator(cfg); err == nil {
+			t.Errorf("Expected error for config %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package verify
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/replay"
+)
+
+// DefaultWait is how long Run keeps capturing after the last packet is sent.
+const DefaultWait = time.Second
+
+// Latency summarises the one-way latency of matched packets.
+type Latency struct {
+	Min  time.Duration `json:"min_ns"`
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// Report is the outcome of a verification. Indexes are the 1-based
+// positions of packets in the sent stream.
+type Report struct {
+	Sent       int `json:"sent"`
+	Received   int `json:"received"`
+	Matched    int `json:"matched"`
+	Lost       int `json:"lost"`
+	Reordered  int `json:"reordered"`
+	Duplicated int `json:"duplicated"`
+	Modified   int `json:"modified"`
+	// Unrelated counts received frames that match nothing sent, such as
+	// neighbour discovery on the capture interface.
+	Unrelated int     `json:"unrelated"`
+	Latency   Latency `json:"latency"`
+
+	LostIndexes     []int `json:"lost_indexes,omitempty"`
+	ModifiedIndexes []int `json:"modified_indexes,omitempty"`
+}
+
+// Intact reports whether every packet arrived once, in order and unchanged.
+func (r *Report) Intact() bool {
+	return r.Lost == 0 && r.Reordered == 0 && r.Duplicated == 0 && r.Modified == 0
+}
+
+// WriteText writes a human-readable summary of r to w.
+func (r *Report) WriteText(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Sent %d, received %d: matched=%d lost=%d reordered=%d duplicated=%d modified=%d unrelated=%d\n",
+		r.Sent, r.Received, r.Matched, r.Lost, r.Reordered, r.Duplicated, r.Modified, r.Unrelated)
+	if r.Matched > 0 {
+		l := r.Latency
+		fmt.Fprintf(&sb, "Latency: min=%v mean=%v p50=%v p90=%v p99=%v max=%v\n", l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+	}
+	if len(r.LostIndexes) > 0 {
+		fmt.Fprintf(&sb, "Lost: %s\n", joinInts(r.LostIndexes))
+	}
+	if len(r.ModifiedIndexes) > 0 {
+		fmt.Fprintf(&sb, "Modified: %s\n", joinInts(r.ModifiedIndexes))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type sent struct {
+	index   int
+	ts      time.Time
+	content uint64
+}
+
+// Matcher pairs sent frames with received ones. Frames are identified by
+// addresses, ports, the IPv4 ID and TCP sequence numbers (or the payload
+// when those are not enough), so a frame that is altered in transit still
+// matches and is counted as modified. TTL, hop limit, checksums and the
+// link layer are ignored, since a routing DUT legitimately changes them.
+// It is safe for concurrent use.
+type Matcher struct {
+	mu        sync.Mutex
+	linkType  layers.LinkType
+	pending   map[uint64][]sent
+	delivered map[uint64]int
+	maxIndex  int
+	latencies []time.Duration
+	report    Report
+}
+
+// NewMatcher returns a Matcher for frames of linkType.
+func NewMatcher(linkType layers.LinkType) *Matcher {
+	return &Matcher{
+		linkType:  linkType,
+		pending:   make(map[uint64][]sent),
+		delivered: make(map[uint64]int),
+	}
+}
+
+// Sent records a frame sent at ts.
+func (m *Matcher) Sent(data []byte, ts time.Time) {
+	ident, content := m.keys(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.report.Sent++
+	m.pending[ident] = append(m.pending[ident], sent{index: m.report.Sent, ts: ts, content: content})
+}
+
+// Received records a frame received at ts.
+func (m *Matcher) Received(data []byte, ts time.Time) {
+	ident, content := m.keys(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &m.report
+	r.Received++
+
+	queue := m.pending[ident]
+	if len(queue) == 0 {
+		if m.delivered[ident] > 0 {
+			r.Duplicated++
+		} else {
+			r.Unrelated++
+		}
+		return
+	}
+	s := queue[0]
+	if len(queue) == 1 {
+		delete(m.pending, ident)
+	} else {
+		m.pending[ident] = queue[1:]
+	}
+	m.delivered[ident]++
+
+	r.Matched++
+	if s.content != content {
+		r.Modified++
+		r.ModifiedIndexes = append(r.ModifiedIndexes, s.index)
+	}
+	if s.index < m.maxIndex {
+		r.Reordered++
+	} else {
+		m.maxIndex = s.index
+	}
+	m.latencies = append(m.latencies, ts.Sub(s.ts))
+}
+
+// Report returns the results so far; sent frames not yet received count
+// as lost.
+func (m *Matcher) Report() *Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.report
+	r.ModifiedIndexes = append([]int(nil), r.ModifiedIndexes...)
+	for _, queue := range m.pending {
+		for _, s := range queue {
+			r.LostIndexes = append(r.LostIndexes, s.index)
+		}
+	}
+	sort.Ints(r.LostIndexes)
+	r.Lost = len(r.LostIndexes)
+	r.Latency = summarise(m.latencies)
+	return &r
+}
+
+func summarise(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	pct := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+	}
+	return Latency{
+		Min:  sorted[0],
+		Mean: sum / time.Duration(len(sorted)),
+		P50:  pct(0.50),
+		P90:  pct(0.90),
+		P99:  pct(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+// keys returns the identity and content hashes of a frame.
+func (m *Matcher) keys(data []byte) (ident, content uint64) {
+	packet := gopacket.NewPacket(data, m.linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	id, body := fnv.New64a(), fnv.New64a()
+
+	var net []byte
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		net = trim(ip.Contents, ip.Payload, int(ip.Length))
+		net[8] = 0               // TTL
+		net[10], net[11] = 0, 0  // header checksum
+		id.Write(net[12:20])     // addresses
+		id.Write([]byte{net[9]}) // protocol
+		id.Write(net[4:6])       // identification
+	case *layers.IPv6:
+		net = trim(ip.Contents, ip.Payload, 40+int(ip.Length))
+		net[7] = 0 // hop limit
+		id.Write(net[8:40])
+		id.Write([]byte{net[6]})
+	default:
+		id.Write(data)
+		body.Write(data)
+		return id.Sum64(), body.Sum64()
+	}
+	body.Write(net)
+
+	switch l4 := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		h := l4.Contents
+		id.Write(h[0:14]) // ports, seq, ack, offset and flags
+	case *layers.UDP:
+		id.Write(l4.Contents[0:4])
+		identifyPayload(id, packet, l4.Payload)
+	default:
+		identifyPayload(id, packet, packet.NetworkLayer().LayerPayload())
+	}
+	return id.Sum64(), body.Sum64()
+}
+
+// identifyPayload adds payload to the identity hash unless an IPv4 ID
+// already tells packets apart.
+func identifyPayload(id hash.Hash64, packet gopacket.Packet, payload []byte) {
+	if _, ok := packet.NetworkLayer().(*layers.IPv4); ok {
+		return
+	}
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(payload)))
+	id.Write(n[:])
+	id.Write(payload)
+}
+
+// trim returns a copy of header+payload cut to length, dropping
+// link-layer padding.
+func trim(header, payload []byte, length int) []byte {
+	b := append(append([]byte(nil), header...), payload...)
+	if length >= len(header) && length < len(b) {
+		b = b[:length]
+	}
+	return b
+}
+
+// Compare matches the packets of a sent capture against a received one,
+// using the capture timestamps for latency.
+func Compare(sentFile, receivedFile string) (*Report, error) {
+	fs, err := pcapio.Open(sentFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", sentFile, err)
+	}
+	defer fs.Close()
+	fr, err := pcapio.Open(receivedFile)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", receivedFile, err)
+	}
+	defer fr.Close()
+	if fs.LinkType() != fr.LinkType() {
+		return nil, fmt.Errorf("link types differ: %v and %v", fs.LinkType(), fr.LinkType())
+	}
+
+	m := NewMatcher(fs.LinkType())
+	if err := feed(fs, m.Sent); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", sentFile, err)
+	}
+	if err := feed(fr, m.Received); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", receivedFile, err)
+	}
+	return m.Report(), nil
+}
+
+func feed(r pcapio.Reader, record func([]byte, time.Time)) error {
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		record(data, ci.Timestamp)
+	}
+}
+
+// Config describes a live verification.
+type Config struct {
+	// PcapFile is replayed on TxInterface and captured on RxInterface.
+	PcapFile    string
+	TxInterface string
+	RxInterface string
+	// Wait is how long to keep capturing after the last packet is sent;
+	// DefaultWait when zero.
+	Wait time.Duration
+}
+
+// Run replays cfg.PcapFile with opts while capturing on cfg.RxInterface
+// and reports how the traffic arrived. Both interfaces must be on this
+// host so send and receive times share a clock.
+func Run(cfg Config, opts *replay.Options, logger *common.Logger) (*Report, error) {
+	wait := cfg.Wait
+	if wait == 0 {
+		wait = DefaultWait
+	}
+
+	rx, err := pcap.OpenLive(cfg.RxInterface, 65535, true, 100*time.Millisecond)
+	if err != nil {
+		return nil, &common.InterfaceError{Interface: cfg.RxInterface, Err: err}
+	}
+	defer rx.Close()
+	if err := rx.SetDirection(pcap.DirectionIn); err != nil {
+		logger.Warn("Could not capture inbound traffic only", "interface", cfg.RxInterface, "error", err)
+	}
+
+	m := NewMatcher(rx.LinkType())
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			data, ci, err := rx.ReadPacketData()
+			if err == pcap.NextErrorTimeoutExpired {
+				continue
+			}
+			if err != nil {
+				logger.Error(fmt.Errorf("error capturing on %s: %w", cfg.RxInterface, err))
+				return
+			}
+			m.Received(data, ci.Timestamp)
+		}
+	}()
+
+	// Record each frame as the last stage before it is written.
+	withRecorder := *opts
+	withRecorder.Stages = append(append([]common.Stage(nil), opts.Stages...), recorder{m})
+	txCfg := &common.CaptureConfig{
+		InterfaceName: cfg.TxInterface,
+		SnapLen:       65535,
+		PcapFile:      cfg.PcapFile,
+	}
+	err = replay.ReplayPacketsWithOptions(txCfg, &withRecorder, logger)
+	if err == nil {
+		time.Sleep(wait)
+	}
+	close(stop)
+	<-done
+	if err != nil {
+		return nil, err
+	}
+	return m.Report(), nil
+}
+
+// recorder is a pass-through stage that timestamps frames as they are sent.
+type recorder struct{ m *Matcher }
+
+func (r recorder) Name() string { return "verify" }
+
+func (r recorder) Process(pkt common.Packet) ([]common.Packet, error) {
+	r.m.Sent(pkt.Data, time.Now())
+	return []common.Packet{pkt}, nil
+}
+
+func (r recorder) Flush() ([]common.Packet, error) { return nil, nil }
+
+func joinInts(v []int) string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = fmt.Sprint(n)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,139 @@
+package verify_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"osi-replay/pkg/verify"
+)
+
+func udpFrame(t *testing.T, id uint16, ttl uint8, payload string) []byte {
+	t.Helper()
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      ttl,
+		Id:       id,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("10.0.0.1"),
+		DstIP:    net.ParseIP("10.0.0.2"),
+	}
+	udp := &layers.UDP{SrcPort: 4000, DstPort: 5000}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMatcher(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	m := verify.NewMatcher(layers.LinkTypeEthernet)
+	for i := 1; i <= 6; i++ {
+		m.Sent(udpFrame(t, uint16(i), 64, "payload"), base)
+	}
+
+	// Forwarded frames have a lower TTL and may be padded; neither counts
+	// as a modification.
+	padded := append(udpFrame(t, 4, 63, "payload"), make([]byte, 10)...)
+	received := [][]byte{
+		udpFrame(t, 1, 63, "payload"),
+		udpFrame(t, 3, 63, "payload"),
+		udpFrame(t, 2, 63, "payload"), // reordered
+		udpFrame(t, 3, 63, "payload"), // duplicate
+		padded,
+		udpFrame(t, 5, 63, "PAYLOAD"), // modified
+		udpFrame(t, 99, 63, "other"),  // unrelated
+	}
+	for i, data := range received {
+		m.Received(data, base.Add(time.Duration(i+1)*time.Millisecond))
+	}
+
+	r := m.Report()
+	got := [8]int{r.Sent, r.Received, r.Matched, r.Lost, r.Reordered, r.Duplicated, r.Modified, r.Unrelated}
+	want := [8]int{6, 7, 5, 1, 1, 1, 1, 1}
+	if got != want {
+		t.Errorf("sent/received/matched/lost/reordered/duplicated/modified/unrelated = %v, want %v", got, want)
+	}
+	if len(r.LostIndexes) != 1 || r.LostIndexes[0] != 6 {
+		t.Errorf("lost indexes = %v, want [6]", r.LostIndexes)
+	}
+	if len(r.ModifiedIndexes) != 1 || r.ModifiedIndexes[0] != 5 {
+		t.Errorf("modified indexes = %v, want [5]", r.ModifiedIndexes)
+	}
+	l := r.Latency
+	if l.Min != time.Millisecond || l.Max != 6*time.Millisecond || l.P50 != 3*time.Millisecond {
+		t.Errorf("latency = %+v", l)
+	}
+	if r.Intact() {
+		t.Error("report should not be intact")
+	}
+
+	var text bytes.Buffer
+	if err := r.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(text.Bytes(), []byte("lost=1")) || !bytes.Contains(text.Bytes(), []byte("Lost: 6")) {
+		t.Errorf("unexpected text report:\n%s", text.String())
+	}
+}
+
+func writePcap(t *testing.T, path string, frames [][]byte, start time.Time) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range frames {
+		ci := gopacket.CaptureInfo{Timestamp: start.Add(time.Duration(i) * time.Millisecond), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	var frames [][]byte
+	for i := 1; i <= 10; i++ {
+		frames = append(frames, udpFrame(t, uint16(i), 64, "data"))
+	}
+	start := time.Unix(1700000000, 0)
+	writePcap(t, filepath.Join(dir, "sent.pcap"), frames, start)
+	writePcap(t, filepath.Join(dir, "recv.pcap"), frames, start.Add(250*time.Microsecond))
+
+	r, err := verify.Compare(filepath.Join(dir, "sent.pcap"), filepath.Join(dir, "recv.pcap"))
+	if err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	if !r.Intact() || r.Matched != 10 {
+		t.Errorf("expected intact delivery, got %+v", r)
+	}
+	if r.Latency.Min != 250*time.Microsecond || r.Latency.Max != 250*time.Microsecond {
+		t.Errorf("latency = %+v", r.Latency)
+	}
+
+	if _, err := verify.Compare(filepath.Join(dir, "missing.pcap"), filepath.Join(dir, "recv.pcap")); err == nil {
+		t.Error("expected error for missing capture")
+	}
+}
@@ -0,0 +1,212 @@
+package vlan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+)
+
+// TPIDs recognised as VLAN tags.
+const (
+	TPIDDot1Q  = uint16(layers.EthernetTypeDot1Q) // 802.1Q customer tag
+	TPIDDot1AD = uint16(layers.EthernetTypeQinQ)  // 802.1ad service tag
+	tpidLegacy = 0x9100                           // pre-standard QinQ
+)
+
+const (
+	typeOffset = 12 // offset of the first EtherType/TPID in an Ethernet frame
+	tagLen     = 4
+)
+
+// Tag is a single 802.1Q or 802.1ad tag.
+type Tag struct {
+	TPID uint16
+	VID  uint16
+	PCP  uint8
+}
+
+// Remap rewrites existing tags whose VLAN ID equals From.
+type Remap struct {
+	From uint16
+	To   uint16
+	// PCP is the new priority (0-7), or -1 to keep the current one.
+	PCP int
+}
+
+// Config describes the tag operations applied to each frame, in the order
+// Pop, Remap, Push.
+type Config struct {
+	// Pop removes this many outermost tags.
+	Pop int
+	// Remap rewrites matching tags that remain after popping.
+	Remap []Remap
+	// Push adds tags, outermost first.
+	Push []Tag
+}
+
+// Tagger is a common.Stage that manipulates VLAN tags in Ethernet frames.
+type Tagger struct {
+	cfg Config
+}
+
+// New validates cfg and returns a Tagger.
+func New(cfg Config) (*Tagger, error) {
+	if cfg.Pop < 0 {
+		return nil, fmt.Errorf("invalid pop count %d", cfg.Pop)
+	}
+	for _, t := range cfg.Push {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range cfg.Remap {
+		if r.To > 0xfff || r.PCP > 7 || r.PCP < -1 {
+			return nil, fmt.Errorf("invalid remap %d -> %d/%d", r.From, r.To, r.PCP)
+		}
+	}
+	return &Tagger{cfg: cfg}, nil
+}
+
+// Name implements common.Stage.
+func (t *Tagger) Name() string { return "vlan" }
+
+// Process implements common.Stage.
+func (t *Tagger) Process(pkt common.Packet) ([]common.Packet, error) {
+	data, err := t.Apply(pkt.Data)
+	if err != nil {
+		return nil, err
+	}
+	pkt.CaptureInfo.Length += len(data) - len(pkt.Data)
+	pkt.CaptureInfo.CaptureLength = len(data)
+	pkt.Data = data
+	return []common.Packet{pkt}, nil
+}
+
+// Flush implements common.Stage.
+func (t *Tagger) Flush() ([]common.Packet, error) { return nil, nil }
+
+// Apply returns a copy of the Ethernet frame data with the configured
+// tag operations applied. Tags that are not popped or remapped are kept.
+func (t *Tagger) Apply(data []byte) ([]byte, error) {
+	if len(data) < typeOffset+2 {
+		return nil, fmt.Errorf("frame too short for Ethernet header: %d bytes", len(data))
+	}
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	for i := 0; i < t.cfg.Pop; i++ {
+		if !isTPID(binary.BigEndian.Uint16(out[typeOffset:])) {
+			break
+		}
+		if len(out) < typeOffset+tagLen+2 {
+			return nil, fmt.Errorf("truncated VLAN tag")
+		}
+		out = append(out[:typeOffset], out[typeOffset+tagLen:]...)
+	}
+
+	if len(t.cfg.Remap) > 0 {
+		for off := typeOffset; off+tagLen+2 <= len(out) && isTPID(binary.BigEndian.Uint16(out[off:])); off += tagLen {
+			tci := binary.BigEndian.Uint16(out[off+2:])
+			for _, r := range t.cfg.Remap {
+				if tci&0x0fff != r.From {
+					continue
+				}
+				tci = tci&0xf000 | r.To
+				if r.PCP >= 0 {
+					tci = tci&0x1fff | uint16(r.PCP)<<13
+				}
+				break
+			}
+			binary.BigEndian.PutUint16(out[off+2:], tci)
+		}
+	}
+
+	if len(t.cfg.Push) > 0 {
+		tags := make([]byte, 0, tagLen*len(t.cfg.Push))
+		for _, tag := range t.cfg.Push {
+			tags = binary.BigEndian.AppendUint16(tags, tag.TPID)
+			tags = binary.BigEndian.AppendUint16(tags, uint16(tag.PCP)<<13|tag.VID)
+		}
+		pushed := make([]byte, 0, len(out)+len(tags))
+		pushed = append(pushed, out[:typeOffset]...)
+		pushed = append(pushed, tags...)
+		out = append(pushed, out[typeOffset:]...)
+	}
+	return out, nil
+}
+
+// ParseTag parses a tag of the form "[ad:]VID[/PCP]", e.g. "100",
+// "100/5" or "ad:200". Tags default to 802.1Q.
+func ParseTag(s string) (Tag, error) {
+	tag := Tag{TPID: TPIDDot1Q}
+	rest := s
+	if strings.HasPrefix(rest, "ad:") {
+		tag.TPID = TPIDDot1AD
+		rest = strings.TrimPrefix(rest, "ad:")
+	} else {
+		rest = strings.TrimPrefix(rest, "q:")
+	}
+	vid, pcp, err := parseVIDPCP(rest)
+	if err != nil {
+		return Tag{}, fmt.Errorf("invalid VLAN tag %q: %w", s, err)
+	}
+	tag.VID = vid
+	if pcp >= 0 {
+		tag.PCP = uint8(pcp)
+	}
+	return tag, tag.validate()
+}
+
+// ParseRemap parses a remapping of the form "FROM=TO[/PCP]", e.g. "100=200/3".
+func ParseRemap(s string) (Remap, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok {
+		return Remap{}, fmt.Errorf("invalid VLAN remap %q: expected FROM=TO[/PCP]", s)
+	}
+	f, err := strconv.ParseUint(from, 10, 12)
+	if err != nil {
+		return Remap{}, fmt.Errorf("invalid VLAN remap %q: %w", s, err)
+	}
+	vid, pcp, err := parseVIDPCP(to)
+	if err != nil {
+		return Remap{}, fmt.Errorf("invalid VLAN remap %q: %w", s, err)
+	}
+	return Remap{From: uint16(f), To: vid, PCP: pcp}, nil
+}
+
+// parseVIDPCP parses "VID[/PCP]"; a missing PCP is returned as -1.
+func parseVIDPCP(s string) (uint16, int, error) {
+	v, p, hasPCP := strings.Cut(s, "/")
+	vid, err := strconv.ParseUint(v, 10, 12)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad VLAN ID %q", v)
+	}
+	pcp := -1
+	if hasPCP {
+		n, err := strconv.ParseUint(p, 10, 3)
+		if err != nil {
+			return 0, 0, fmt.Errorf("bad priority %q", p)
+		}
+		pcp = int(n)
+	}
+	return uint16(vid), pcp, nil
+}
+
+func (t Tag) validate() error {
+	if !isTPID(t.TPID) {
+		return fmt.Errorf("unsupported TPID %#04x", t.TPID)
+	}
+	if t.VID > 0xfff || t.PCP > 7 {
+		return fmt.Errorf("invalid VLAN tag %d/%d", t.VID, t.PCP)
+	}
+	return nil
+}
+
+func isTPID(v uint16) bool {
+	return v == TPIDDot1Q || v == TPIDDot1AD || v == tpidLegacy
+}
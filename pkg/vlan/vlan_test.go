@@ -0,0 +1,118 @@
+package vlan_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/vlan"
+)
+
+func buildFrame(t *testing.T, tags ...*layers.Dot1Q) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		FixLengths:       true,
+		ComputeChecksums: true,
+	}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ls := []gopacket.SerializableLayer{eth}
+	for i, tag := range tags {
+		if i == 0 {
+			eth.EthernetType = layers.EthernetTypeDot1Q
+		}
+		tag.Type = layers.EthernetTypeIPv4
+		if i > 0 {
+			tags[i-1].Type = layers.EthernetTypeDot1Q
+		}
+		ls = append(ls, tag)
+	}
+	ls = append(ls, &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("192.168.1.100"),
+		DstIP:    net.ParseIP("192.168.1.200"),
+	})
+
+	if err := gopacket.SerializeLayers(buf, opts, ls...); err != nil {
+		t.Fatalf("Error serializing layers: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decodeTags(data []byte) ([]*layers.Dot1Q, *layers.Ethernet) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	var tags []*layers.Dot1Q
+	for _, l := range packet.Layers() {
+		if tag, ok := l.(*layers.Dot1Q); ok {
+			tags = append(tags, tag)
+		}
+	}
+	eth, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	return tags, eth
+}
+
+func TestApply_PushQinQ(t *testing.T) {
+	outer, _ := vlan.ParseTag("ad:300/2")
+	inner, _ := vlan.ParseTag("100")
+	tagger, err := vlan.New(vlan.Config{Push: []vlan.Tag{outer, inner}})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	out, err := tagger.Apply(buildFrame(t))
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	tags, eth := decodeTags(out)
+	if eth.EthernetType != layers.EthernetTypeQinQ {
+		t.Errorf("Expected outer TPID 0x88a8, got %v", eth.EthernetType)
+	}
+	if len(tags) != 2 || tags[0].VLANIdentifier != 300 || tags[0].Priority != 2 || tags[1].VLANIdentifier != 100 {
+		t.Fatalf("Unexpected tags after push: %+v", tags)
+	}
+}
+
+func TestApply_PopAndRemap(t *testing.T) {
+	remap, err := vlan.ParseRemap("100=200/6")
+	if err != nil {
+		t.Fatalf("ParseRemap returned error: %v", err)
+	}
+	tagger, err := vlan.New(vlan.Config{Pop: 1, Remap: []vlan.Remap{remap}})
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	in := buildFrame(t, &layers.Dot1Q{VLANIdentifier: 10}, &layers.Dot1Q{VLANIdentifier: 100, Priority: 1})
+	out, err := tagger.Apply(in)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	tags, _ := decodeTags(out)
+	if len(tags) != 1 {
+		t.Fatalf("Expected 1 tag after pop, got %d", len(tags))
+	}
+	if tags[0].VLANIdentifier != 200 || tags[0].Priority != 6 {
+		t.Errorf("Expected remapped tag 200/6, got %d/%d", tags[0].VLANIdentifier, tags[0].Priority)
+	}
+	if len(out) != len(in)-4 {
+		t.Errorf("Expected frame to shrink by 4 bytes, got %d -> %d", len(in), len(out))
+	}
+}
+
+func TestParseTag_Invalid(t *testing.T) {
+	for _, s := range []string{"", "4096", "100/8", "ad:"} {
+		if _, err := vlan.ParseTag(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
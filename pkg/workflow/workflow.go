@@ -0,0 +1,573 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"gopkg.in/yaml.v3"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/dedup"
+	"osi-replay/pkg/fragment"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/rewriter"
+	"osi-replay/pkg/sanitizer"
+	"osi-replay/pkg/slice"
+	"osi-replay/pkg/timeshift"
+	"osi-replay/pkg/tunnel"
+	"osi-replay/pkg/vlan"
+)
+
+// Workflow describes a pipeline: packets are read from Source, passed
+// through Stages in order, and written to every sink. For example:
+//
+//	source:
+//	  files: [monday.pcap, tuesday.pcap]
+//	stages:
+//	  - sanitize: {}
+//	  - rewrite:
+//	      src_ip: {192.168.1.100: 10.0.0.5}
+//	  - slice: {range: 1-1000}
+//	sinks:
+//	  - file: out.pcapng
+//	  - interface: eth1
+type Workflow struct {
+	Name   string  `yaml:"name"`
+	Source Source  `yaml:"source"`
+	Stages []Stage `yaml:"stages"`
+	Sinks  []Sink  `yaml:"sinks"`
+}
+
+// Source is either a live interface or a list of capture files read one
+// after another. Packet indexes run on across files.
+type Source struct {
+	Interface string   `yaml:"interface"`
+	Files     []string `yaml:"files"`
+	// Filter is a BPF expression applied to a live interface.
+	Filter string `yaml:"filter"`
+	// Count and Duration, if set, stop a live source after that many
+	// packets or that long.
+	Count    int           `yaml:"count"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Stage configures one processing step; exactly one field must be set.
+type Stage struct {
+	Sanitize  *struct{}  `yaml:"sanitize"`
+	Rewrite   *Rewrite   `yaml:"rewrite"`
+	Slice     *Slice     `yaml:"slice"`
+	Dedup     *Dedup     `yaml:"dedup"`
+	Defrag    *struct{}  `yaml:"defrag"`
+	Fragment  *Fragment  `yaml:"fragment"`
+	Decap     *Decap     `yaml:"decap"`
+	Encap     *Encap     `yaml:"encap"`
+	VLAN      *VLAN      `yaml:"vlan"`
+	Timeshift *Timeshift `yaml:"timeshift"`
+}
+
+// Rewrite maps addresses, as rewriter.RewriteConfig.
+type Rewrite struct {
+	SrcIP  map[string]string `yaml:"src_ip"`
+	DstIP  map[string]string `yaml:"dst_ip"`
+	SrcMAC map[string]string `yaml:"src_mac"`
+	DstMAC map[string]string `yaml:"dst_mac"`
+}
+
+// Slice selects packets by source index, time window or sampling, with
+// the same syntax as the slice command's flags.
+type Slice struct {
+	Range string `yaml:"range"`
+	From  string `yaml:"from"`
+	To    string `yaml:"to"`
+	Every int    `yaml:"every"`
+}
+
+// Dedup drops frames repeated within Window.
+type Dedup struct {
+	Window time.Duration `yaml:"window"`
+}
+
+// Fragment splits IP packets larger than MTU.
+type Fragment struct {
+	MTU int `yaml:"mtu"`
+}
+
+// Decap strips tunnel headers; KeepID is "", "vlan" or "comment".
+type Decap struct {
+	KeepID string `yaml:"keep_id"`
+}
+
+// Encap wraps frames in a tunnel, as tunnel.EncapConfig.
+type Encap struct {
+	Kind    string `yaml:"kind"`
+	Src     string `yaml:"src"`
+	Dst     string `yaml:"dst"`
+	VNI     uint32 `yaml:"vni"`
+	SrcPort uint16 `yaml:"src_port"`
+	DstPort uint16 `yaml:"dst_port"`
+}
+
+// VLAN pops, remaps and pushes tags using the transform flag syntax.
+type VLAN struct {
+	Pop   int      `yaml:"pop"`
+	Remap []string `yaml:"remap"`
+	Push  []string `yaml:"push"`
+}
+
+// Timeshift adjusts timestamps, as timeshift.Config.
+type Timeshift struct {
+	Offset time.Duration `yaml:"offset"`
+	Rebase string        `yaml:"rebase"`
+	Scale  float64       `yaml:"scale"`
+	Random time.Duration `yaml:"random"`
+}
+
+// Sink is a capture file (pcapng when it ends in ".pcapng") or an
+// interface to send on; exactly one field must be set.
+type Sink struct {
+	File      string `yaml:"file"`
+	Interface string `yaml:"interface"`
+}
+
+// Parse parses and validates a YAML workflow. Unknown keys are errors, so
+// a misspelled option is not silently ignored.
+func Parse(data []byte) (*Workflow, error) {
+	var w Workflow
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&w); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing workflow: %w", err)
+	}
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Load reads the workflow at path.
+func Load(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading workflow: %w", err)
+	}
+	return Parse(data)
+}
+
+// Validate checks the source and sinks and builds each stage once to
+// report configuration errors before anything is opened.
+func (w *Workflow) Validate() error {
+	src := w.Source
+	if (src.Interface == "") == (len(src.Files) == 0) {
+		return fmt.Errorf("workflow source needs exactly one of interface or files")
+	}
+	if len(w.Sinks) == 0 {
+		return fmt.Errorf("workflow needs at least one sink")
+	}
+	var comments bool
+	for _, st := range w.Stages {
+		if st.Decap != nil && st.Decap.KeepID == tunnel.KeepComment {
+			comments = true
+		}
+	}
+	for i, s := range w.Sinks {
+		if (s.File == "") == (s.Interface == "") {
+			return fmt.Errorf("sink %d needs exactly one of file or interface", i+1)
+		}
+		if comments && s.File != "" && !pcapio.IsPcapng(s.File) {
+			return fmt.Errorf("sink %d: decap keep_id comment needs a .pcapng file; pcap has no packet comments", i+1)
+		}
+	}
+	_, err := w.BuildStages()
+	return err
+}
+
+// BuildStages returns fresh pipeline stages for w.Stages.
+func (w *Workflow) BuildStages() ([]common.Stage, error) {
+	stages := make([]common.Stage, 0, len(w.Stages))
+	for i, spec := range w.Stages {
+		st, err := spec.build()
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i+1, err)
+		}
+		stages = append(stages, st)
+	}
+	return stages, nil
+}
+
+func (s *Stage) build() (common.Stage, error) {
+	var (
+		st  common.Stage
+		set int
+		err error
+	)
+	if s.Sanitize != nil {
+		set++
+		st = sanitizer.NewFilter()
+	}
+	if r := s.Rewrite; r != nil {
+		set++
+		st = rewriter.New(&rewriter.RewriteConfig{
+			IPMapSrc:  r.SrcIP,
+			IPMapDst:  r.DstIP,
+			MACMapSrc: r.SrcMAC,
+			MACMapDst: r.DstMAC,
+		})
+	}
+	if sl := s.Slice; sl != nil {
+		set++
+		var sel *slice.Selector
+		if sel, err = slice.NewSelector(sl.Range, sl.From, sl.To, sl.Every); err == nil {
+			if sel == nil {
+				err = fmt.Errorf("slice needs at least one of range, from, to or every")
+			}
+			st = sel
+		}
+	}
+	if d := s.Dedup; d != nil {
+		set++
+		st = dedup.New(d.Window)
+	}
+	if s.Defrag != nil {
+		set++
+		st = fragment.NewDefragmenter(0)
+	}
+	if f := s.Fragment; f != nil {
+		set++
+		st, err = fragment.NewFragmenter(f.MTU)
+	}
+	if d := s.Decap; d != nil {
+		set++
+		st, err = tunnel.NewDecapsulator(tunnel.DecapConfig{KeepID: d.KeepID})
+	}
+	if e := s.Encap; e != nil {
+		set++
+		st, err = tunnel.NewEncapsulator(tunnel.EncapConfig{
+			Kind:    e.Kind,
+			SrcIP:   net.ParseIP(e.Src),
+			DstIP:   net.ParseIP(e.Dst),
+			VNI:     e.VNI,
+			SrcPort: e.SrcPort,
+			DstPort: e.DstPort,
+		})
+	}
+	if v := s.VLAN; v != nil {
+		set++
+		st, err = v.build()
+	}
+	if t := s.Timeshift; t != nil {
+		set++
+		st, err = t.build()
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("each stage needs exactly one kind, got %d", set)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (v *VLAN) build() (common.Stage, error) {
+	cfg := vlan.Config{Pop: v.Pop}
+	for _, s := range v.Remap {
+		r, err := vlan.ParseRemap(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Remap = append(cfg.Remap, r)
+	}
+	for _, s := range v.Push {
+		tag, err := vlan.ParseTag(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Push = append(cfg.Push, tag)
+	}
+	return vlan.New(cfg)
+}
+
+func (t *Timeshift) build() (common.Stage, error) {
+	cfg := timeshift.Config{Offset: t.Offset, Scale: t.Scale, RandomMax: t.Random}
+	if t.Rebase != "" {
+		ts, err := time.Parse(time.RFC3339Nano, t.Rebase)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rebase time %q: %w", t.Rebase, err)
+		}
+		cfg.RebaseTo = ts
+	}
+	return timeshift.New(cfg)
+}
+
+// Result counts what a run did. Dropped is keyed by stage name.
+type Result struct {
+	Read    int            `json:"read"`
+	Written int            `json:"written"`
+	Dropped map[string]int `json:"dropped"`
+}
+
+// Run executes the workflow as a single streaming pass: each packet goes
+// through every stage and on to the sinks before the next is read, so no
+// intermediate files are written. A live source runs until ctx is
+// cancelled or its Count or Duration is reached. Packets a stage fails on
+// are logged and skipped.
+func (w *Workflow) Run(ctx context.Context, logger *common.Logger) (*Result, error) {
+	stages, err := w.BuildStages()
+	if err != nil {
+		return nil, err
+	}
+	src, err := w.openSource(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer src.close()
+
+	var sinks []sink
+	defer func() {
+		for _, s := range sinks {
+			if err := s.close(); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+	for _, spec := range w.Sinks {
+		s, err := openSink(spec, src.linkType())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	res := &Result{Dropped: make(map[string]int)}
+	dropped := func(st common.Stage, pkt common.Packet) {
+		logger.Debug("Stage dropped packet", "stage", st.Name(), "index", pkt.Index)
+		res.Dropped[st.Name()]++
+	}
+	write := func(pkts []common.Packet) {
+		for _, pkt := range pkts {
+			for _, s := range sinks {
+				if err := s.write(pkt); err != nil {
+					logger.Error(fmt.Errorf("error writing packet %d: %w", pkt.Index, err))
+				}
+			}
+			res.Written++
+		}
+	}
+
+	for {
+		pkt, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		res.Read++
+		out, err := common.RunStages(stages, []common.Packet{pkt}, dropped)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		write(out)
+		if res.Read%10000 == 0 {
+			logger.Info("Workflow progress", "packets", res.Read)
+		}
+	}
+
+	out, err := common.FlushStages(stages, dropped)
+	if err != nil {
+		logger.Error(err)
+	}
+	write(out)
+
+	logger.Info("Workflow complete", "read", res.Read, "written", res.Written)
+	for name, n := range res.Dropped {
+		logger.Info("Stage dropped packets", "stage", name, "packets", n)
+	}
+	return res, nil
+}
+
+// source yields packets until io.EOF. Run logs other errors and asks for
+// the next packet, so a source must not return the same failure forever.
+type source interface {
+	next() (common.Packet, error)
+	linkType() layers.LinkType
+	close()
+}
+
+func (w *Workflow) openSource(ctx context.Context) (source, error) {
+	if w.Source.Interface != "" {
+		return openLive(ctx, w.Source)
+	}
+	fs := &fileSource{paths: w.Source.Files, ctx: ctx}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// fileSource reads capture files in order. A read error ends the file it
+// occurred in, since later reads would fail the same way.
+type fileSource struct {
+	ctx    context.Context
+	paths  []string
+	cur    *pcapio.File
+	name   string
+	link   layers.LinkType
+	opened bool
+	index  int
+}
+
+func (s *fileSource) open() error {
+	path := s.paths[0]
+	s.paths = s.paths[1:]
+	f, err := pcapio.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	if s.opened && f.LinkType() != s.link {
+		f.Close()
+		return fmt.Errorf("%s has link type %v, expected %v", path, f.LinkType(), s.link)
+	}
+	s.cur, s.name, s.link, s.opened = f, path, f.LinkType(), true
+	return nil
+}
+
+func (s *fileSource) next() (common.Packet, error) {
+	for {
+		if s.ctx.Err() != nil {
+			return common.Packet{}, io.EOF
+		}
+		if s.cur == nil {
+			if len(s.paths) == 0 {
+				return common.Packet{}, io.EOF
+			}
+			if err := s.open(); err != nil {
+				s.paths = nil
+				return common.Packet{}, err
+			}
+		}
+		data, ci, err := s.cur.ReadPacketData()
+		if err != nil {
+			s.cur.Close()
+			s.cur = nil
+			if err == io.EOF {
+				continue
+			}
+			return common.Packet{}, fmt.Errorf("error reading %s: %w", s.name, err)
+		}
+		s.index++
+		return common.Packet{Data: data, CaptureInfo: ci, Index: s.index}, nil
+	}
+}
+
+func (s *fileSource) linkType() layers.LinkType { return s.link }
+
+func (s *fileSource) close() {
+	if s.cur != nil {
+		s.cur.Close()
+	}
+}
+
+// liveSource captures from an interface until the first capture error.
+type liveSource struct {
+	ctx      context.Context
+	handle   *pcap.Handle
+	count    int
+	deadline time.Time
+	index    int
+	failed   bool
+}
+
+func openLive(ctx context.Context, src Source) (*liveSource, error) {
+	handle, err := pcap.OpenLive(src.Interface, 65535, true, 100*time.Millisecond)
+	if err != nil {
+		return nil, &common.InterfaceError{Interface: src.Interface, Err: err}
+	}
+	if src.Filter != "" {
+		if err := handle.SetBPFFilter(src.Filter); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("invalid filter %q: %w", src.Filter, err)
+		}
+	}
+	ls := &liveSource{ctx: ctx, handle: handle, count: src.Count}
+	if src.Duration > 0 {
+		ls.deadline = time.Now().Add(src.Duration)
+	}
+	return ls, nil
+}
+
+func (s *liveSource) next() (common.Packet, error) {
+	for {
+		if s.failed || s.ctx.Err() != nil || (s.count > 0 && s.index >= s.count) ||
+			(!s.deadline.IsZero() && time.Now().After(s.deadline)) {
+			return common.Packet{}, io.EOF
+		}
+		data, ci, err := s.handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		if err != nil {
+			s.failed = true
+			return common.Packet{}, fmt.Errorf("error capturing packet: %w", err)
+		}
+		s.index++
+		return common.Packet{Data: data, CaptureInfo: ci, Index: s.index}, nil
+	}
+}
+
+func (s *liveSource) linkType() layers.LinkType { return s.handle.LinkType() }
+
+func (s *liveSource) close() { s.handle.Close() }
+
+type sink interface {
+	write(pkt common.Packet) error
+	close() error
+}
+
+func openSink(spec Sink, linkType layers.LinkType) (sink, error) {
+	if spec.Interface != "" {
+		handle, err := pcap.OpenLive(spec.Interface, 65535, false, pcap.BlockForever)
+		if err != nil {
+			return nil, &common.InterfaceError{Interface: spec.Interface, Err: err}
+		}
+		return &ifaceSink{handle: handle}, nil
+	}
+	f, err := os.Create(spec.File)
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %w", err)
+	}
+	writer, err := pcapio.NewWriter(f, spec.File, 65536, linkType)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileSink{f: f, writer: writer}, nil
+}
+
+type fileSink struct {
+	f      *os.File
+	writer pcapio.PacketWriter
+}
+
+func (s *fileSink) write(pkt common.Packet) error { return pcapio.WritePacket(s.writer, pkt) }
+
+func (s *fileSink) close() error { return s.f.Close() }
+
+type ifaceSink struct {
+	handle *pcap.Handle
+}
+
+func (s *ifaceSink) write(pkt common.Packet) error { return s.handle.WritePacketData(pkt.Data) }
+
+func (s *ifaceSink) close() error {
+	s.handle.Close()
+	return nil
+}
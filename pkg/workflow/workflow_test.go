@@ -0,0 +1,168 @@
+package workflow_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"osi-replay/pkg/common"
+	"osi-replay/pkg/craft"
+	"osi-replay/pkg/pcapio"
+	"osi-replay/pkg/workflow"
+)
+
+// writeCapture writes one UDP packet per source address.
+func writeCapture(t *testing.T, path string, srcs ...string) {
+	t.Helper()
+	var sb strings.Builder
+	sb.WriteString("packets:\n")
+	for _, src := range srcs {
+		fmt.Fprintf(&sb, "  - ethernet: {}\n    ipv4: {src: %s, dst: 192.168.1.200}\n    udp: {src: 1000, dst: 9000}\n    payload: hi\n", src)
+	}
+	spec, err := craft.Parse([]byte(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := craft.WriteFile(spec, path, common.NewLogger("test")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readSources(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := pcapio.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var srcs []string
+	for {
+		data, _, err := f.ReadPacketData()
+		if err == io.EOF {
+			return srcs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		srcs = append(srcs, p.Layer(layers.LayerTypeIPv4).(*layers.IPv4).SrcIP.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	in1 := filepath.Join(dir, "in1.pcap")
+	in2 := filepath.Join(dir, "in2.pcap")
+	writeCapture(t, in1, "192.168.1.100", "10.0.0.1", "192.168.1.7")
+	writeCapture(t, in2, "192.168.1.8", "192.168.1.100")
+	out1 := filepath.Join(dir, "out.pcap")
+	out2 := filepath.Join(dir, "out.pcapng")
+
+	yaml := fmt.Sprintf(`
+source:
+  files: [%s, %s]
+stages:
+  - sanitize: {}
+  - rewrite:
+      src_ip: {192.168.1.100: 10.0.0.5}
+  - slice: {range: 1-4}
+sinks:
+  - file: %s
+  - file: %s
+`, in1, in2, out1, out2)
+	w, err := workflow.Parse([]byte(yaml))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	res, err := w.Run(context.Background(), common.NewLogger("test"))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Packet 2 (10.0.0.1) is sanitized away and packet 5 is outside the
+	// slice; indexes run across both files.
+	want := []string{"10.0.0.5", "192.168.1.7", "192.168.1.8"}
+	for _, out := range []string{out1, out2} {
+		got := readSources(t, out)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: sources %v, want %v", filepath.Base(out), got, want)
+		}
+	}
+	if res.Read != 5 || res.Written != 3 || res.Dropped["sanitize"] != 1 || res.Dropped["slice"] != 1 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestRun_DebugLogsDrops(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.pcap")
+	writeCapture(t, in, "192.168.1.1", "10.0.0.1")
+	w, err := workflow.Parse([]byte(fmt.Sprintf("source: {files: [%s]}\nstages: [{sanitize: {}}]\nsinks: [{file: %s}]\n",
+		in, filepath.Join(dir, "out.pcap"))))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := common.SetOutput(&buf, common.LogJSON); err != nil {
+		t.Fatal(err)
+	}
+	defer common.SetOutput(os.Stderr, common.LogText)
+	if err := common.SetLevel(common.LevelDebug); err != nil {
+		t.Fatal(err)
+	}
+	defer common.SetLevel(common.LevelInfo)
+
+	if _, err := w.Run(context.Background(), common.NewLogger("test").With("file", "wf.yaml")); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", line, err)
+		}
+		if m["msg"] == "Stage dropped packet" {
+			found = true
+			if m["level"] != "DEBUG" || m["stage"] != "sanitize" || m["index"] != 2.0 || m["file"] != "wf.yaml" {
+				t.Errorf("Unexpected drop line: %v", m)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected a debug line for the dropped packet:\n%s", buf.String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]string{
+		"no source":        "sinks: [{file: out.pcap}]",
+		"two sources":      "source: {interface: eth0, files: [a.pcap]}\nsinks: [{file: out.pcap}]",
+		"no sinks":         "source: {files: [a.pcap]}",
+		"empty sink":       "source: {files: [a.pcap]}\nsinks: [{}]",
+		"two kinds":        "source: {files: [a.pcap]}\nstages: [{sanitize: {}, defrag: {}}]\nsinks: [{file: out.pcap}]",
+		"bad slice":        "source: {files: [a.pcap]}\nstages: [{slice: {}}]\nsinks: [{file: out.pcap}]",
+		"bad fragment":     "source: {files: [a.pcap]}\nstages: [{fragment: {mtu: 10}}]\nsinks: [{file: out.pcap}]",
+		"bad vlan":         "source: {files: [a.pcap]}\nstages: [{vlan: {push: [abc]}}]\nsinks: [{file: out.pcap}]",
+		"invalid yaml":     "source: [",
+		"unknown stage":    "source: {files: [a.pcap]}\nstages: [{nope: {}}]\nsinks: [{file: out.pcap}]",
+		"comment to pcap":  "source: {files: [a.pcap]}\nstages: [{decap: {keep_id: comment}}]\nsinks: [{file: out.pcap}]",
+		"misspelled key":   "source: {files: [a.pcap]}\nstages: [{rewrite: {srcip: {10.0.0.1: 10.0.0.2}}}]\nsinks: [{file: out.pcap}]",
+		"misspelled decap": "source: {files: [a.pcap]}\nstages: [{decap: {keep-id: vlan}}]\nsinks: [{file: out.pcap}]",
+		"unknown top key":  "source: {files: [a.pcap]}\nsink: [{file: out.pcap}]\nsinks: [{file: out.pcap}]",
+	}
+	for name, doc := range cases {
+		if _, err := workflow.Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}